@@ -0,0 +1,28 @@
+// Команда fakeshikimori - локальный OAuth-провайдер, повторяющий API Shikimori,
+// для ручной и интеграционной проверки входа без настоящего приложения.
+//
+//	go run ./cmd/fakeshikimori -addr :9090
+//	SHIKIMORI_OAUTH_URL=http://localhost:9090 go run ./cmd/server
+//
+// Каталог аниме фейкового /api/graphql пуст: его заполняют тесты через AddAnime.
+//
+// Сам провайдер - internal/shikimori/shikimoritest, его же используют тесты.
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori/shikimoritest"
+)
+
+func main() {
+	addr := flag.String("addr", ":9090", "listen address")
+	ttl := flag.Duration("token-ttl", 24*time.Hour, "access token lifetime")
+	flag.Parse()
+
+	log.Printf("Fake Shikimori listening on %s", *addr)
+	log.Fatal(http.ListenAndServe(*addr, shikimoritest.NewProvider(*ttl)))
+}
@@ -0,0 +1,86 @@
+// jwtkeys генерирует ключ подписи access-токенов для каталога JWT_KEYS_DIR.
+//
+//	go run ./cmd/jwtkeys -dir ./keys                 # Ed25519, kid = текущая дата
+//	go run ./cmd/jwtkeys -dir ./keys -alg RS256      # RSA 3072
+//
+// Создает <kid>.pem (приватный) и <kid>.pub.pem (публичный). Порядок ротации
+// описан в internal/auth/keys.go.
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func main() {
+	dir := flag.String("dir", os.Getenv("JWT_KEYS_DIR"), "каталог ключей")
+	kid := flag.String("kid", time.Now().UTC().Format("2006-01-02T150405"), "идентификатор ключа")
+	alg := flag.String("alg", "EdDSA", "алгоритм: EdDSA или RS256")
+	flag.Parse()
+
+	if *dir == "" {
+		log.Fatal("укажите -dir или JWT_KEYS_DIR")
+	}
+
+	var (
+		private crypto.PrivateKey
+		public  crypto.PublicKey
+	)
+	switch *alg {
+	case "EdDSA":
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			log.Fatal(err)
+		}
+		private, public = priv, pub
+	case "RS256":
+		priv, err := rsa.GenerateKey(rand.Reader, 3072)
+		if err != nil {
+			log.Fatal(err)
+		}
+		private, public = priv, &priv.PublicKey
+	default:
+		log.Fatalf("неизвестный алгоритм %q", *alg)
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		log.Fatal(err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(public)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := os.MkdirAll(*dir, 0o700); err != nil {
+		log.Fatal(err)
+	}
+	privatePath := filepath.Join(*dir, *kid+".pem")
+	publicPath := filepath.Join(*dir, *kid+".pub.pem")
+	writePEM(privatePath, "PRIVATE KEY", privateDER, 0o600)
+	writePEM(publicPath, "PUBLIC KEY", publicDER, 0o644)
+
+	fmt.Printf("kid: %s\n%s\n%s\n", *kid, privatePath, publicPath)
+}
+
+func writePEM(path, blockType string, der []byte, perm os.FileMode) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := pem.Encode(f, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		log.Fatal(err)
+	}
+}
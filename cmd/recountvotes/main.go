@@ -0,0 +1,28 @@
+// recountvotes пересобирает счетчики голосов комментариев и карму
+// пользователей из таблицы comment_votes, если они разошлись.
+//
+//	go run ./cmd/recountvotes
+//
+// Использует те же переменные окружения, что и сервер (DB_DSN).
+package main
+
+import (
+	"log"
+
+	"github.com/Zipklas/anime-site-backend/internal/comment"
+	"github.com/Zipklas/anime-site-backend/pkg/database"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	_ = godotenv.Load()
+
+	db := database.InitPostgres()
+	result, err := comment.NewRepository(db).RecountVotes()
+	if err != nil {
+		log.Fatal("Failed to recount votes:", err)
+	}
+	log.Printf("self votes removed: %d, comments fixed: %d, users fixed: %d",
+		result.SelfVotesRemoved, result.CommentsFixed, result.UsersFixed)
+}
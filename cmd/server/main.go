@@ -1,19 +1,30 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/auth"
 	"github.com/Zipklas/anime-site-backend/internal/comment"
 	"github.com/Zipklas/anime-site-backend/internal/kodik"
+	"github.com/Zipklas/anime-site-backend/internal/markup"
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/Zipklas/anime-site-backend/internal/notification"
 	"github.com/Zipklas/anime-site-backend/internal/user"
 	"github.com/Zipklas/anime-site-backend/pkg/database"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
 
 	"github.com/Zipklas/anime-site-backend/internal/shikimori"
 
 	"github.com/joho/godotenv"
-	echojwt "github.com/labstack/echo-jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
@@ -24,12 +35,45 @@ func main() {
 	// Инициализация базы данных
 	db := database.InitPostgres()
 
+	// SIGINT/SIGTERM останавливают сервер и фоновые задачи
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	var background sync.WaitGroup
+
 	// Инициализация сервиса Shikimori
 	shikimoriService := shikimori.NewService()
 	shikimoriHandler := shikimori.NewHandler(shikimoriService)
 	userRepo := user.NewRepository(db)
-	userService := user.NewService(userRepo, shikimoriService)
+	tokenManager, err := auth.NewManagerFromEnv()
+	if err != nil {
+		log.Fatal("Failed to load JWT keys:", err)
+	}
+	// Перечитываем ключи подписи по SIGHUP (ротация без перезапуска)
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := tokenManager.Keys().Reload(); err != nil {
+				log.Printf("Failed to reload JWT keys: %v", err)
+				continue
+			}
+			log.Println("JWT keys reloaded")
+		}
+	}()
+	mailSender := mailer.NewFromEnv()
+	userService := user.NewService(userRepo, shikimoriService, mailSender, tokenManager, shikimori.NewOAuthClientFromEnv())
 	userHandler := user.NewHandler(userService)
+	authMiddleware := auth.NewMiddleware(tokenManager, userService)
+	if failed, err := userService.FailInterruptedImports(); err != nil {
+		log.Printf("Failed to finish interrupted list imports: %v", err)
+	} else if failed > 0 {
+		log.Printf("Marked %d interrupted list imports as failed", failed)
+	}
+	if emails := os.Getenv("ADMIN_EMAILS"); emails != "" {
+		if err := userService.PromoteAdmins(strings.Split(emails, ",")); err != nil {
+			log.Printf("Failed to promote admins: %v", err)
+		}
+	}
 	// Создание нового экземпляра Echo
 	e := echo.New()
 
@@ -42,27 +86,94 @@ func main() {
 	// Роуты для регистрации и логина
 	e.POST("/register", userHandler.Register)
 	e.POST("/login", userHandler.Login)
+	e.POST("/auth/refresh", userHandler.Refresh)
+	e.GET("/.well-known/jwks.json", auth.JWKSHandler(tokenManager.Keys()))
+	e.POST("/auth/verify-email", userHandler.VerifyEmail)
+	e.POST("/auth/resend-verification", userHandler.ResendVerification)
+	e.POST("/auth/forgot-password", userHandler.ForgotPassword)
+	e.POST("/auth/reset-password", userHandler.ResetPassword)
+	e.GET("/auth/shikimori/url", userHandler.ShikimoriLoginURL)
+	e.POST("/auth/shikimori/callback", userHandler.ShikimoriLogin)
+	e.POST("/logout", userHandler.Logout, authMiddleware.Required)
+	e.POST("/logout-all", userHandler.LogoutAll, authMiddleware.Required)
 	e.POST("/api/shikimori/search", shikimoriHandler.SearchAnime)
 	e.GET("/api/shikimori/top", shikimoriHandler.GetTopAnime)
 	e.GET("/api/shikimori/anime/:id", shikimoriHandler.GetAnimeByID)
 
 	commentRepo := comment.NewRepository(db)
-	commentService := comment.NewService(commentRepo)
+	notificationService := notification.NewService(notification.NewRepository(db))
+	notificationHandler := notification.NewHandler(notificationService)
+	// Об автоматическом одобрении комментария сообщаем, только если
+	// MODERATION_NOTIFY_APPROVED=true
+	notifyApproved := os.Getenv("MODERATION_NOTIFY_APPROVED") == "true"
+	commentNotifier := comment.Notifiers{
+		comment.NewInAppNotifier(notificationService, markup.NewParserFromEnv(), notifyApproved),
+		comment.NewMailNotifier(commentRepo, mailSender, notifyApproved),
+	}
+	moderator, err := moderation.NewFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure moderation:", err)
+	}
+	commentService := comment.NewService(commentRepo, moderator, commentNotifier)
 	commentHandler := comment.NewHandler(commentService)
+	// Воркеры асинхронной модерации (MODERATION_MODE=async)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		commentService.RunModerationWorkers(ctx)
+	}()
+	// Раз в час стираем текст давно удаленных комментариев
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			purged, err := commentService.PurgeDeletedComments(ctx)
+			if err != nil {
+				log.Printf("Failed to purge deleted comments: %v", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d deleted comments", purged)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
 
-	// Добавляем роуты
+	// Добавляем роуты. Читать комментарии можно без входа,
+	// остальные обработчики сами требуют пользователя.
 	commentGroup := e.Group("/api/comments")
-	commentGroup.Use(echojwt.WithConfig(echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-	}))
+	commentGroup.Use(authMiddleware.Optional)
 
 	commentGroup.POST("/:anime_id", commentHandler.CreateComment)
 	commentGroup.GET("/:anime_id", commentHandler.GetComments)
+	commentGroup.GET("/:anime_id/episodes", commentHandler.GetEpisodeCounts)
+	commentGroup.GET("/:comment_id/replies", commentHandler.GetReplies)
 	commentGroup.DELETE("/:comment_id", commentHandler.DeleteComment)
 	commentGroup.PUT("/:comment_id", commentHandler.UpdateComment)
+	commentGroup.GET("/:comment_id/history", commentHandler.GetCommentHistory, auth.RequireRole(auth.RoleModerator))
 	// Добавляем после других comment роутов
 	commentGroup.PUT("/:comment_id/vote", commentHandler.VoteComment)
 	commentGroup.DELETE("/:comment_id/vote", commentHandler.RemoveVote)
+	commentGroup.POST("/:comment_id/report", commentHandler.ReportComment)
+	commentGroup.PUT("/:comment_id/hide", commentHandler.HideComment, auth.RequireRole(auth.RoleModerator))
+	commentGroup.DELETE("/:comment_id/hide", commentHandler.UnhideComment, auth.RequireRole(auth.RoleModerator))
+
+	// Очередь модерации комментариев и журнал решений
+	moderationGroup := e.Group("/api/moderation")
+	moderationGroup.Use(authMiddleware.Required, auth.RequireRole(auth.RoleModerator))
+	moderationGroup.GET("/comments", commentHandler.GetModerationQueue)
+	moderationGroup.POST("/comments/bulk", commentHandler.BulkReview)
+	moderationGroup.POST("/comments/:comment_id/approve", commentHandler.ApproveComment)
+	moderationGroup.POST("/comments/:comment_id/reject", commentHandler.RejectComment)
+	moderationGroup.GET("/comments/:comment_id/reports", commentHandler.GetReports)
+	moderationGroup.GET("/actions", commentHandler.ListModerationActions)
+	moderationGroup.GET("/health", commentHandler.ModerationHealth)
+	moderationGroup.GET("/jobs", commentHandler.ListModerationJobs)
+	moderationGroup.POST("/jobs/:job_id/retry", commentHandler.RetryModerationJob)
 	// Добавляем после инициализации других сервисов
 	kodikService := kodik.NewService("None")
 	kodikHandler := kodik.NewHandler(kodikService)
@@ -73,9 +184,7 @@ func main() {
 
 	// Защищенная группа для просмотра
 	playerGroup := e.Group("/player")
-	playerGroup.Use(echojwt.WithConfig(echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-	}))
+	playerGroup.Use(authMiddleware.Required)
 	playerGroup.GET("/:video_id", func(c echo.Context) error {
 		// Здесь будет обработчик для самого плеера
 		return c.JSON(http.StatusOK, echo.Map{"status": "under construction"})
@@ -83,20 +192,64 @@ func main() {
 
 	// Группа роутов для профиля (с защитой JWT)
 	r := e.Group("/profile")
-	r.Use(echojwt.WithConfig(echojwt.Config{
-		SigningKey: []byte(os.Getenv("JWT_SECRET")),
-		ErrorHandler: func(c echo.Context, err error) error {
-			log.Printf("Error validating JWT token: %v", err)
-			return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
-		},
-	}))
+	r.Use(authMiddleware.Required)
 
 	// Обработчик запроса на получение профиля
 	r.GET("", userHandler.Profile)
+	r.PUT("/username", userHandler.SetUsername)            // PUT /profile/username
 	r.POST("/watched/:anime_id", userHandler.AddWatched)   // POST /profile/watched/:anime_id
 	r.POST("/favorite/:anime_id", userHandler.AddFavorite) // POST /profile/favorite/:anime_id
 	r.GET("/watched", userHandler.GetWatchedAnime)         // GET /profile/watched
 	r.GET("/favorite", userHandler.GetFavouriteAnime)
+	r.DELETE("/watched/:anime_id", userHandler.RemoveWatched)   // DELETE /profile/watched/:anime_id
+	r.DELETE("/favorite/:anime_id", userHandler.RemoveFavorite) // DELETE /profile/favorite/:anime_id
+	r.PUT("/favorite/order", userHandler.ReorderFavorites)      // PUT /profile/favorite/order
+	r.GET("/list", userHandler.GetList)                         // GET /profile/list?status=watching
+	r.GET("/list/:anime_id", userHandler.GetListEntry)          // GET /profile/list/:anime_id
+	r.PUT("/list/:anime_id", userHandler.SaveListEntry)         // PUT /profile/list/:anime_id
+	r.DELETE("/list/:anime_id", userHandler.RemoveListEntry)    // DELETE /profile/list/:anime_id
+	r.GET("/sessions", userHandler.Sessions)                    // GET /profile/sessions
+	r.DELETE("/sessions/:session_id", userHandler.RevokeSession)
+	r.GET("/shikimori", userHandler.ShikimoriAccount)
+	r.DELETE("/shikimori", userHandler.UnlinkShikimori)
+	r.POST("/shikimori/link", userHandler.ShikimoriLinkURL)
+	r.POST("/shikimori/callback", userHandler.LinkShikimori)
+	r.POST("/shikimori/import", userHandler.ImportShikimori)
+	r.PUT("/shikimori/push", userHandler.SetShikimoriPush)
+	r.GET("/shikimori/sync", userHandler.ShikimoriSyncStatus)
+	r.POST("/import", userHandler.ImportList)           // POST /profile/import
+	r.GET("/import/:job_id", userHandler.GetListImport) // GET /profile/import/:job_id
+	r.GET("/export", userHandler.ExportList)            // GET /profile/export?format=mal|json|csv
+	r.GET("/comments", commentHandler.MyComments)       // GET /profile/comments?status=pending
+	// Уведомления об ответах, упоминаниях и модерации
+	notificationGroup := e.Group("/notifications")
+	notificationGroup.Use(authMiddleware.Required)
+	notificationGroup.GET("", notificationHandler.List) // GET /notifications?unread=true
+	notificationGroup.GET("/unread-count", notificationHandler.UnreadCount)
+	notificationGroup.POST("/read", notificationHandler.MarkRead)
+	notificationGroup.GET("/preferences", notificationHandler.GetPreferences)
+	notificationGroup.PUT("/preferences", notificationHandler.SetPreferences)
+
+	// Администрирование пользователей
+	adminGroup := e.Group("/admin")
+	adminGroup.Use(authMiddleware.Required, auth.RequireRole(auth.RoleAdmin))
+	adminGroup.GET("/users", userHandler.ListUsers)
+	adminGroup.PUT("/users/:user_id/role", userHandler.SetUserRole)
+
 	// Запуск сервера
-	log.Fatal(e.Start(":8080"))
+	go func() {
+		if err := e.Start(":8080"); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := e.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Failed to stop server: %v", err)
+	}
+	// Ждем, пока воркеры доделают начатые задачи
+	background.Wait()
 }
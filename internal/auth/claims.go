@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/google/uuid"
+)
+
+// Роли пользователей, каждая следующая включает права предыдущей
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{
+	RoleUser:      1,
+	RoleModerator: 2,
+	RoleAdmin:     3,
+}
+
+// IsValidRole сообщает, существует ли роль
+func IsValidRole(role string) bool {
+	return roleRank[role] > 0
+}
+
+// HasRole сообщает, достаточно ли роли role для действия, требующего required
+func HasRole(role, required string) bool {
+	return roleRank[role] >= roleRank[required] && roleRank[required] > 0
+}
+
+// Claims - содержимое access-токена
+type Claims struct {
+	UserID    string `json:"user_id"`
+	Email     string `json:"email"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid"`
+	jwt.RegisteredClaims
+}
+
+// CurrentUser - пользователь текущего запроса
+type CurrentUser struct {
+	ID        uuid.UUID
+	Email     string
+	Role      string // Актуальная роль из базы, а не из токена
+	SessionID uuid.UUID
+}
+
+func (u *CurrentUser) HasRole(required string) bool {
+	return HasRole(u.Role, required)
+}
@@ -0,0 +1,15 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// JWKSHandler отдает публичные ключи для проверки токенов другими сервисами
+func JWKSHandler(keys *KeySet) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set("Cache-Control", "public, max-age=300")
+		return c.JSON(http.StatusOK, keys.JWKS())
+	}
+}
@@ -0,0 +1,261 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Ключи подписи лежат в каталоге JWT_KEYS_DIR, имя файла - kid:
+//
+//	<kid>.pem     - приватный ключ PKCS#8 (RSA от 2048 бит или Ed25519),
+//	                подходит и для подписи, и для проверки;
+//	<kid>.pub.pem - публичный ключ PKIX, только для проверки.
+//
+// Подписывает ключ JWT_SIGNING_KID, а если переменная не задана - приватный
+// ключ с наибольшим kid, поэтому kid удобно называть датой (2026-01-31).
+// Каталог перечитывается по SIGHUP.
+//
+// Ротация без разлогинивания:
+//  1. Сгенерировать ключ (go run ./cmd/jwtkeys -dir $JWT_KEYS_DIR) и положить
+//     в каталог только его .pub.pem, перечитать ключи. Новый ключ появится в
+//     JWKS, и другие сервисы успеют его получить.
+//  2. Положить новый .pem и перечитать ключи - подпись переходит на него.
+//  3. Через ACCESS_TOKEN_TTL заменить .pem старого ключа на .pub.pem,
+//     а еще через ACCESS_TOKEN_TTL удалить его совсем.
+const (
+	privateKeySuffix = ".pem"
+	publicKeySuffix  = ".pub.pem"
+)
+
+type verificationKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet - ключ подписи и все действующие ключи проверки
+type KeySet struct {
+	dir      string
+	fixedKID string
+
+	mu            sync.RWMutex
+	signingKID    string
+	signingKey    crypto.PrivateKey
+	signingMethod jwt.SigningMethod
+	public        map[string]verificationKey
+}
+
+// LoadKeySet читает ключи из каталога. signingKID может быть пустым.
+func LoadKeySet(dir, signingKID string) (*KeySet, error) {
+	ks := &KeySet{dir: dir, fixedKID: signingKID}
+	if err := ks.Reload(); err != nil {
+		return nil, err
+	}
+	return ks, nil
+}
+
+// NewEphemeralKeySet создает одноразовый ключ Ed25519 для локальной разработки.
+// Токены перестают быть валидными после перезапуска.
+func NewEphemeralKeySet() (*KeySet, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	kid := "ephemeral-" + time.Now().UTC().Format("20060102150405")
+	return &KeySet{
+		signingKID:    kid,
+		signingKey:    priv,
+		signingMethod: jwt.SigningMethodEdDSA,
+		public:        map[string]verificationKey{kid: {alg: jwt.SigningMethodEdDSA.Alg(), key: pub}},
+	}, nil
+}
+
+// Reload перечитывает каталог. При ошибке остаются прежние ключи.
+func (ks *KeySet) Reload() error {
+	if ks.dir == "" {
+		return errors.New("key directory is not set")
+	}
+
+	entries, err := os.ReadDir(ks.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read key directory: %w", err)
+	}
+
+	public := make(map[string]verificationKey)
+	private := make(map[string]crypto.PrivateKey)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, privateKeySuffix) {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(ks.dir, name))
+		if err != nil {
+			return err
+		}
+
+		if kid, ok := strings.CutSuffix(name, publicKeySuffix); ok {
+			key, err := parsePublicKey(data)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			alg, err := algorithmFor(key)
+			if err != nil {
+				return fmt.Errorf("%s: %w", name, err)
+			}
+			public[kid] = verificationKey{alg: alg, key: key}
+			continue
+		}
+
+		kid := strings.TrimSuffix(name, privateKeySuffix)
+		key, err := parsePrivateKey(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		pub := key.(crypto.Signer).Public()
+		alg, err := algorithmFor(pub)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		private[kid] = key
+		public[kid] = verificationKey{alg: alg, key: pub}
+	}
+
+	signingKID := ks.fixedKID
+	if signingKID == "" {
+		kids := make([]string, 0, len(private))
+		for kid := range private {
+			kids = append(kids, kid)
+		}
+		sort.Strings(kids)
+		if len(kids) > 0 {
+			signingKID = kids[len(kids)-1]
+		}
+	}
+	signingKey, ok := private[signingKID]
+	if !ok {
+		return fmt.Errorf("no private key for signing kid %q in %s", signingKID, ks.dir)
+	}
+
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	ks.signingKID = signingKID
+	ks.signingKey = signingKey
+	ks.signingMethod = jwt.GetSigningMethod(public[signingKID].alg)
+	ks.public = public
+	return nil
+}
+
+func (ks *KeySet) signer() (string, crypto.PrivateKey, jwt.SigningMethod) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	return ks.signingKID, ks.signingKey, ks.signingMethod
+}
+
+func (ks *KeySet) verificationKey(kid string) (verificationKey, bool) {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+	key, ok := ks.public[kid]
+	return key, ok
+}
+
+// JWK - публичный ключ в формате RFC 7517
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS возвращает все ключи проверки для /.well-known/jwks.json
+func (ks *KeySet) JWKS() JWKS {
+	ks.mu.RLock()
+	defer ks.mu.RUnlock()
+
+	kids := make([]string, 0, len(ks.public))
+	for kid := range ks.public {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	set := JWKS{Keys: make([]JWK, 0, len(kids))}
+	for _, kid := range kids {
+		vk := ks.public[kid]
+		jwk := JWK{Kid: kid, Use: "sig", Alg: vk.alg}
+		switch key := vk.key.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(key.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(key)
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func algorithmFor(key crypto.PublicKey) (string, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < 2048 {
+			return "", errors.New("RSA key must be at least 2048 bits")
+		}
+		return jwt.SigningMethodRS256.Alg(), nil
+	case ed25519.PublicKey:
+		return jwt.SigningMethodEdDSA.Alg(), nil
+	default:
+		return "", fmt.Errorf("unsupported key type %T", key)
+	}
+}
+
+func parsePrivateKey(data []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := key.(crypto.Signer); !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return key, nil
+}
+
+func parsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
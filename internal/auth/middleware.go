@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// SessionValidator проверяет, что сессия не отозвана, и возвращает
+// текущую роль пользователя. Реализуется user.Service.
+type SessionValidator interface {
+	ValidateSession(sessionID, userID string) (role string, err error)
+}
+
+type contextKey struct{}
+
+func WithUser(ctx context.Context, user *CurrentUser) context.Context {
+	return context.WithValue(ctx, contextKey{}, user)
+}
+
+// UserFromContext возвращает пользователя, положенного middleware
+func UserFromContext(ctx context.Context) (*CurrentUser, bool) {
+	user, ok := ctx.Value(contextKey{}).(*CurrentUser)
+	return user, ok && user != nil
+}
+
+// GetUser возвращает пользователя текущего запроса
+func GetUser(c echo.Context) (*CurrentUser, bool) {
+	return UserFromContext(c.Request().Context())
+}
+
+type Middleware struct {
+	tokens   *Manager
+	sessions SessionValidator
+}
+
+func NewMiddleware(tokens *Manager, sessions SessionValidator) *Middleware {
+	return &Middleware{tokens: tokens, sessions: sessions}
+}
+
+// Required пропускает только запросы с действующим токеном
+func (m *Middleware) Required(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tokenString, ok := bearerToken(c)
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "missing token")
+		}
+		return m.authenticate(c, tokenString, next)
+	}
+}
+
+// Optional пропускает анонимные запросы, но проверяет токен, если он передан
+func (m *Middleware) Optional(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tokenString, ok := bearerToken(c)
+		if !ok {
+			return next(c)
+		}
+		return m.authenticate(c, tokenString, next)
+	}
+}
+
+func (m *Middleware) authenticate(c echo.Context, tokenString string, next echo.HandlerFunc) error {
+	claims, err := m.tokens.Parse(tokenString)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+
+	userID, err := uuid.Parse(claims.UserID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	}
+
+	role, err := m.sessions.ValidateSession(claims.SessionID, claims.UserID)
+	if err != nil {
+		log.Printf("Session %s rejected: %v", claims.SessionID, err)
+		return echo.NewHTTPError(http.StatusUnauthorized, "session expired or revoked")
+	}
+
+	user := &CurrentUser{
+		ID:        userID,
+		Email:     claims.Email,
+		Role:      role,
+		SessionID: sessionID,
+	}
+	c.SetRequest(c.Request().WithContext(WithUser(c.Request().Context(), user)))
+	return next(c)
+}
+
+// RequireRole пропускает пользователей с ролью не ниже role.
+// Ставится после Required.
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			user, ok := GetUser(c)
+			if !ok {
+				return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+			}
+			if !user.HasRole(role) {
+				return echo.NewHTTPError(http.StatusForbidden, "insufficient permissions")
+			}
+			return next(c)
+		}
+	}
+}
+
+func bearerToken(c echo.Context) (string, bool) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidToken  = errors.New("invalid token")
+	ErrKeysDirNotSet = errors.New("JWT_KEYS_DIR is not set; set JWT_DEV_KEYS=true to sign with an ephemeral key in development")
+)
+
+const defaultAccessTokenTTL = 15 * time.Minute
+
+// Manager подписывает и проверяет access-токены
+type Manager struct {
+	keys *KeySet
+	ttl  time.Duration
+}
+
+func NewManager(keys *KeySet, ttl time.Duration) *Manager {
+	return &Manager{keys: keys, ttl: ttl}
+}
+
+// NewManagerFromEnv читает ключи из JWT_KEYS_DIR (см. keys.go) и
+// ACCESS_TOKEN_TTL (по умолчанию 15m). Без JWT_KEYS_DIR возвращает ошибку:
+// временный ключ создается, только если явно задан JWT_DEV_KEYS=true
+// (локальная разработка, токены не переживают перезапуск).
+func NewManagerFromEnv() (*Manager, error) {
+	ttl := defaultAccessTokenTTL
+	if value := os.Getenv("ACCESS_TOKEN_TTL"); value != "" {
+		if d, err := time.ParseDuration(value); err == nil && d > 0 {
+			ttl = d
+		} else {
+			log.Printf("Invalid ACCESS_TOKEN_TTL=%q, using %s", value, ttl)
+		}
+	}
+
+	dir := os.Getenv("JWT_KEYS_DIR")
+	if dir == "" {
+		if os.Getenv("JWT_DEV_KEYS") != "true" {
+			return nil, ErrKeysDirNotSet
+		}
+		log.Println("JWT_DEV_KEYS=true, using an ephemeral signing key")
+		keys, err := NewEphemeralKeySet()
+		if err != nil {
+			return nil, err
+		}
+		return NewManager(keys, ttl), nil
+	}
+
+	keys, err := LoadKeySet(dir, os.Getenv("JWT_SIGNING_KID"))
+	if err != nil {
+		return nil, err
+	}
+	return NewManager(keys, ttl), nil
+}
+
+// Keys - набор ключей для перезагрузки и JWKS
+func (m *Manager) Keys() *KeySet {
+	return m.keys
+}
+
+// TTL - время жизни выдаваемых access-токенов
+func (m *Manager) TTL() time.Duration {
+	return m.ttl
+}
+
+// Sign выдает access-токен, iat и exp заполняются автоматически
+func (m *Manager) Sign(claims Claims) (string, error) {
+	kid, key, method := m.keys.signer()
+	if key == nil {
+		return "", errors.New("signing key is not loaded")
+	}
+
+	now := time.Now()
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(m.ttl))
+
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	return token.SignedString(key)
+}
+
+// Parse проверяет подпись по kid из заголовка и срок действия токена
+func (m *Manager) Parse(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys.verificationKey(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown kid %q", kid)
+		}
+		if token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("unexpected algorithm %s for kid %q", token.Method.Alg(), kid)
+		}
+		return key.key, nil
+	},
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewManagerFromEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		dir     string
+		devKeys string
+		wantErr error
+	}{
+		{name: "no keys outside dev mode", wantErr: ErrKeysDirNotSet},
+		{name: "dev mode must be explicit", devKeys: "1", wantErr: ErrKeysDirNotSet},
+		{name: "ephemeral key in dev mode", devKeys: "true"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_KEYS_DIR", tt.dir)
+			t.Setenv("JWT_DEV_KEYS", tt.devKeys)
+
+			manager, err := NewManagerFromEnv()
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if _, err := manager.Sign(Claims{UserID: "user"}); err != nil {
+				t.Errorf("Sign with dev key: %v", err)
+			}
+		})
+	}
+}
+
+func TestNewManagerFromEnvRejectsEmptyKeysDir(t *testing.T) {
+	t.Setenv("JWT_KEYS_DIR", t.TempDir())
+	t.Setenv("JWT_DEV_KEYS", "true")
+
+	if _, err := NewManagerFromEnv(); err == nil {
+		t.Error("empty JWT_KEYS_DIR accepted, want an error even in dev mode")
+	}
+}
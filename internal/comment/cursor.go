@@ -0,0 +1,99 @@
+package comment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Режимы сортировки комментариев
+const (
+	SortNew           = "new"
+	SortOld           = "old"
+	SortTop           = "top"
+	SortControversial = "controversial"
+	SortHot           = "hot"
+)
+
+// Размер страницы по умолчанию и максимальный
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var (
+	ErrInvalidSort   = errors.New("unknown sort, use new, old, top, controversial or hot")
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// sortKeys - SQL-выражения ключа сортировки. Для new и old порядок задают
+// created_at и id. hot - формула Reddit: log10 рейтинга плюс время создания,
+// так что свежие комментарии поднимаются выше, а ключ не зависит от момента запроса.
+var sortKeys = map[string]string{
+	SortNew: "0::float8",
+	SortOld: "0::float8",
+	SortTop: "comments.score::float8",
+	SortControversial: "CASE WHEN comments.upvotes = 0 OR comments.downvotes = 0 THEN 0::float8 " +
+		"ELSE power((comments.upvotes + comments.downvotes)::float8, " +
+		"LEAST(comments.upvotes, comments.downvotes)::float8 / GREATEST(comments.upvotes, comments.downvotes)) END",
+	SortHot: "sign(comments.score)::float8 * log(GREATEST(abs(comments.score), 1)::float8) + " +
+		"extract(epoch from comments.created_at)::float8 / 45000",
+}
+
+// PageRequest - параметры страницы из запроса
+type PageRequest struct {
+	Sort   string
+	Cursor string
+	Limit  int
+}
+
+// cursor - позиция последнего комментария страницы (keyset-пагинация)
+type cursor struct {
+	Sort      string    `json:"s"`
+	Key       float64   `json:"k"`
+	CreatedAt time.Time `json:"t"`
+	ID        uuid.UUID `json:"id"`
+}
+
+func encodeCursor(sort string, last *CommentWithUser) string {
+	data, _ := json.Marshal(cursor{
+		Sort:      sort,
+		Key:       last.SortKey,
+		CreatedAt: last.CreatedAt,
+		ID:        last.ID,
+	})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(sort, value string) (*cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// normalize проверяет сортировку и ограничивает размер страницы
+func (p PageRequest) normalize(defaultSort string) (PageRequest, error) {
+	if p.Sort == "" {
+		p.Sort = defaultSort
+	}
+	if _, ok := sortKeys[p.Sort]; !ok {
+		return p, ErrInvalidSort
+	}
+	if p.Limit <= 0 {
+		p.Limit = DefaultPageSize
+	}
+	p.Limit = min(p.Limit, MaxPageSize)
+	return p, nil
+}
@@ -1,9 +1,11 @@
 package comment
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/Zipklas/anime-site-backend/internal/auth"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
@@ -25,21 +27,37 @@ func (h *Handler) CreateComment(c echo.Context) error {
 	var req struct {
 		Content  string     `json:"content"`
 		ParentID *uuid.UUID `json:"parent_id,omitempty"`
+		Season   *int       `json:"season,omitempty"`
+		Episode  *int       `json:"episode,omitempty"` // Пусто - обсуждение аниме целиком
 	}
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
+	thread, err := newThread(animeID, req.Season, req.Episode)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
 
 	userID, err := getUserIDFromToken(c)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	comment, err := h.service.CreateComment(c.Request().Context(), animeID, req.Content, userID, req.ParentID)
+	comment, err := h.service.CreateComment(c.Request().Context(), thread, req.Content, userID, req.ParentID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		if errors.Is(err, ErrEmailNotVerified) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
+		if errors.Is(err, ErrInvalidParent) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return contentError(err)
 	}
 
+	// 202 - комментарий сохранен, но появится после проверки модератором
+	if comment.ModerationStatus == ModerationPending {
+		return c.JSON(http.StatusAccepted, comment)
+	}
 	return c.JSON(http.StatusCreated, comment)
 }
 
@@ -63,7 +81,7 @@ func (h *Handler) VoteComment(c echo.Context) error {
 	}
 
 	if err := h.service.VoteComment(c.Request().Context(), commentID, userID, req.IsUpvote); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return voteError(err)
 	}
 
 	return c.NoContent(http.StatusNoContent)
@@ -81,29 +99,162 @@ func (h *Handler) RemoveVote(c echo.Context) error {
 	}
 
 	if err := h.service.RemoveVote(c.Request().Context(), commentID, userID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return voteError(err)
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
-// Обновляем GetComments
+func voteError(err error) error {
+	switch {
+	case errors.Is(err, ErrCommentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrSelfVote):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// GetComments возвращает страницу плоского списка или, с ?tree=true, веток
+// комментариев глубиной ?depth=N. Параметры страницы: ?sort=new|old|top|
+// controversial|hot, ?limit=N и ?cursor= из next_cursor предыдущей страницы.
 func (h *Handler) GetComments(c echo.Context) error {
 	animeID := c.Param("anime_id")
 	if animeID == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "anime_id is required")
 	}
 
-	userID, _ := getUserIDFromToken(c) // Ошибка не критична - просто не будет user_vote
+	// Читать комментарии можно анонимно - тогда просто не будет user_vote
+	var userID uuid.UUID
+	if current, ok := auth.GetUser(c); ok {
+		userID = current.ID
+	}
 
-	comments, err := h.service.GetComments(c.Request().Context(), animeID, userID)
+	thread, err := threadQuery(c, animeID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	// Обсуждение непросмотренной серии показываем только по запросу
+	reveal, _ := strconv.ParseBool(c.QueryParam("reveal"))
+
+	if tree, _ := strconv.ParseBool(c.QueryParam("tree")); tree {
+		depth, _ := strconv.Atoi(c.QueryParam("depth"))
+		nodes, err := h.service.GetCommentTree(c.Request().Context(), thread, userID, isModerator(c), reveal, depth, pageRequest(c))
+		if err != nil {
+			return pageError(err)
+		}
+		return c.JSON(http.StatusOK, nodes)
+	}
+
+	comments, err := h.service.GetComments(c.Request().Context(), thread, userID, isModerator(c), reveal, pageRequest(c))
+	if err != nil {
+		return pageError(err)
 	}
 
 	return c.JSON(http.StatusOK, comments)
 }
 
+// GetReplies - GET /api/comments/:comment_id/replies?depth=N&reveal=true,
+// подгрузка ответов
+func (h *Handler) GetReplies(c echo.Context) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	var userID uuid.UUID
+	if current, ok := auth.GetUser(c); ok {
+		userID = current.ID
+	}
+
+	depth, _ := strconv.Atoi(c.QueryParam("depth"))
+	reveal, _ := strconv.ParseBool(c.QueryParam("reveal"))
+	replies, err := h.service.GetReplies(c.Request().Context(), commentID, userID, isModerator(c), reveal, depth, pageRequest(c))
+	if err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return pageError(err)
+	}
+
+	return c.JSON(http.StatusOK, replies)
+}
+
+// GetEpisodeCounts - GET /api/comments/:anime_id/episodes, число
+// комментариев к каждой серии
+func (h *Handler) GetEpisodeCounts(c echo.Context) error {
+	var userID uuid.UUID
+	if current, ok := auth.GetUser(c); ok {
+		userID = current.ID
+	}
+
+	counts, err := h.service.GetEpisodeCounts(c.Request().Context(), c.Param("anime_id"), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, counts)
+}
+
+// threadQuery читает обсуждение из ?episode=N&season=S
+func threadQuery(c echo.Context, animeID string) (Thread, error) {
+	season, err := optionalInt(c.QueryParam("season"))
+	if err != nil {
+		return Thread{}, ErrInvalidEpisode
+	}
+	episode, err := optionalInt(c.QueryParam("episode"))
+	if err != nil {
+		return Thread{}, ErrInvalidEpisode
+	}
+	return newThread(animeID, season, episode)
+}
+
+func optionalInt(value string) (*int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// newThread проверяет номер серии. Без сезона серия относится к первому.
+func newThread(animeID string, season, episode *int) (Thread, error) {
+	thread := Thread{AnimeID: animeID}
+	if episode == nil {
+		if season != nil {
+			return Thread{}, ErrInvalidEpisode
+		}
+		return thread, nil
+	}
+	if *episode <= 0 || (season != nil && *season <= 0) {
+		return Thread{}, ErrInvalidEpisode
+	}
+	if season == nil {
+		first := 1
+		season = &first
+	}
+	thread.Season, thread.Episode = season, episode
+	return thread, nil
+}
+
+func pageRequest(c echo.Context) PageRequest {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	return PageRequest{
+		Sort:   c.QueryParam("sort"),
+		Cursor: c.QueryParam("cursor"),
+		Limit:  limit,
+	}
+}
+
+func pageError(err error) error {
+	if errors.Is(err, ErrInvalidSort) || errors.Is(err, ErrInvalidCursor) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
 func (h *Handler) DeleteComment(c echo.Context) error {
 	commentID, err := uuid.Parse(c.Param("comment_id"))
 	if err != nil {
@@ -115,21 +266,71 @@ func (h *Handler) DeleteComment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	if err := h.service.DeleteComment(c.Request().Context(), commentID, userID); err != nil {
+	// Причина необязательна, ее записываем в журнал при удалении модератором
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.service.DeleteComment(c.Request().Context(), commentID, userID, isModerator(c), req.Reason); err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
 
 	return c.NoContent(http.StatusNoContent)
 }
 
-func (h *Handler) UpdateComment(c echo.Context) error {
+// HideComment скрывает комментарий от других пользователей (только модераторы)
+func (h *Handler) HideComment(c echo.Context) error {
+	return h.setHidden(c, true)
+}
+
+func (h *Handler) UnhideComment(c echo.Context) error {
+	return h.setHidden(c, false)
+}
+
+func (h *Handler) setHidden(c echo.Context, hidden bool) error {
 	commentID, err := uuid.Parse(c.Param("comment_id"))
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
 	}
 
+	moderatorID, err := getUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
 	var req struct {
-		Content string `json:"content"`
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.service.HideComment(c.Request().Context(), commentID, moderatorID, hidden, req.Reason); err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ReportComment - POST /api/comments/:comment_id/report {"category": "spam", "text": "..."}
+func (h *Handler) ReportComment(c echo.Context) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	var req struct {
+		Category string `json:"category"`
+		Text     string `json:"text"`
 	}
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
@@ -140,16 +341,290 @@ func (h *Handler) UpdateComment(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
 	}
 
-	if err := h.service.UpdateComment(c.Request().Context(), commentID, userID, req.Content); err != nil {
+	report, err := h.service.ReportComment(c.Request().Context(), commentID, userID, req.Category, req.Text)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCommentNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrAlreadyReported):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		case errors.Is(err, ErrInvalidReportCategory), errors.Is(err, ErrReportTextTooLong), errors.Is(err, ErrSelfReport):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusCreated, report)
+}
+
+// GetReports - GET /api/moderation/comments/:comment_id/reports
+func (h *Handler) GetReports(c echo.Context) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	reports, err := h.service.GetReports(c.Request().Context(), commentID)
+	if err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
 	}
+	return c.JSON(http.StatusOK, reports)
+}
+
+// GetModerationQueue - GET /api/moderation/comments?queue=pending|rejected|flagged|reported
+// с параметрами страницы как у GetComments
+func (h *Handler) GetModerationQueue(c echo.Context) error {
+	comments, err := h.service.GetModerationQueue(c.Request().Context(), c.QueryParam("queue"), pageRequest(c))
+	if err != nil {
+		if errors.Is(err, ErrInvalidQueue) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return pageError(err)
+	}
+	return c.JSON(http.StatusOK, comments)
+}
+
+// ApproveComment - POST /api/moderation/comments/:comment_id/approve
+func (h *Handler) ApproveComment(c echo.Context) error {
+	return h.reviewComment(c, ActionApprove)
+}
 
+// RejectComment - POST /api/moderation/comments/:comment_id/reject, причина обязательна
+func (h *Handler) RejectComment(c echo.Context) error {
+	return h.reviewComment(c, ActionReject)
+}
+
+func (h *Handler) reviewComment(c echo.Context, action string) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	moderatorID, err := getUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	result, err := h.service.ReviewComments(c.Request().Context(), []uuid.UUID{commentID}, moderatorID, action, req.Reason)
+	if err != nil {
+		return reviewError(err)
+	}
+	if len(result.NotFound) > 0 {
+		return echo.NewHTTPError(http.StatusNotFound, ErrCommentNotFound.Error())
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// BulkReview - POST /api/moderation/comments/bulk
+// {"comment_ids": [...], "action": "approve|reject", "reason": "..."}
+func (h *Handler) BulkReview(c echo.Context) error {
+	var req struct {
+		CommentIDs []uuid.UUID `json:"comment_ids"`
+		Action     string      `json:"action"`
+		Reason     string      `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	moderatorID, err := getUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	result, err := h.service.ReviewComments(c.Request().Context(), req.CommentIDs, moderatorID, req.Action, req.Reason)
+	if err != nil {
+		return reviewError(err)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+func reviewError(err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidReviewAction), errors.Is(err, ErrReasonRequired), errors.Is(err, ErrInvalidBulkSize):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// ListModerationActions - GET /api/moderation/actions?comment_id=&moderator_id=&action=&limit=&offset=
+func (h *Handler) ListModerationActions(c echo.Context) error {
+	filter := ActionFilter{Action: c.QueryParam("action"), Limit: 50}
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 200 {
+		filter.Limit = l
+	}
+	if o, err := strconv.Atoi(c.QueryParam("offset")); err == nil && o > 0 {
+		filter.Offset = o
+	}
+	if value := c.QueryParam("comment_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+		}
+		filter.CommentID = &id
+	}
+	if value := c.QueryParam("moderator_id"); value != "" {
+		id, err := uuid.Parse(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid moderator_id")
+		}
+		filter.ModeratorID = &id
+	}
+
+	actions, err := h.service.ListModerationActions(c.Request().Context(), filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, actions)
+}
+
+// ModerationHealth - GET /api/moderation/health, состояние проверок и
+// задержка сервиса токсичности. 503, если какая-то проверка недоступна.
+func (h *Handler) ModerationHealth(c echo.Context) error {
+	health := h.service.GetModerationHealth(c.Request().Context())
+	if !health.Healthy {
+		return c.JSON(http.StatusServiceUnavailable, health)
+	}
+	return c.JSON(http.StatusOK, health)
+}
+
+// ListModerationJobs - GET /api/moderation/jobs?status=dead, задачи
+// асинхронной модерации. По умолчанию - исчерпавшие попытки.
+func (h *Handler) ListModerationJobs(c echo.Context) error {
+	limit, offset := 50, 0
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(c.QueryParam("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	jobs, err := h.service.ListModerationJobs(c.Request().Context(), c.QueryParam("status"), limit, offset)
+	if err != nil {
+		if errors.Is(err, ErrUnknownJobStatus) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, jobs)
+}
+
+// RetryModerationJob - POST /api/moderation/jobs/:job_id/retry, вернуть
+// задачу из dead в очередь
+func (h *Handler) RetryModerationJob(c echo.Context) error {
+	jobID, err := uuid.Parse(c.Param("job_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid job_id")
+	}
+
+	if err := h.service.RetryModerationJob(c.Request().Context(), jobID); err != nil {
+		if errors.Is(err, ErrJobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
 	return c.NoContent(http.StatusNoContent)
 }
 
+// MyComments - GET /profile/comments?status=approved|pending|rejected, свои
+// комментарии со статусом модерации и причиной отклонения
+func (h *Handler) MyComments(c echo.Context) error {
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	comments, err := h.service.GetUserComments(c.Request().Context(), userID, c.QueryParam("status"), pageRequest(c))
+	if err != nil {
+		if errors.Is(err, ErrInvalidStatus) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return pageError(err)
+	}
+	return c.JSON(http.StatusOK, comments)
+}
+
+func isModerator(c echo.Context) bool {
+	current, ok := auth.GetUser(c)
+	return ok && current.HasRole(auth.RoleModerator)
+}
+
+func (h *Handler) UpdateComment(c echo.Context) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	var req struct {
+		Content string `json:"content"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	userID, err := getUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	}
+
+	comment, err := h.service.UpdateComment(c.Request().Context(), commentID, userID, req.Content)
+	if err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return contentError(err)
+	}
+
+	if comment.ModerationStatus == ModerationPending {
+		return c.JSON(http.StatusAccepted, comment)
+	}
+	return c.JSON(http.StatusOK, comment)
+}
+
+// contentError - ошибки проверки и модерации текста комментария
+func contentError(err error) error {
+	var rejected *RejectedError
+	switch {
+	case errors.Is(err, ErrEmptyContent), errors.Is(err, ErrContentTooLong):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.As(err, &rejected):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, ErrModerationUnavailable):
+		return echo.NewHTTPError(http.StatusServiceUnavailable, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// GetCommentHistory - GET /api/comments/:comment_id/history (только модераторы)
+func (h *Handler) GetCommentHistory(c echo.Context) error {
+	commentID, err := uuid.Parse(c.Param("comment_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid comment_id")
+	}
+
+	history, err := h.service.GetCommentHistory(c.Request().Context(), commentID)
+	if err != nil {
+		if errors.Is(err, ErrCommentNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, history)
+}
+
 func getUserIDFromToken(c echo.Context) (uuid.UUID, error) {
-	userToken := c.Get("user").(*jwt.Token)
-	claims := userToken.Claims.(jwt.MapClaims)
-	userIDStr := claims["user_id"].(string)
-	return uuid.Parse(userIDStr)
+	current, ok := auth.GetUser(c)
+	if !ok {
+		return uuid.Nil, errors.New("unauthorized")
+	}
+	return current.ID, nil
 }
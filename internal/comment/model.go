@@ -3,19 +3,193 @@ package comment
 import (
 	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/markup"
 	"github.com/google/uuid"
 )
 
 type Comment struct {
-	Votes      []CommentVote `gorm:"foreignKey:CommentID"`
-	ID         uuid.UUID     `gorm:"type:uuid;primaryKey" json:"id"`
-	AnimeID    string        `gorm:"index" json:"anime_id"` // Shikimori ID аниме
-	UserID     uuid.UUID     `json:"user_id"`
-	Content    string        `gorm:"type:text" json:"content"`
-	CreatedAt  time.Time     `json:"created_at"`
-	UpdatedAt  time.Time     `json:"updated_at"`
-	ParentID   *uuid.UUID    `gorm:"type:uuid;index" json:"parent_id,omitempty"` // Для ответов на комментарии
-	IsApproved bool          `gorm:"default:true" json:"is_approved"`
+	Votes     []CommentVote `gorm:"foreignKey:CommentID"`
+	ID        uuid.UUID     `gorm:"type:uuid;primaryKey" json:"id"`
+	AnimeID   string        `gorm:"index;index:idx_comments_anime_created,priority:1;index:idx_comments_anime_episode,priority:1" json:"anime_id"` // Shikimori ID аниме
+	UserID    uuid.UUID     `json:"user_id"`
+	Content   string        `gorm:"type:text" json:"content"`
+	CreatedAt time.Time     `gorm:"index:idx_comments_anime_created,priority:2" json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+	EditedAt  *time.Time    `json:"edited_at,omitempty"`                        // Когда автор последний раз менял текст
+	ParentID  *uuid.UUID    `gorm:"type:uuid;index" json:"parent_id,omitempty"` // Для ответов на комментарии
+	// Обсуждение серии, см. Thread. Пусто - обсуждение аниме целиком.
+	Season     *int       `gorm:"index:idx_comments_anime_episode,priority:2" json:"season,omitempty"`
+	Episode    *int       `gorm:"index:idx_comments_anime_episode,priority:3" json:"episode,omitempty"`
+	IsApproved bool       `gorm:"not null;default:false" json:"is_approved"` // Копия ModerationStatus == approved для клиентов
+	IsHidden   bool       `gorm:"not null;default:false" json:"is_hidden"`   // Скрыт модератором
+	HiddenBy   *uuid.UUID `gorm:"type:uuid" json:"-"`
+
+	// Результат автоматической модерации, см. ModerationApproved и др.
+	// Оценок нет, если сервис модерации не ответил.
+	ModerationStatus  string             `gorm:"not null;default:approved;index" json:"moderation_status"`
+	ToxicityScore     *float64           `json:"toxicity_score,omitempty"`
+	ModerationDetails map[string]float64 `gorm:"serializer:json;type:jsonb" json:"moderation_details,omitempty"`
+	ModerationBackend string             `json:"moderation_backend,omitempty"` // Какая проверка приняла решение
+	ModerationReason  string             `json:"moderation_reason,omitempty"`  // Причина решения модератора
+	ModeratedBy       *uuid.UUID         `gorm:"type:uuid" json:"-"`
+	ModeratedAt       *time.Time         `json:"moderated_at,omitempty"`
+
+	// Жалобы читателей: OpenReports - нерассмотренные, ReportsResolvedAt -
+	// когда модератор последний раз принял решение по комментарию. Жалобы
+	// на рассмотренный комментарий сохраняются, но в очередь не попадают.
+	OpenReports       int        `gorm:"not null;default:0;index" json:"-"`
+	ReportsResolvedAt *time.Time `json:"-"`
+
+	// Мягкое удаление: в ветке остается заглушка DeletedPlaceholder, ответы
+	// сохраняются. Текст стирается по истечении COMMENT_RETENTION.
+	DeletedAt          *time.Time `gorm:"index" json:"deleted_at,omitempty"`
+	DeletedBy          *uuid.UUID `gorm:"type:uuid" json:"-"`
+	DeletedByModerator bool       `gorm:"not null;default:false" json:"deleted_by_moderator,omitempty"`
+	DeleteReason       string     `json:"delete_reason,omitempty"`
+	PurgedAt           *time.Time `json:"-"`
+
+	// Счетчики голосов, обновляются вместе с comment_votes
+	Upvotes   int `gorm:"not null;default:0" json:"upvotes"`
+	Downvotes int `gorm:"not null;default:0" json:"downvotes"`
+	Score     int `gorm:"not null;default:0;index" json:"score"` // upvotes - downvotes
+
+	// Разобранная разметка Content для показа, заполняется сервисом
+	Rendered *markup.Document `gorm:"-" json:"rendered,omitempty"`
+}
+
+// Thread - обсуждение аниме целиком (Episode == nil) или отдельной серии.
+// Сезон и серия нумеруются как в kodik.Season и kodik.Episode.
+type Thread struct {
+	AnimeID string
+	Season  *int
+	Episode *int
+}
+
+// thread - обсуждение комментария. Ответы создаются в обсуждении
+// родителя, поэтому у всей ветки оно совпадает с корневым.
+func (c *Comment) thread() Thread {
+	return Thread{AnimeID: c.AnimeID, Season: c.Season, Episode: c.Episode}
+}
+
+// EpisodeCount - число видимых комментариев к серии. Behind - пользователь
+// еще не досмотрел до этой серии, ее обсуждение скрыто, см. CommentPage.
+type EpisodeCount struct {
+	Season   int   `json:"season"`
+	Episode  int   `json:"episode"`
+	Comments int64 `json:"comments"`
+	Behind   bool  `json:"behind,omitempty"`
+}
+
+// DeletedPlaceholder заменяет текст удаленного комментария в выдаче
+const DeletedPlaceholder = "[deleted]"
+
+// visible - комментарий виден всем, а не только автору и модераторам
+func (c *Comment) visible() bool {
+	return c.ModerationStatus == ModerationApproved && !c.IsHidden
+}
+
+// redact убирает текст и автора удаленного комментария, оставляя заглушку
+func (c *CommentWithUser) redact() {
+	if c.DeletedAt == nil {
+		return
+	}
+	c.Content = DeletedPlaceholder
+	c.Rendered = nil
+	c.UserID = uuid.Nil
+	c.Username = nil
+	c.UserKarma = 0
+	c.ToxicityScore = nil
+	c.ModerationDetails = nil
+	c.ModerationReason = ""
+}
+
+// Статусы задачи асинхронной модерации
+const (
+	JobQueued  = "queued"
+	JobRunning = "running"
+	JobDone    = "done"
+	JobDead    = "dead" // Попытки исчерпаны, комментарий ждет модератора
+)
+
+// ModerationJob - задача проверки комментария воркером. Одна на
+// комментарий: правка текста ставит ее в очередь заново и увеличивает
+// Revision, чтобы результат проверки старого текста не применился.
+type ModerationJob struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	CommentID   uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex" json:"comment_id"`
+	Status      string     `gorm:"not null;default:queued;index:idx_moderation_jobs_pick,priority:1" json:"status"`
+	RunAt       time.Time  `gorm:"not null;index:idx_moderation_jobs_pick,priority:2" json:"run_at"`
+	Attempts    int        `gorm:"not null;default:0" json:"attempts"`
+	Revision    int        `gorm:"not null;default:1" json:"-"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// Статусы жалобы
+const (
+	ReportOpen     = "open"
+	ReportResolved = "resolved"
+)
+
+// CommentReport - жалоба читателя на комментарий, одна от пользователя
+type CommentReport struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	CommentID  uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_comment_reports_user,priority:1" json:"comment_id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_comment_reports_user,priority:2" json:"user_id"`
+	Category   string     `gorm:"not null" json:"category"` // См. reportCategories
+	Text       string     `gorm:"type:text" json:"text,omitempty"`
+	Status     string     `gorm:"not null;default:open;index" json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ResolvedBy *uuid.UUID `gorm:"type:uuid" json:"resolved_by,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// CommentRevision - прежний текст комментария, сохраняется при каждой правке
+type CommentRevision struct {
+	ID               uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	CommentID        uuid.UUID `gorm:"type:uuid;not null;index" json:"comment_id"`
+	Content          string    `gorm:"type:text" json:"content"`
+	ModerationStatus string    `json:"moderation_status"`
+	ToxicityScore    *float64  `json:"toxicity_score,omitempty"`
+	CreatedAt        time.Time `json:"created_at"` // Когда текст был заменен
+}
+
+// CommentHistory - комментарий с прежними версиями, новые сверху
+type CommentHistory struct {
+	Comment   *Comment          `json:"comment"`
+	Revisions []CommentRevision `json:"revisions"`
+}
+
+// Действия модераторов для журнала moderation_actions
+const (
+	ActionApprove = "approve"
+	ActionReject  = "reject"
+	ActionHide    = "hide"
+	ActionUnhide  = "unhide"
+	ActionDelete  = "delete"
+)
+
+// ModerationAction - запись журнала: кто, что и почему решил по комментарию.
+// Записи остаются и после удаления комментария.
+type ModerationAction struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	CommentID   uuid.UUID `gorm:"type:uuid;not null;index" json:"comment_id"`
+	ModeratorID uuid.UUID `gorm:"type:uuid;not null;index" json:"moderator_id"`
+	Action      string    `gorm:"not null" json:"action"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `gorm:"index" json:"created_at"`
+}
+
+func newAction(commentID, moderatorID uuid.UUID, action, reason string) ModerationAction {
+	return ModerationAction{
+		ID:          uuid.New(),
+		CommentID:   commentID,
+		ModeratorID: moderatorID,
+		Action:      action,
+		Reason:      reason,
+	}
 }
 
 // Добавляем новую модель для голосов
@@ -29,8 +203,39 @@ type CommentVote struct {
 // Добавляем поля в CommentWithUser
 type CommentWithUser struct {
 	Comment
-	UserEmail string `json:"user_email"`
-	Upvotes   int    `json:"upvotes"`
-	Downvotes int    `json:"downvotes"`
-	UserVote  *bool  `json:"user_vote"` // nil - нет голоса, true - лайк, false - дизлайк
+	Username  *string `json:"username"` // Публичное имя автора, почта не отдается
+	UserKarma int     `json:"user_karma"`
+	UserVote  *bool   `json:"user_vote"` // nil - нет голоса, true - лайк, false - дизлайк
+
+	ReplyCount int     `json:"reply_count"` // Видимые прямые ответы
+	Depth      int     `json:"-"`           // Уровень в дереве, 1 - корень выборки
+	SortKey    float64 `json:"-"`           // Ключ сортировки страницы, см. sortKeys
+}
+
+// CommentPage - страница плоского списка комментариев
+type CommentPage struct {
+	Comments   []CommentWithUser `json:"comments"`
+	NextCursor string            `json:"next_cursor,omitempty"` // Пусто на последней странице
+	// Серия дальше прогресса пользователя в его списке: комментарии не
+	// отдаются, пока он не попросит показать их (reveal=true)
+	SpoilerHidden bool `json:"spoiler_hidden,omitempty"`
+}
+
+// CommentTreePage - страница веток комментариев
+type CommentTreePage struct {
+	Comments      []*CommentNode `json:"comments"`
+	NextCursor    string         `json:"next_cursor,omitempty"`
+	SpoilerHidden bool           `json:"spoiler_hidden,omitempty"`
+}
+
+// CommentNode - комментарий с ответами для древовидной выдачи.
+// HasMoreReplies означает, что загружены не все ответы (ограничения
+// глубины и числа ответов), остальные отдает
+// GET /api/comments/:comment_id/replies?cursor=RepliesCursor. Пустой
+// курсор - ответы не загружены совсем, подгрузка идет с начала.
+type CommentNode struct {
+	CommentWithUser
+	Replies        []*CommentNode `json:"replies"`
+	HasMoreReplies bool           `json:"has_more_replies"`
+	RepliesCursor  string         `json:"replies_cursor,omitempty"`
 }
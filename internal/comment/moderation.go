@@ -0,0 +1,138 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+)
+
+// Статусы модерации комментария
+const (
+	ModerationApproved = "approved"
+	ModerationPending  = "pending" // Ждет проверки модератором, видно только автору
+	ModerationRejected = "rejected"
+)
+
+// Что делать с комментарием, если проверка недоступна
+// (MODERATION_FAILURE_POLICY)
+const (
+	FailOpen   = "open"   // Публиковать без проверки
+	FailClosed = "closed" // Отказывать в публикации
+	FailQueue  = "queue"  // Отправлять на ручную проверку
+)
+
+// failureBackend записывается в ModerationBackend, когда решение принято
+// по MODERATION_FAILURE_POLICY
+const failureBackend = "failure_policy"
+
+var ErrModerationUnavailable = errors.New("moderation service is unavailable, try again later")
+
+// RejectedError - комментарий отклонен автоматической модерацией
+type RejectedError struct {
+	Verdict *moderation.Verdict
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf(
+		"Ваш комментарий был отклонен системой модерации. "+
+			"Общий уровень токсичности: %.0f%%. "+
+			"Проблемные категории: %s. "+
+			"Пожалуйста, переформулируйте ваш комментарий.",
+		e.Verdict.Score*100,
+		strings.Join(e.Verdict.Reasons, ", "),
+	)
+}
+
+type moderationConfig struct {
+	failurePolicy   string
+	reviewThreshold float64 // Для очереди flagged
+}
+
+func moderationConfigFromEnv() moderationConfig {
+	cfg := moderationConfig{
+		failurePolicy:   FailQueue,
+		reviewThreshold: moderation.ThresholdsFromEnv().Review,
+	}
+	switch policy := os.Getenv("MODERATION_FAILURE_POLICY"); policy {
+	case "":
+	case FailOpen, FailClosed, FailQueue:
+		cfg.failurePolicy = policy
+	default:
+		log.Printf("unknown MODERATION_FAILURE_POLICY %q, using %q", policy, FailQueue)
+	}
+	return cfg
+}
+
+// verdictStatuses - статус комментария по решению проверки
+var verdictStatuses = map[string]string{
+	moderation.Approve: ModerationApproved,
+	moderation.Review:  ModerationPending,
+	moderation.Reject:  ModerationRejected,
+}
+
+// moderationStatus проверяет текст и решает, опубликовать комментарий,
+// отправить на проверку или отклонить. Ошибка проверки обрабатывается
+// согласно MODERATION_FAILURE_POLICY; вердикт тогда nil.
+func (s *service) moderationStatus(ctx context.Context, content string) (string, *moderation.Verdict, error) {
+	verdict, err := s.moderator.Moderate(ctx, content)
+	if err != nil {
+		log.Printf("moderation error: %v", err)
+		switch s.moderation.failurePolicy {
+		case FailOpen:
+			return ModerationApproved, nil, nil
+		case FailClosed:
+			return "", nil, ErrModerationUnavailable
+		default:
+			return ModerationPending, nil, nil
+		}
+	}
+	return verdictStatuses[verdict.Decision], verdict, nil
+}
+
+// ModerationHealth - состояние автоматической модерации. Пока проверка
+// недоступна, новые комментарии обрабатываются по FailurePolicy.
+type ModerationHealth struct {
+	Healthy       bool                       `json:"healthy"`
+	FailurePolicy string                     `json:"failure_policy"`
+	Backends      []moderation.BackendHealth `json:"backends"`
+}
+
+func (s *service) GetModerationHealth(ctx context.Context) *ModerationHealth {
+	health := &ModerationHealth{
+		Healthy:       true,
+		FailurePolicy: s.moderation.failurePolicy,
+		Backends:      moderation.Health(s.moderator),
+	}
+	for _, backend := range health.Backends {
+		health.Healthy = health.Healthy && backend.Healthy
+	}
+	return health
+}
+
+// markQueued оставляет комментарий ждать асинхронной проверки
+func markQueued(comment *Comment) {
+	comment.ModerationStatus = ModerationPending
+	comment.IsApproved = false
+	comment.ToxicityScore = nil
+	comment.ModerationDetails = nil
+	comment.ModerationBackend = ""
+}
+
+// applyVerdict записывает в комментарий результат модерации
+func applyVerdict(comment *Comment, status string, verdict *moderation.Verdict) {
+	comment.ModerationStatus = status
+	comment.IsApproved = status == ModerationApproved
+	comment.ToxicityScore = nil
+	comment.ModerationDetails = nil
+	comment.ModerationBackend = failureBackend
+	if verdict != nil {
+		comment.ToxicityScore = &verdict.Score
+		comment.ModerationDetails = verdict.Details
+		comment.ModerationBackend = verdict.Backend
+	}
+}
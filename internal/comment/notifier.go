@@ -0,0 +1,224 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Zipklas/anime-site-backend/internal/markup"
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/Zipklas/anime-site-backend/internal/notification"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
+	"github.com/google/uuid"
+)
+
+// Notifier сообщает пользователям о событиях с комментариями. Ответы и
+// упоминания отправляются, только когда комментарий виден всем. Removed
+// вызывается, когда комментарии удалены, скрыты или отклонены.
+type Notifier interface {
+	ModerationDecided(ctx context.Context, comment *Comment) error
+	Replied(ctx context.Context, reply *Comment, parent *Comment) error
+	Mentioned(ctx context.Context, comment *Comment, userIDs []uuid.UUID) error
+	VoteMilestone(ctx context.Context, comment *Comment, milestone int) error
+	Removed(ctx context.Context, commentIDs []uuid.UUID) error
+}
+
+// VoteMilestones - при каком рейтинге комментария уведомлять автора
+var VoteMilestones = []int{10, 50, 100, 500, 1000}
+
+type noopNotifier struct{}
+
+func (noopNotifier) ModerationDecided(ctx context.Context, comment *Comment) error { return nil }
+func (noopNotifier) Replied(ctx context.Context, reply *Comment, parent *Comment) error {
+	return nil
+}
+func (noopNotifier) Mentioned(ctx context.Context, comment *Comment, userIDs []uuid.UUID) error {
+	return nil
+}
+func (noopNotifier) VoteMilestone(ctx context.Context, comment *Comment, milestone int) error {
+	return nil
+}
+func (noopNotifier) Removed(ctx context.Context, commentIDs []uuid.UUID) error { return nil }
+
+// Notifiers рассылает события всем получателям по очереди
+type Notifiers []Notifier
+
+func (ns Notifiers) ModerationDecided(ctx context.Context, comment *Comment) error {
+	var errs []error
+	for _, n := range ns {
+		errs = append(errs, n.ModerationDecided(ctx, comment))
+	}
+	return errors.Join(errs...)
+}
+
+func (ns Notifiers) Replied(ctx context.Context, reply *Comment, parent *Comment) error {
+	var errs []error
+	for _, n := range ns {
+		errs = append(errs, n.Replied(ctx, reply, parent))
+	}
+	return errors.Join(errs...)
+}
+
+func (ns Notifiers) Mentioned(ctx context.Context, comment *Comment, userIDs []uuid.UUID) error {
+	var errs []error
+	for _, n := range ns {
+		errs = append(errs, n.Mentioned(ctx, comment, userIDs))
+	}
+	return errors.Join(errs...)
+}
+
+func (ns Notifiers) VoteMilestone(ctx context.Context, comment *Comment, milestone int) error {
+	var errs []error
+	for _, n := range ns {
+		errs = append(errs, n.VoteMilestone(ctx, comment, milestone))
+	}
+	return errors.Join(errs...)
+}
+
+func (ns Notifiers) Removed(ctx context.Context, commentIDs []uuid.UUID) error {
+	var errs []error
+	for _, n := range ns {
+		errs = append(errs, n.Removed(ctx, commentIDs))
+	}
+	return errors.Join(errs...)
+}
+
+// InAppNotifier сохраняет уведомления на сайте, см. GET /notifications.
+// Об автоматическом одобрении, как и MailNotifier, сообщает только при
+// onApproved; решение модератора сообщается всегда.
+type InAppNotifier struct {
+	notifications notification.Service
+	markup        *markup.Parser
+	onApproved    bool
+}
+
+func NewInAppNotifier(notifications notification.Service, parser *markup.Parser, onApproved bool) *InAppNotifier {
+	return &InAppNotifier{notifications: notifications, markup: parser, onApproved: onApproved}
+}
+
+func (n *InAppNotifier) ModerationDecided(ctx context.Context, comment *Comment) error {
+	if comment.ModerationStatus == ModerationApproved && comment.ModeratedBy == nil && !n.onApproved {
+		return nil
+	}
+	data := map[string]interface{}{"status": comment.ModerationStatus}
+	if comment.ModerationReason != "" {
+		data["reason"] = comment.ModerationReason
+	}
+	return n.notifications.Notify(ctx, n.commentNotification(comment, comment.UserID, notification.TypeModeration, "", data))
+}
+
+func (n *InAppNotifier) Replied(ctx context.Context, reply *Comment, parent *Comment) error {
+	return n.notifications.Notify(ctx, n.commentNotification(reply, parent.UserID, notification.TypeReply,
+		"reply:"+reply.ID.String(), map[string]interface{}{"parent_id": parent.ID}))
+}
+
+func (n *InAppNotifier) Mentioned(ctx context.Context, comment *Comment, userIDs []uuid.UUID) error {
+	var errs []error
+	for _, userID := range userIDs {
+		errs = append(errs, n.notifications.Notify(ctx, n.commentNotification(comment, userID, notification.TypeMention,
+			"mention:"+comment.ID.String(), nil)))
+	}
+	return errors.Join(errs...)
+}
+
+func (n *InAppNotifier) VoteMilestone(ctx context.Context, comment *Comment, milestone int) error {
+	return n.notifications.Notify(ctx, n.commentNotification(comment, comment.UserID, notification.TypeVoteMilestone,
+		fmt.Sprintf("vote:%s:%d", comment.ID, milestone), map[string]interface{}{"score": milestone}))
+}
+
+// Removed убирает уведомления с отрывком текста, который больше не виден
+func (n *InAppNotifier) Removed(ctx context.Context, commentIDs []uuid.UUID) error {
+	return n.notifications.ForgetComments(ctx, commentIDs)
+}
+
+// excerptLength - сколько символов текста показывать в уведомлении
+const excerptLength = 100
+
+// commentNotification - уведомление recipient о комментарии. Автор
+// комментария указывается как actor, кроме уведомлений о нем самом.
+// Отрывок берется из разобранного текста: без разметки и спойлеров.
+func (n *InAppNotifier) commentNotification(comment *Comment, recipient uuid.UUID, notificationType, dedupKey string, data map[string]interface{}) *notification.Notification {
+	excerpt := markup.PlainText(n.markup.Parse(comment.Content).Nodes)
+	if utf8.RuneCountInString(excerpt) > excerptLength {
+		excerpt = string([]rune(excerpt)[:excerptLength]) + "…"
+	}
+	notice := &notification.Notification{
+		UserID:    recipient,
+		Type:      notificationType,
+		CommentID: &comment.ID,
+		AnimeID:   comment.AnimeID,
+		Excerpt:   excerpt,
+		Data:      data,
+	}
+	if recipient != comment.UserID {
+		notice.ActorID = &comment.UserID
+	}
+	if dedupKey != "" {
+		notice.DedupKey = &dedupKey
+	}
+	return notice
+}
+
+// MailNotifier пишет автору на почту о решениях модерации. По умолчанию
+// только об отклонении: об одобрении автор узнает, увидев комментарий
+// опубликованным.
+type MailNotifier struct {
+	noopNotifier
+	repo       Repository
+	mailer     mailer.Mailer
+	onApproved bool
+	label      float64 // С какой оценки категория считается проблемной
+}
+
+// NewMailNotifier создает уведомления по почте. onApproved - писать и
+// об одобренных комментариях.
+func NewMailNotifier(repo Repository, m mailer.Mailer, onApproved bool) *MailNotifier {
+	return &MailNotifier{
+		repo:       repo,
+		mailer:     m,
+		onApproved: onApproved,
+		label:      moderation.ThresholdsFromEnv().Label,
+	}
+}
+
+func (n *MailNotifier) ModerationDecided(ctx context.Context, comment *Comment) error {
+	if comment.ModerationStatus == ModerationApproved && !n.onApproved {
+		return nil
+	}
+	email, err := n.repo.GetAuthorEmail(comment.UserID)
+	if err != nil || email == "" {
+		return err
+	}
+
+	msg := mailer.Message{To: email}
+	switch comment.ModerationStatus {
+	case ModerationApproved:
+		msg.Subject = "Ваш комментарий опубликован"
+		msg.Body = fmt.Sprintf("Ваш комментарий прошел проверку и опубликован:\n\n%s\n", comment.Content)
+	case ModerationRejected:
+		var reasons []string
+		for category, score := range comment.ModerationDetails {
+			if score >= n.label {
+				reasons = append(reasons, category)
+			}
+		}
+		sort.Strings(reasons)
+		msg.Subject = "Ваш комментарий отклонен"
+		if comment.ModeratedBy != nil {
+			msg.Body = fmt.Sprintf("Ваш комментарий был отклонен модератором:\n\n%s\n\n", comment.Content)
+			msg.Body += fmt.Sprintf("Причина: %s.\n", comment.ModerationReason)
+		} else {
+			msg.Body = fmt.Sprintf("Ваш комментарий был отклонен системой модерации:\n\n%s\n\n", comment.Content)
+		}
+		if len(reasons) > 0 {
+			msg.Body += fmt.Sprintf("Проблемные категории: %s.\n", strings.Join(reasons, ", "))
+		}
+		msg.Body += "Вы можете отредактировать комментарий, и он будет проверен снова.\n"
+	default:
+		return nil
+	}
+	return n.mailer.Send(ctx, msg)
+}
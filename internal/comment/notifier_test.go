@@ -0,0 +1,204 @@
+package comment
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/Zipklas/anime-site-backend/internal/markup"
+	"github.com/Zipklas/anime-site-backend/internal/notification"
+	"github.com/google/uuid"
+)
+
+// fakeNotifications записывает уведомления вместо сохранения
+type fakeNotifications struct {
+	notification.Service
+	sent      []*notification.Notification
+	forgotten []uuid.UUID
+}
+
+func (f *fakeNotifications) Notify(ctx context.Context, n *notification.Notification) error {
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+func (f *fakeNotifications) ForgetComments(ctx context.Context, commentIDs []uuid.UUID) error {
+	f.forgotten = append(f.forgotten, commentIDs...)
+	return nil
+}
+
+func TestInAppNotifierExcerpt(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"plain", "Отличная серия", "Отличная серия"},
+		{"markup removed", "**Очень** *хорошо*, [ссылка](https://shikimori.one/animes/1)", "Очень хорошо, ссылка"},
+		{"spoiler masked", "В конце ||главный герой умирает||!", "В конце " + markup.SpoilerPlaceholder + "!"},
+		{"quote and lines", "> цитата\n\nответ\nвторая строка", "цитата ответ вторая строка"},
+		{"html kept as text", "<script>alert(1)</script>", "<script>alert(1)</script>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeNotifications{}
+			n := NewInAppNotifier(sink, markup.NewParser([]string{"shikimori.one"}), false)
+			comment := &Comment{ID: uuid.New(), UserID: uuid.New(), Content: tt.content}
+
+			if err := n.Mentioned(context.Background(), comment, []uuid.UUID{uuid.New()}); err != nil {
+				t.Fatal(err)
+			}
+			if got := sink.sent[0].Excerpt; got != tt.want {
+				t.Errorf("excerpt = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInAppNotifierExcerptIsTruncated(t *testing.T) {
+	sink := &fakeNotifications{}
+	n := NewInAppNotifier(sink, markup.NewParser(nil), false)
+	comment := &Comment{ID: uuid.New(), UserID: uuid.New(), Content: strings.Repeat("я", excerptLength) + "||спойлер||"}
+
+	if err := n.Mentioned(context.Background(), comment, []uuid.UUID{uuid.New()}); err != nil {
+		t.Fatal(err)
+	}
+	excerpt := sink.sent[0].Excerpt
+	if utf8.RuneCountInString(excerpt) != excerptLength+1 || strings.Contains(excerpt, "спойлер") {
+		t.Errorf("excerpt = %q", excerpt)
+	}
+}
+
+func TestInAppNotifierModerationDecided(t *testing.T) {
+	moderator := uuid.New()
+	tests := []struct {
+		name        string
+		status      string
+		moderatedBy *uuid.UUID
+		onApproved  bool
+		notified    bool
+	}{
+		{"auto approved", ModerationApproved, nil, false, false},
+		{"auto approved with onApproved", ModerationApproved, nil, true, true},
+		{"approved by moderator", ModerationApproved, &moderator, false, true},
+		{"auto rejected", ModerationRejected, nil, false, true},
+		{"rejected by moderator", ModerationRejected, &moderator, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &fakeNotifications{}
+			n := NewInAppNotifier(sink, markup.NewParser(nil), tt.onApproved)
+			comment := &Comment{ID: uuid.New(), UserID: uuid.New(), ModerationStatus: tt.status, ModeratedBy: tt.moderatedBy}
+
+			if err := n.ModerationDecided(context.Background(), comment); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(sink.sent) > 0; got != tt.notified {
+				t.Errorf("notified = %v, want %v", got, tt.notified)
+			}
+		})
+	}
+}
+
+func TestCrossedMilestones(t *testing.T) {
+	tests := []struct {
+		before, after int
+		want          []int
+	}{
+		{9, 10, []int{10}},
+		{8, 10, []int{10}},
+		{9, 11, []int{10}}, // минус сменился на плюс
+		{10, 11, nil},
+		{11, 9, nil},
+		{10, 9, nil},
+		{48, 50, []int{50}},
+		{-1, 1, nil},
+		{0, 1000, []int{10, 50, 100, 500, 1000}},
+	}
+	for _, tt := range tests {
+		if got := crossedMilestones(tt.before, tt.after); !slices.Equal(got, tt.want) {
+			t.Errorf("crossedMilestones(%d, %d) = %v, want %v", tt.before, tt.after, got, tt.want)
+		}
+	}
+}
+
+// voteRepo отдает заданный рейтинг до и после голоса
+type voteRepo struct {
+	*fakeRepo
+	before, after int
+}
+
+func (r *voteRepo) AddVote(commentID uuid.UUID, userID uuid.UUID, isUpvote bool) (int, int, error) {
+	return r.before, r.after, nil
+}
+
+func TestVoteCommentNotifiesWhenMilestoneCrossed(t *testing.T) {
+	comment := &Comment{ID: uuid.New(), UserID: uuid.New(), ModerationStatus: ModerationApproved}
+	notifier := &recordingNotifier{}
+	s := newTestService(&voteRepo{fakeRepo: newFakeRepo(comment), before: 9, after: 11}, nil, notifier)
+
+	if err := s.VoteComment(context.Background(), comment.ID, uuid.New(), true); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(notifier.milestones, []int{10}) {
+		t.Errorf("milestones = %v, want [10]", notifier.milestones)
+	}
+}
+
+// removalRepo считает удаление и скрытие успешными
+type removalRepo struct {
+	*fakeRepo
+}
+
+func (r *removalRepo) Delete(commentID uuid.UUID, userID uuid.UUID) error { return nil }
+
+func (r *removalRepo) ForceDelete(commentID uuid.UUID, moderatorID uuid.UUID, reason string) error {
+	return nil
+}
+
+func (r *removalRepo) SetHidden(commentID uuid.UUID, hidden bool, moderatorID uuid.UUID, reason string) error {
+	return nil
+}
+
+func TestRemovedCommentsDropNotifications(t *testing.T) {
+	ctx := context.Background()
+	id := uuid.New()
+	tests := []struct {
+		name    string
+		action  func(s *service) error
+		removed bool
+	}{
+		{"deleted by author", func(s *service) error { return s.DeleteComment(ctx, id, uuid.New(), false, "") }, true},
+		{"deleted by moderator", func(s *service) error { return s.DeleteComment(ctx, id, uuid.New(), true, "spam") }, true},
+		{"hidden", func(s *service) error { return s.HideComment(ctx, id, uuid.New(), true, "spam") }, true},
+		{"unhidden", func(s *service) error { return s.HideComment(ctx, id, uuid.New(), false, "") }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notifier := &recordingNotifier{}
+			s := newTestService(&removalRepo{newFakeRepo()}, nil, notifier)
+			if err := tt.action(s); err != nil {
+				t.Fatal(err)
+			}
+			if got := slices.Contains(notifier.removed, id); got != tt.removed {
+				t.Errorf("notifications removed = %v, want %v", got, tt.removed)
+			}
+		})
+	}
+}
+
+func TestAnnounceSkipsCommentsAwaitingModeration(t *testing.T) {
+	parent := &Comment{ID: uuid.New(), UserID: uuid.New(), ModerationStatus: ModerationApproved, IsApproved: true}
+	notifier := &recordingNotifier{}
+	s := newTestService(newFakeRepo(parent), nil, notifier)
+
+	for _, status := range []string{ModerationPending, ModerationRejected, ModerationApproved} {
+		reply := &Comment{ID: uuid.New(), UserID: uuid.New(), ParentID: &parent.ID, ModerationStatus: status}
+		s.announce(context.Background(), reply)
+	}
+	if notifier.replies != 1 {
+		t.Errorf("reply notifications = %d, want 1 (approved only)", notifier.replies)
+	}
+}
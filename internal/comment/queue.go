@@ -0,0 +1,212 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownJobStatus = errors.New("unknown moderation job status")
+
+// Режимы модерации (MODERATION_MODE)
+const (
+	ModerationSync  = "sync"  // Проверка до ответа на запрос
+	ModerationAsync = "async" // Комментарий ждет проверки воркером
+)
+
+const (
+	defaultModerationWorkers     = 2
+	defaultModerationMaxAttempts = 5
+
+	jobPollInterval = time.Second
+	// jobLease - сколько задача числится за воркером. Если воркер не
+	// отчитался за это время, задачу заберет другой.
+	jobLease = 2 * time.Minute
+
+	// jobTimeout - сколько ждать проверки одной задачи. Остановка сервера
+	// не прерывает начатую задачу, а ждет ее завершения.
+	jobTimeout = time.Minute
+
+	jobRetryBase = 10 * time.Second
+	jobRetryMax  = 10 * time.Minute
+)
+
+// queueConfig - настройки асинхронной модерации из MODERATION_MODE,
+// MODERATION_WORKERS и MODERATION_MAX_ATTEMPTS
+type queueConfig struct {
+	async       bool
+	workers     int
+	maxAttempts int
+}
+
+func queueConfigFromEnv() queueConfig {
+	cfg := queueConfig{
+		workers:     defaultModerationWorkers,
+		maxAttempts: defaultModerationMaxAttempts,
+	}
+	switch mode := os.Getenv("MODERATION_MODE"); mode {
+	case "", ModerationSync:
+	case ModerationAsync:
+		cfg.async = true
+	default:
+		log.Printf("unknown MODERATION_MODE %q, using %q", mode, ModerationSync)
+	}
+	if n, err := strconv.Atoi(os.Getenv("MODERATION_WORKERS")); err == nil && n > 0 {
+		cfg.workers = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("MODERATION_MAX_ATTEMPTS")); err == nil && n > 0 {
+		cfg.maxAttempts = n
+	}
+	return cfg
+}
+
+// RunModerationWorkers обрабатывает очередь модерации до отмены ctx и
+// возвращается, когда воркеры доделают начатые задачи. В синхронном
+// режиме сразу возвращается.
+func (s *service) RunModerationWorkers(ctx context.Context) {
+	if !s.queue.async {
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < s.queue.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.moderationWorker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *service) moderationWorker(ctx context.Context) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		jobs, err := s.repo.ClaimModerationJobs(1, jobLease)
+		if err != nil {
+			log.Printf("Failed to claim moderation jobs: %v", err)
+		}
+		for i := range jobs {
+			jobCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobTimeout)
+			s.processModerationJob(jobCtx, &jobs[i])
+			cancel()
+		}
+		// Пока очередь не пуста, берем следующую задачу сразу
+		if len(jobs) > 0 {
+			timer.Reset(0)
+		} else {
+			timer.Reset(jobPollInterval)
+		}
+	}
+}
+
+// processModerationJob проверяет комментарий задачи. Ошибка проверки
+// откладывает задачу с растущей паузой, после MODERATION_MAX_ATTEMPTS
+// попыток задача уходит в dead, а комментарий остается ждать модератора.
+func (s *service) processModerationJob(ctx context.Context, job *ModerationJob) {
+	comment, err := s.repo.GetByID(job.CommentID)
+	if errors.Is(err, ErrCommentNotFound) {
+		s.finishJob(job)
+		return
+	}
+	if err != nil {
+		s.retryJob(job, err)
+		return
+	}
+	// Комментарий удален или его уже проверил модератор
+	if comment.DeletedAt != nil || comment.ModerationStatus != ModerationPending || comment.ModeratedBy != nil {
+		s.finishJob(job)
+		return
+	}
+	// Задачу уже забирали столько раз, что воркеры, видимо, падают на ней
+	if job.Attempts > s.queue.maxAttempts {
+		s.retryJob(job, errors.New("lease expired too many times"))
+		return
+	}
+
+	verdict, err := s.moderator.Moderate(ctx, comment.Content)
+	if err != nil {
+		s.retryJob(job, err)
+		return
+	}
+
+	status := verdictStatuses[verdict.Decision]
+	updated, err := s.repo.CompleteModerationJob(job, comment.Content, status, verdict)
+	if err != nil {
+		log.Printf("Failed to complete moderation job %s: %v", job.ID, err)
+		return
+	}
+	if updated == nil || updated.ModerationStatus == ModerationPending {
+		return
+	}
+	if err := s.notifier.ModerationDecided(ctx, updated); err != nil {
+		log.Printf("Failed to notify author of comment %s: %v", updated.ID, err)
+	}
+	s.announce(ctx, updated)
+}
+
+func (s *service) finishJob(job *ModerationJob) {
+	if err := s.repo.FinishModerationJob(job); err != nil {
+		log.Printf("Failed to finish moderation job %s: %v", job.ID, err)
+	}
+}
+
+func (s *service) retryJob(job *ModerationJob, cause error) {
+	dead := job.Attempts >= s.queue.maxAttempts
+	if dead {
+		log.Printf("Moderation job %s for comment %s is dead after %d attempts: %v",
+			job.ID, job.CommentID, job.Attempts, cause)
+	}
+	runAt := time.Now().Add(jobBackoff(job.Attempts))
+	if err := s.repo.RetryModerationJob(job, runAt, cause.Error(), dead); err != nil {
+		log.Printf("Failed to reschedule moderation job %s: %v", job.ID, err)
+	}
+}
+
+// jobBackoff - пауза перед следующей попыткой: удваивается с каждой
+// попыткой до jobRetryMax, половина паузы случайная
+func jobBackoff(attempts int) time.Duration {
+	if attempts < 1 {
+		attempts = 1
+	}
+	delay := jobRetryMax
+	if attempts < 16 {
+		delay = jobRetryBase << (attempts - 1)
+	}
+	if delay > jobRetryMax || delay <= 0 {
+		delay = jobRetryMax
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// ListModerationJobs возвращает задачи модерации со статусом status
+// (по умолчанию dead)
+func (s *service) ListModerationJobs(ctx context.Context, status string, limit, offset int) ([]ModerationJob, error) {
+	if status == "" {
+		status = JobDead
+	}
+	switch status {
+	case JobQueued, JobRunning, JobDone, JobDead:
+	default:
+		return nil, ErrUnknownJobStatus
+	}
+	return s.repo.ListModerationJobs(status, limit, offset)
+}
+
+// RetryModerationJob возвращает задачу из dead в очередь
+func (s *service) RetryModerationJob(ctx context.Context, jobID uuid.UUID) error {
+	return s.repo.RequeueModerationJob(jobID)
+}
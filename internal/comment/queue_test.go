@@ -0,0 +1,238 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/markup"
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/google/uuid"
+)
+
+// fakeRepo реализует только нужные тестам методы Repository,
+// остальные паникуют через nil-интерфейс
+type fakeRepo struct {
+	Repository
+
+	comments map[uuid.UUID]*Comment
+	queued   []*Comment
+	created  []*Comment
+	finished []*ModerationJob
+	retried  []retriedJob
+	complete []string
+}
+
+type retriedJob struct {
+	job  *ModerationJob
+	dead bool
+}
+
+func newFakeRepo(comments ...*Comment) *fakeRepo {
+	r := &fakeRepo{comments: map[uuid.UUID]*Comment{}}
+	for _, c := range comments {
+		r.comments[c.ID] = c
+	}
+	return r
+}
+
+func (r *fakeRepo) GetByID(id uuid.UUID) (*Comment, error) {
+	c, ok := r.comments[id]
+	if !ok {
+		return nil, ErrCommentNotFound
+	}
+	copied := *c
+	return &copied, nil
+}
+
+func (r *fakeRepo) IsUserVerified(userID uuid.UUID) (bool, error) { return true, nil }
+
+func (r *fakeRepo) Create(comment *Comment) error {
+	r.created = append(r.created, comment)
+	r.comments[comment.ID] = comment
+	return nil
+}
+
+func (r *fakeRepo) CreateQueued(comment *Comment) error {
+	r.queued = append(r.queued, comment)
+	r.comments[comment.ID] = comment
+	return nil
+}
+
+func (r *fakeRepo) FindUsersByUsername(usernames []string) ([]uuid.UUID, error) { return nil, nil }
+
+func (r *fakeRepo) FinishModerationJob(job *ModerationJob) error {
+	r.finished = append(r.finished, job)
+	return nil
+}
+
+func (r *fakeRepo) RetryModerationJob(job *ModerationJob, runAt time.Time, lastError string, dead bool) error {
+	r.retried = append(r.retried, retriedJob{job: job, dead: dead})
+	return nil
+}
+
+func (r *fakeRepo) CompleteModerationJob(job *ModerationJob, content string, status string, verdict *moderation.Verdict) (*Comment, error) {
+	r.complete = append(r.complete, status)
+	c := r.comments[job.CommentID]
+	applyVerdict(c, status, verdict)
+	copied := *c
+	return &copied, nil
+}
+
+type fakeModerator struct {
+	verdict *moderation.Verdict
+	err     error
+}
+
+func (m *fakeModerator) Name() string { return "fake" }
+
+func (m *fakeModerator) Moderate(ctx context.Context, text string) (*moderation.Verdict, error) {
+	return m.verdict, m.err
+}
+
+type recordingNotifier struct {
+	noopNotifier
+	decided    []string
+	replies    int
+	milestones []int
+	removed    []uuid.UUID
+}
+
+func (n *recordingNotifier) VoteMilestone(ctx context.Context, comment *Comment, milestone int) error {
+	n.milestones = append(n.milestones, milestone)
+	return nil
+}
+
+func (n *recordingNotifier) Removed(ctx context.Context, commentIDs []uuid.UUID) error {
+	n.removed = append(n.removed, commentIDs...)
+	return nil
+}
+
+func (n *recordingNotifier) ModerationDecided(ctx context.Context, comment *Comment) error {
+	n.decided = append(n.decided, comment.ModerationStatus)
+	return nil
+}
+
+func (n *recordingNotifier) Replied(ctx context.Context, reply *Comment, parent *Comment) error {
+	n.replies++
+	return nil
+}
+
+func newTestService(repo Repository, moderator moderation.Moderator, notifier Notifier) *service {
+	return &service{
+		repo:       repo,
+		moderator:  moderator,
+		moderation: moderationConfig{failurePolicy: FailQueue, reviewThreshold: 0.5},
+		queue:      queueConfig{async: true, workers: 1, maxAttempts: 3},
+		notifier:   notifier,
+		markup:     markup.NewParser(nil),
+	}
+}
+
+func pendingComment() *Comment {
+	c := &Comment{ID: uuid.New(), AnimeID: "1", UserID: uuid.New(), Content: "text"}
+	markQueued(c)
+	return c
+}
+
+func TestProcessModerationJob(t *testing.T) {
+	approve := &moderation.Verdict{Decision: moderation.Approve, Backend: "fake"}
+	reject := &moderation.Verdict{Decision: moderation.Reject, Score: 0.95, Backend: "fake"}
+	failure := errors.New("timeout")
+
+	tests := []struct {
+		name      string
+		comment   func() *Comment
+		attempts  int
+		moderator *fakeModerator
+		finished  bool
+		complete  string
+		retried   bool
+		dead      bool
+		decided   []string
+	}{
+		{name: "approved", comment: pendingComment, attempts: 1,
+			moderator: &fakeModerator{verdict: approve}, complete: ModerationApproved, decided: []string{ModerationApproved}},
+		{name: "rejected", comment: pendingComment, attempts: 1,
+			moderator: &fakeModerator{verdict: reject}, complete: ModerationRejected, decided: []string{ModerationRejected}},
+		{name: "retry on error", comment: pendingComment, attempts: 1,
+			moderator: &fakeModerator{err: failure}, retried: true},
+		{name: "dead after max attempts", comment: pendingComment, attempts: 3,
+			moderator: &fakeModerator{err: failure}, retried: true, dead: true},
+		{name: "lease expired too often", comment: pendingComment, attempts: 4,
+			moderator: &fakeModerator{verdict: approve}, retried: true, dead: true},
+		{name: "already reviewed by moderator", comment: func() *Comment {
+			c := pendingComment()
+			moderator := uuid.New()
+			c.ModeratedBy = &moderator
+			return c
+		}, attempts: 1, moderator: &fakeModerator{verdict: approve}, finished: true},
+		{name: "deleted", comment: func() *Comment {
+			c := pendingComment()
+			now := time.Now()
+			c.DeletedAt = &now
+			return c
+		}, attempts: 1, moderator: &fakeModerator{verdict: approve}, finished: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			comment := tt.comment()
+			repo := newFakeRepo(comment)
+			notifier := &recordingNotifier{}
+			s := newTestService(repo, tt.moderator, notifier)
+
+			s.processModerationJob(context.Background(), &ModerationJob{ID: uuid.New(), CommentID: comment.ID, Attempts: tt.attempts})
+
+			if got := len(repo.finished) > 0; got != tt.finished {
+				t.Errorf("finished = %v, want %v", got, tt.finished)
+			}
+			if got := len(repo.retried) > 0; got != tt.retried {
+				t.Fatalf("retried = %v, want %v", got, tt.retried)
+			}
+			if tt.retried && repo.retried[0].dead != tt.dead {
+				t.Errorf("dead = %v, want %v", repo.retried[0].dead, tt.dead)
+			}
+			if tt.complete != "" && (len(repo.complete) != 1 || repo.complete[0] != tt.complete) {
+				t.Errorf("completed with %v, want %s", repo.complete, tt.complete)
+			}
+			if len(notifier.decided) != len(tt.decided) {
+				t.Errorf("notified %v, want %v", notifier.decided, tt.decided)
+			}
+		})
+	}
+}
+
+func TestCreateCommentAsyncIsPendingAndSilent(t *testing.T) {
+	parent := &Comment{ID: uuid.New(), AnimeID: "1", UserID: uuid.New(), ModerationStatus: ModerationApproved, IsApproved: true}
+	repo := newFakeRepo(parent)
+	notifier := &recordingNotifier{}
+	s := newTestService(repo, &fakeModerator{verdict: &moderation.Verdict{Decision: moderation.Approve}}, notifier)
+
+	comment, err := s.CreateComment(context.Background(), Thread{AnimeID: "1"}, "reply", uuid.New(), &parent.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(repo.queued) != 1 || len(repo.created) != 0 {
+		t.Fatalf("queued %d, created %d: want the comment queued", len(repo.queued), len(repo.created))
+	}
+	if comment.ModerationStatus != ModerationPending || comment.IsApproved || comment.visible() {
+		t.Errorf("queued comment is public: status %q, approved %v", comment.ModerationStatus, comment.IsApproved)
+	}
+	if notifier.replies != 0 {
+		t.Errorf("reply notification sent before moderation")
+	}
+}
+
+func TestJobBackoff(t *testing.T) {
+	for attempts := 0; attempts <= 40; attempts++ {
+		delay := jobBackoff(attempts)
+		base := jobRetryBase << max(attempts-1, 0)
+		if attempts > 16 || base > jobRetryMax {
+			base = jobRetryMax
+		}
+		if delay < base/2 || delay > base {
+			t.Errorf("jobBackoff(%d) = %v, want within [%v, %v]", attempts, delay, base/2, base)
+		}
+	}
+}
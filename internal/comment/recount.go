@@ -0,0 +1,62 @@
+package comment
+
+import "gorm.io/gorm"
+
+// RecountResult - сколько записей исправил пересчет
+type RecountResult struct {
+	SelfVotesRemoved int64 `json:"self_votes_removed"`
+	CommentsFixed    int64 `json:"comments_fixed"`
+	UsersFixed       int64 `json:"users_fixed"`
+}
+
+// RecountVotes пересобирает счетчики комментариев и карму пользователей
+// из comment_votes. Голоса за свои комментарии удаляются. На время
+// пересчета таблица голосов блокируется от записи.
+func (r *repository) RecountVotes() (*RecountResult, error) {
+	var result RecountResult
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("LOCK TABLE comment_votes IN SHARE MODE").Error; err != nil {
+			return err
+		}
+
+		res := tx.Exec(`DELETE FROM comment_votes USING comments
+WHERE comments.id = comment_votes.comment_id AND comments.user_id = comment_votes.user_id`)
+		if res.Error != nil {
+			return res.Error
+		}
+		result.SelfVotesRemoved = res.RowsAffected
+
+		res = tx.Exec(`UPDATE comments SET
+	upvotes = COALESCE(v.upvotes, 0),
+	downvotes = COALESCE(v.downvotes, 0),
+	score = COALESCE(v.upvotes - v.downvotes, 0)
+FROM comments c
+LEFT JOIN (
+	SELECT comment_id, COUNT(*) FILTER (WHERE is_upvote) AS upvotes, COUNT(*) FILTER (WHERE NOT is_upvote) AS downvotes
+	FROM comment_votes GROUP BY comment_id
+) v ON v.comment_id = c.id
+WHERE comments.id = c.id
+	AND (comments.upvotes, comments.downvotes, comments.score) <>
+		(COALESCE(v.upvotes, 0), COALESCE(v.downvotes, 0), COALESCE(v.upvotes - v.downvotes, 0))`)
+		if res.Error != nil {
+			return res.Error
+		}
+		result.CommentsFixed = res.RowsAffected
+
+		res = tx.Exec(`UPDATE users SET karma = COALESCE(k.karma, 0)
+FROM users u
+LEFT JOIN (
+	SELECT user_id, SUM(score) AS karma FROM comments WHERE deleted_at IS NULL GROUP BY user_id
+) k ON k.user_id = u.id
+WHERE users.id = u.id AND users.karma <> COALESCE(k.karma, 0)`)
+		if res.Error != nil {
+			return res.Error
+		}
+		result.UsersFixed = res.RowsAffected
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,73 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Категории жалоб
+var reportCategories = map[string]bool{
+	"spam":     true,
+	"abuse":    true, // Оскорбления, травля
+	"spoiler":  true,
+	"offtopic": true,
+	"illegal":  true,
+	"other":    true,
+}
+
+// Порог автоматического скрытия по умолчанию: столько разных пользователей
+// должны пожаловаться (COMMENT_REPORT_HIDE_THRESHOLD, 0 - не скрывать)
+const defaultReportHideThreshold = 3
+
+// maxReportTextLength - ограничение пояснения к жалобе
+const maxReportTextLength = 500
+
+var (
+	ErrInvalidReportCategory = errors.New("unknown category, use spam, abuse, spoiler, offtopic, illegal or other")
+	ErrReportTextTooLong     = errors.New("report text is too long")
+	ErrSelfReport            = errors.New("you cannot report your own comment")
+	ErrAlreadyReported       = errors.New("you have already reported this comment")
+)
+
+// ReportComment сохраняет жалобу пользователя на видимый ему комментарий
+func (s *service) ReportComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, category, text string) (*CommentReport, error) {
+	if !reportCategories[category] {
+		return nil, ErrInvalidReportCategory
+	}
+	text = strings.TrimSpace(text)
+	if utf8.RuneCountInString(text) > maxReportTextLength {
+		return nil, ErrReportTextTooLong
+	}
+
+	comment, err := s.repo.GetByID(commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment.DeletedAt != nil || (!comment.visible() && comment.UserID != userID) {
+		return nil, ErrCommentNotFound
+	}
+
+	report := &CommentReport{
+		ID:        uuid.New(),
+		CommentID: commentID,
+		UserID:    userID,
+		Category:  category,
+		Text:      text,
+	}
+	if _, err := s.repo.AddReport(report, s.reportHideThreshold); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// GetReports возвращает все жалобы на комментарий, новые сверху
+func (s *service) GetReports(ctx context.Context, commentID uuid.UUID) ([]CommentReport, error) {
+	if _, err := s.repo.GetByID(commentID); err != nil {
+		return nil, err
+	}
+	return s.repo.ListReports(commentID)
+}
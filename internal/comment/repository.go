@@ -2,20 +2,55 @@ package comment
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
+	"github.com/Zipklas/anime-site-backend/internal/user"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrCommentNotFound = errors.New("comment not found")
+	ErrJobNotFound     = errors.New("dead moderation job not found")
+	ErrSelfVote        = errors.New("you cannot vote for your own comment")
 )
 
 type Repository interface {
 	Create(comment *Comment) error
-	GetByAnimeID(animeID string, userID uuid.UUID) ([]CommentWithUser, error)
+	CreateQueued(comment *Comment) error
+	List(opts ListOptions) ([]CommentWithUser, error)
+	GetByID(commentID uuid.UUID) (*Comment, error)
+	GetDescendants(parentIDs []uuid.UUID, maxDepth, perParent, limit int, userID uuid.UUID, includeHidden bool) ([]CommentWithUser, error)
 	Delete(commentID uuid.UUID, userID uuid.UUID) error
-	Update(comment *Comment) error
+	ForceDelete(commentID uuid.UUID, moderatorID uuid.UUID, reason string) error
+	SetHidden(commentID uuid.UUID, hidden bool, moderatorID uuid.UUID, reason string) error
+	SetModeration(commentIDs []uuid.UUID, status string, moderatorID uuid.UUID, reason string) ([]uuid.UUID, error)
+	ListActions(filter ActionFilter) ([]ModerationAction, error)
+	UpdateContent(commentID uuid.UUID, userID uuid.UUID, update ContentUpdate) (*Comment, error)
+	ListRevisions(commentID uuid.UUID) ([]CommentRevision, error)
+	PurgeDeleted(before time.Time) ([]uuid.UUID, error)
+	ClaimModerationJobs(limit int, lease time.Duration) ([]ModerationJob, error)
+	CompleteModerationJob(job *ModerationJob, content string, status string, verdict *moderation.Verdict) (*Comment, error)
+	RetryModerationJob(job *ModerationJob, runAt time.Time, lastError string, dead bool) error
+	FinishModerationJob(job *ModerationJob) error
+	ListModerationJobs(status string, limit, offset int) ([]ModerationJob, error)
+	RequeueModerationJob(jobID uuid.UUID) error
+	GetAuthorEmail(userID uuid.UUID) (string, error)
+	FindUsersByUsername(usernames []string) ([]uuid.UUID, error)
+	CountByEpisode(animeID string) ([]EpisodeCount, error)
+	WatchedEpisodes(userID uuid.UUID, animeID string) (*int, error)
+	AddReport(report *CommentReport, hideThreshold int) (hidden bool, err error)
+	ListReports(commentID uuid.UUID) ([]CommentReport, error)
 	GetUserVote(commentID uuid.UUID, userID uuid.UUID) (*bool, error)
 	GetVotes(commentID uuid.UUID) (upvotes, downvotes int, err error)
-	AddVote(commentID uuid.UUID, userID uuid.UUID, isUpvote bool) error
+	AddVote(commentID uuid.UUID, userID uuid.UUID, isUpvote bool) (before, after int, err error)
 	RemoveVote(commentID uuid.UUID, userID uuid.UUID) error
+	IsUserVerified(userID uuid.UUID) (bool, error)
+	RecountVotes() (*RecountResult, error)
 }
 
 type repository struct {
@@ -30,39 +65,262 @@ func (r *repository) Create(comment *Comment) error {
 	return r.db.Create(comment).Error
 }
 
-func (r *repository) AddVote(commentID uuid.UUID, userID uuid.UUID, isUpvote bool) error {
+// CreateQueued сохраняет комментарий вместе с задачей его модерации
+func (r *repository) CreateQueued(comment *Comment) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(comment).Error; err != nil {
+			return err
+		}
+		return enqueueModeration(tx, comment.ID)
+	})
+}
+
+// enqueueModeration ставит комментарий в очередь модерации. Если задача
+// уже есть, она перезапускается с новой ревизией.
+func enqueueModeration(tx *gorm.DB, commentID uuid.UUID) error {
+	now := time.Now()
+	return tx.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "comment_id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"status":       JobQueued,
+			"run_at":       now,
+			"attempts":     0,
+			"revision":     gorm.Expr("moderation_jobs.revision + 1"),
+			"locked_until": nil,
+			"last_error":   "",
+			"updated_at":   now,
+		}),
+	}).Create(&ModerationJob{
+		ID:        uuid.New(),
+		CommentID: commentID,
+		Status:    JobQueued,
+		RunAt:     now,
+		Revision:  1,
+	}).Error
+}
+
+// ClaimModerationJobs забирает до limit готовых задач и задачи, чья
+// аренда истекла (воркер упал). Параллельные воркеры не получают одну
+// задачу благодаря SKIP LOCKED.
+func (r *repository) ClaimModerationJobs(limit int, lease time.Duration) ([]ModerationJob, error) {
+	now := time.Now()
+	var jobs []ModerationJob
+	err := r.db.Raw(`UPDATE moderation_jobs SET status = @running, attempts = attempts + 1,
+	locked_until = @lease, updated_at = @now
+WHERE id IN (
+	SELECT id FROM moderation_jobs
+	WHERE (status = @queued AND run_at <= @now) OR (status = @running AND locked_until < @now)
+	ORDER BY run_at
+	LIMIT @limit
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING *`, map[string]interface{}{
+		"running": JobRunning,
+		"queued":  JobQueued,
+		"now":     now,
+		"lease":   now.Add(lease),
+		"limit":   limit,
+	}).Scan(&jobs).Error
+	return jobs, err
+}
+
+// CompleteModerationJob применяет вердикт, если комментарий все еще ждет
+// автоматической проверки и его текст не менялся, и закрывает задачу.
+// Возвращает обновленный комментарий или nil, если вердикт устарел.
+func (r *repository) CompleteModerationJob(job *ModerationJob, content string, status string, verdict *moderation.Verdict) (*Comment, error) {
+	var comment *Comment
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		update := Comment{}
+		applyVerdict(&update, status, verdict)
+		result := tx.Model(&Comment{}).
+			Select("moderation_status", "is_approved", "toxicity_score", "moderation_details", "moderation_backend").
+			Where("id = ? AND content = ? AND moderation_status = ? AND moderated_by IS NULL AND deleted_at IS NULL",
+				job.CommentID, content, ModerationPending).
+			Updates(&update)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected > 0 {
+			comment = &Comment{}
+			if err := tx.First(comment, "id = ?", job.CommentID).Error; err != nil {
+				return err
+			}
+		}
+		return finishJob(tx, job, map[string]interface{}{"status": JobDone})
+	})
+	return comment, err
+}
+
+// RetryModerationJob возвращает задачу в очередь на runAt или, при dead,
+// откладывает ее навсегда
+func (r *repository) RetryModerationJob(job *ModerationJob, runAt time.Time, lastError string, dead bool) error {
+	status := JobQueued
+	if dead {
+		status = JobDead
+	}
+	return finishJob(r.db, job, map[string]interface{}{
+		"status":     status,
+		"run_at":     runAt,
+		"last_error": lastError,
+	})
+}
+
+// FinishModerationJob закрывает задачу, проверка которой больше не нужна
+func (r *repository) FinishModerationJob(job *ModerationJob) error {
+	return finishJob(r.db, job, map[string]interface{}{"status": JobDone})
+}
+
+// finishJob обновляет задачу, если ее не перезапустили после захвата
+func finishJob(tx *gorm.DB, job *ModerationJob, fields map[string]interface{}) error {
+	fields["locked_until"] = nil
+	return tx.Model(&ModerationJob{}).
+		Where("id = ? AND revision = ? AND status = ?", job.ID, job.Revision, JobRunning).
+		Updates(fields).Error
+}
+
+// ListModerationJobs - задачи со статусом status, старые сверху
+func (r *repository) ListModerationJobs(status string, limit, offset int) ([]ModerationJob, error) {
+	jobs := []ModerationJob{}
+	err := r.db.Where("status = ?", status).Order("updated_at").Limit(limit).Offset(offset).Find(&jobs).Error
+	return jobs, err
+}
+
+// RequeueModerationJob возвращает в очередь задачу из dead
+func (r *repository) RequeueModerationJob(jobID uuid.UUID) error {
+	result := r.db.Model(&ModerationJob{}).Where("id = ? AND status = ?", jobID, JobDead).Updates(map[string]interface{}{
+		"status":     JobQueued,
+		"run_at":     time.Now(),
+		"attempts":   0,
+		"last_error": "",
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrJobNotFound
+	}
+	return nil
+}
+
+// AddVote ставит или меняет голос и возвращает рейтинг комментария до и
+// после. Счетчики комментария и карма автора меняются в той же транзакции.
+func (r *repository) AddVote(commentID uuid.UUID, userID uuid.UUID, isUpvote bool) (before, after int, err error) {
+	err = r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockComment(tx, commentID, userID)
+		if err != nil {
+			return err
+		}
+		authorID := locked.UserID
+		if authorID == userID {
+			return ErrSelfVote
+		}
+
 		// Удаляем предыдущий голос если был
-		if err := tx.Where("comment_id = ? AND user_id = ?", commentID, userID).Delete(&CommentVote{}).Error; err != nil {
+		previous, err := deleteVote(tx, commentID, userID)
+		if err != nil {
 			return err
 		}
 
 		// Добавляем новый голос
-		return tx.Create(&CommentVote{
+		if err := tx.Create(&CommentVote{
 			CommentID: commentID,
 			UserID:    userID,
 			IsUpvote:  isUpvote,
-		}).Error
+		}).Error; err != nil {
+			return err
+		}
+
+		up, down := voteDelta(previous, -1)
+		addUp, addDown := voteDelta(&isUpvote, 1)
+		before = locked.Score
+		after = before + up + addUp - down - addDown
+		return applyVoteDelta(tx, commentID, authorID, up+addUp, down+addDown)
 	})
+	return before, after, err
 }
 
 func (r *repository) RemoveVote(commentID uuid.UUID, userID uuid.UUID) error {
-	return r.db.Where("comment_id = ? AND user_id = ?", commentID, userID).Delete(&CommentVote{}).Error
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		locked, err := lockComment(tx, commentID, userID)
+		if err != nil {
+			return err
+		}
+		previous, err := deleteVote(tx, commentID, userID)
+		if err != nil || previous == nil {
+			return err
+		}
+		up, down := voteDelta(previous, -1)
+		return applyVoteDelta(tx, commentID, locked.UserID, up, down)
+	})
 }
 
-func (r *repository) GetVotes(commentID uuid.UUID) (upvotes, downvotes int, err error) {
-	var result struct {
-		Upvotes   int
-		Downvotes int
+// lockComment блокирует комментарий до конца транзакции, чтобы
+// параллельные голоса не теряли изменения счетчиков. Возвращает автора и
+// текущий рейтинг. Удаленный или невидимый userID комментарий (на
+// модерации, отклоненный, скрытый) - ErrCommentNotFound.
+func lockComment(tx *gorm.DB, commentID uuid.UUID, userID uuid.UUID) (*Comment, error) {
+	var comment Comment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "user_id", "score").
+		Where("comments.id = @id AND comments.deleted_at IS NULL AND "+visibleCondition("comments", false),
+			map[string]interface{}{"id": commentID, "user": userID}).
+		First(&comment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrCommentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// deleteVote удаляет голос и возвращает его, nil - голоса не было
+func deleteVote(tx *gorm.DB, commentID uuid.UUID, userID uuid.UUID) (*bool, error) {
+	var votes []CommentVote
+	err := tx.Clauses(clause.Returning{}).
+		Where("comment_id = ? AND user_id = ?", commentID, userID).
+		Delete(&votes).Error
+	if err != nil || len(votes) == 0 {
+		return nil, err
+	}
+	return &votes[0].IsUpvote, nil
+}
+
+func voteDelta(isUpvote *bool, sign int) (up, down int) {
+	switch {
+	case isUpvote == nil:
+		return 0, 0
+	case *isUpvote:
+		return sign, 0
+	default:
+		return 0, sign
 	}
+}
 
-	err = r.db.Model(&CommentVote{}).
-		Select("SUM(CASE WHEN is_upvote = true THEN 1 ELSE 0 END) as upvotes, "+
-			"SUM(CASE WHEN is_upvote = false THEN 1 ELSE 0 END) as downvotes").
-		Where("comment_id = ?", commentID).
-		Scan(&result).Error
+func applyVoteDelta(tx *gorm.DB, commentID, authorID uuid.UUID, up, down int) error {
+	if up == 0 && down == 0 {
+		return nil
+	}
+	if err := tx.Model(&Comment{}).Where("id = ?", commentID).UpdateColumns(map[string]interface{}{
+		"upvotes":   gorm.Expr("upvotes + ?", up),
+		"downvotes": gorm.Expr("downvotes + ?", down),
+		"score":     gorm.Expr("score + ?", up-down),
+	}).Error; err != nil {
+		return err
+	}
+	return tx.Table("users").Where("id = ?", authorID).
+		UpdateColumn("karma", gorm.Expr("karma + ?", up-down)).Error
+}
 
-	return result.Upvotes, result.Downvotes, err
+func (r *repository) GetVotes(commentID uuid.UUID) (upvotes, downvotes int, err error) {
+	var comment Comment
+	if err := r.db.Select("upvotes", "downvotes").First(&comment, "id = ?", commentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, 0, ErrCommentNotFound
+		}
+		return 0, 0, err
+	}
+	return comment.Upvotes, comment.Downvotes, nil
 }
 
 func (r *repository) GetUserVote(commentID uuid.UUID, userID uuid.UUID) (*bool, error) {
@@ -77,47 +335,600 @@ func (r *repository) GetUserVote(commentID uuid.UUID, userID uuid.UUID) (*bool,
 	return &vote.IsUpvote, nil
 }
 
-// Обновляем метод GetByAnimeID
-func (r *repository) GetByAnimeID(animeID string, userID uuid.UUID) ([]CommentWithUser, error) {
+// ListOptions - выборка страницы комментариев
+type ListOptions struct {
+	AnimeID       string
+	Season        *int       // Вместе с AnimeID: при Episode == nil - только
+	Episode       *int       // общее обсуждение аниме, без обсуждений серий
+	ParentID      *uuid.UUID // Только прямые ответы на комментарий
+	RootsOnly     bool       // Только комментарии верхнего уровня
+	Sort          string
+	After         *cursor
+	Limit         int
+	UserID        uuid.UUID
+	IncludeHidden bool
+
+	// Фильтры очереди модерации и списка комментариев автора
+	AuthorID         *uuid.UUID
+	ModerationStatus string
+	MinToxicity      *float64
+	ExcludeDeleted   bool
+	Reported         bool // Только с нерассмотренными жалобами
+}
+
+// List возвращает страницу комментариев одним запросом: голоса, голос
+// пользователя и число ответов считаются в том же SQL. Скрытые модераторами
+// комментарии видны только их авторам и, при IncludeHidden, модераторам.
+func (r *repository) List(opts ListOptions) ([]CommentWithUser, error) {
+	where := []string{visibleCondition("comments", opts.IncludeHidden)}
+	if opts.ParentID != nil {
+		where = append(where, "comments.parent_id = @parent")
+	}
+	if opts.AnimeID != "" {
+		where = append(where, "comments.anime_id = @anime")
+		if opts.Episode != nil {
+			where = append(where, "comments.season = @season AND comments.episode = @episode")
+		} else {
+			where = append(where, "comments.episode IS NULL")
+		}
+	}
+	if opts.RootsOnly {
+		where = append(where, "comments.parent_id IS NULL")
+	}
+	if opts.AuthorID != nil {
+		where = append(where, "comments.user_id = @author")
+	}
+	if opts.ModerationStatus != "" {
+		where = append(where, "comments.moderation_status = @status")
+	}
+	if opts.MinToxicity != nil {
+		where = append(where, "comments.toxicity_score >= @toxicity")
+	}
+	if opts.ExcludeDeleted {
+		where = append(where, "comments.deleted_at IS NULL")
+	}
+	if opts.Reported {
+		where = append(where, "comments.open_reports > 0")
+	}
+
+	order, compare := "DESC", "<"
+	if opts.Sort == SortOld {
+		order, compare = "ASC", ">"
+	}
+
+	query := "SELECT * FROM (" + commentQuery("comments", "comments", sortKeys[opts.Sort], opts.IncludeHidden) +
+		" WHERE " + strings.Join(where, " AND ") + ") page"
+	args := map[string]interface{}{
+		"anime":    opts.AnimeID,
+		"season":   opts.Season,
+		"episode":  opts.Episode,
+		"parent":   opts.ParentID,
+		"user":     opts.UserID,
+		"author":   opts.AuthorID,
+		"status":   opts.ModerationStatus,
+		"toxicity": opts.MinToxicity,
+		"limit":    opts.Limit,
+	}
+	if opts.After != nil {
+		query += " WHERE (page.sort_key, page.created_at, page.id) " + compare + " (@key, @created, @id)"
+		args["key"] = opts.After.Key
+		args["created"] = opts.After.CreatedAt
+		args["id"] = opts.After.ID
+	}
+	query += fmt.Sprintf(" ORDER BY page.sort_key %[1]s, page.created_at %[1]s, page.id %[1]s LIMIT @limit", order)
+
 	var comments []CommentWithUser
+	if err := r.db.Raw(query, args).Scan(&comments).Error; err != nil {
+		return nil, err
+	}
+	return comments, nil
+}
+
+func (r *repository) GetByID(commentID uuid.UUID) (*Comment, error) {
+	var comment Comment
+	if err := r.db.First(&comment, "id = ?", commentID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrCommentNotFound
+		}
+		return nil, err
+	}
+	return &comment, nil
+}
+
+// GetDescendants возвращает ответы на комментарии parentIDs вглубь до
+// maxDepth уровня (parentIDs - первый уровень): не больше perParent
+// первых ответов на каждый комментарий и не больше limit всего, верхние
+// уровни в первую очередь. Скрытые комментарии отсекаются вместе с
+// ответами на них.
+func (r *repository) GetDescendants(parentIDs []uuid.UUID, maxDepth, perParent, limit int, userID uuid.UUID, includeHidden bool) ([]CommentWithUser, error) {
+	if len(parentIDs) == 0 || maxDepth < 2 || perParent <= 0 || limit <= 0 {
+		return nil, nil
+	}
+
+	query := `WITH RECURSIVE thread AS (
+	SELECT replies.*, 2 AS depth FROM comments parents
+	CROSS JOIN LATERAL (` + firstReplies("parents", includeHidden) + `) replies
+	WHERE parents.id IN @parents
+	UNION ALL
+	SELECT replies.*, thread.depth + 1 FROM thread
+	CROSS JOIN LATERAL (` + firstReplies("thread", includeHidden) + `) replies
+	WHERE thread.depth < @depth
+)
+` + commentQuery("thread", "thread", sortKeys[SortOld], includeHidden) + `
+ORDER BY thread.depth, thread.created_at, thread.id
+LIMIT @limit`
+
+	var comments []CommentWithUser
+	err := r.db.Raw(query, map[string]interface{}{
+		"parents":    parentIDs,
+		"depth":      maxDepth,
+		"per_parent": perParent,
+		"limit":      limit,
+		"user":       userID,
+	}).Scan(&comments).Error
+	return comments, err
+}
+
+// firstReplies - первые @per_parent видимых ответов на комментарий parent
+// в порядке сортировки old, чтобы курсор продолжал их с того же места
+func firstReplies(parent string, includeHidden bool) string {
+	return `SELECT comments.* FROM comments
+		WHERE comments.parent_id = ` + parent + `.id AND ` + visibleCondition("comments", includeHidden) + `
+		ORDER BY comments.created_at, comments.id LIMIT @per_parent`
+}
+
+// commentQuery - SELECT комментариев таблицы table с автором и его кармой,
+// голосом пользователя @user, числом видимых ответов и ключом сортировки
+func commentQuery(table, from, sortKey string, includeHidden bool) string {
+	sortKey = strings.ReplaceAll(sortKey, "comments.", table+".")
+	return `SELECT ` + table + `.*, users.username, users.karma AS user_karma,
+	uv.is_upvote AS user_vote,
+	(SELECT COUNT(*) FROM comments replies
+		WHERE replies.parent_id = ` + table + `.id AND ` + visibleCondition("replies", includeHidden) + `) AS reply_count,
+	` + sortKey + ` AS sort_key
+FROM ` + from + `
+LEFT JOIN users ON users.id = ` + table + `.user_id
+LEFT JOIN comment_votes uv ON uv.comment_id = ` + table + `.id AND uv.user_id = @user`
+}
+
+// visibleCondition - комментарий виден пользователю @user. Удаленные
+// остаются в выдаче заглушкой, только если ниже в ветке есть живой ответ,
+// в том числе под другими удаленными.
+func visibleCondition(table string, includeHidden bool) string {
+	if includeHidden {
+		return "TRUE"
+	}
+	return "((" + table + ".moderation_status = '" + ModerationApproved + "' AND NOT " + table + ".is_hidden) OR " + table + ".user_id = @user)" +
+		" AND (" + table + ".deleted_at IS NULL OR " + liveDescendant(table) + ")"
+}
+
+// liveDescendant - у комментария есть неудаленный потомок. Спуск идет
+// только через удаленные ответы: живой ответ уже достаточен. Ответы
+// берутся под псевдонимом, иначе при table = "comments" внутренняя
+// таблица перекрыла бы внешнюю.
+func liveDescendant(table string) string {
+	return `EXISTS (WITH RECURSIVE kept AS (
+		SELECT child.id, child.deleted_at FROM comments child WHERE child.parent_id = ` + table + `.id
+		UNION ALL
+		SELECT c.id, c.deleted_at FROM comments c JOIN kept ON c.parent_id = kept.id
+		WHERE kept.deleted_at IS NOT NULL
+	) SELECT 1 FROM kept WHERE kept.deleted_at IS NULL)`
+}
+
+// CountByEpisode считает видимые всем комментарии к каждой серии аниме
+func (r *repository) CountByEpisode(animeID string) ([]EpisodeCount, error) {
+	counts := []EpisodeCount{}
+	err := r.db.Model(&Comment{}).
+		Select("season, episode, COUNT(*) AS comments").
+		Where("anime_id = ? AND episode IS NOT NULL AND moderation_status = ? AND NOT is_hidden AND deleted_at IS NULL", animeID, ModerationApproved).
+		Group("season, episode").
+		Order("season, episode").
+		Scan(&counts).Error
+	return counts, err
+}
+
+// WatchedEpisodes возвращает, сколько серий аниме пользователь отметил
+// просмотренными. nil - аниме нет в его списке (или оно только в
+// избранном) или оно досмотрено.
+func (r *repository) WatchedEpisodes(userID uuid.UUID, animeID string) (*int, error) {
+	var entry struct {
+		Status          string
+		EpisodesWatched int
+	}
+	result := r.db.Table("user_anime_entries").
+		Select("status, episodes_watched").
+		Where("user_id = ? AND anime_id = ?", userID, animeID).
+		Limit(1).
+		Scan(&entry)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return nil, result.Error
+	}
+	return watchedProgress(entry.Status, entry.EpisodesWatched), nil
+}
+
+// watchedProgress - сколько серий досмотрено по записи списка, nil - без
+// ограничений. Запись только в избранном (без статуса) ничего не говорит
+// о просмотре, а пересматривающий уже видел все серии.
+func watchedProgress(status string, episodes int) *int {
+	switch status {
+	case "", user.StatusCompleted, user.StatusRewatching:
+		return nil
+	}
+	return &episodes
+}
+
+// GetAuthorEmail возвращает email автора, пустой у аккаунтов без почты
+func (r *repository) GetAuthorEmail(userID uuid.UUID) (string, error) {
+	var email *string
+	err := r.db.Table("users").Select("email").Where("id = ?", userID).Scan(&email).Error
+	if err != nil || email == nil {
+		return "", err
+	}
+	return *email, nil
+}
+
+// FindUsersByUsername возвращает ID пользователей с такими именами,
+// несуществующие имена пропускаются
+func (r *repository) FindUsersByUsername(usernames []string) ([]uuid.UUID, error) {
+	var ids []uuid.UUID
+	if len(usernames) == 0 {
+		return ids, nil
+	}
+	err := r.db.Table("users").Where("username IN ?", usernames).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// IsUserVerified проверяет, подтвердил ли автор свой email.
+// Аккаунты с привязанным Shikimori считаются подтвержденными.
+func (r *repository) IsUserVerified(userID uuid.UUID) (bool, error) {
+	var count int64
+	err := r.db.Table("users").
+		Where("id = ? AND (email_verified_at IS NOT NULL OR EXISTS (SELECT 1 FROM shikimori_accounts WHERE shikimori_accounts.user_id = users.id))", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// Delete помечает комментарий автора удаленным, ответы на него остаются
+func (r *repository) Delete(commentID uuid.UUID, userID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return softDelete(tx, map[string]interface{}{
+			"deleted_at": time.Now(),
+			"deleted_by": userID,
+		}, "id = ? AND user_id = ?", commentID, userID)
+	})
+}
+
+// ForceDelete удаляет любой комментарий, используется модераторами.
+// Комментарий, уже удаленный автором, тоже можно удалить: решение
+// модератора попадет в журнал, а карма второй раз не изменится.
+func (r *repository) ForceDelete(commentID uuid.UUID, moderatorID uuid.UUID, reason string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var comment Comment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "deleted_at", "deleted_by_moderator").
+			Take(&comment, "id = ?", commentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) || err == nil && comment.DeletedByModerator {
+			return ErrCommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		fields := map[string]interface{}{
+			"deleted_by":           moderatorID,
+			"deleted_by_moderator": true,
+			"delete_reason":        reason,
+		}
+		if comment.DeletedAt == nil {
+			fields["deleted_at"] = time.Now()
+			err = softDelete(tx, fields, "id = ?", commentID)
+		} else {
+			// deleted_at не трогаем: срок хранения отсчитывается от удаления автором
+			err = tx.Model(&Comment{}).Where("id = ?", commentID).UpdateColumns(fields).Error
+		}
+		if err != nil {
+			return err
+		}
+		if err := resolveReports(tx, []uuid.UUID{commentID}, moderatorID); err != nil {
+			return err
+		}
+		entry := newAction(commentID, moderatorID, ActionDelete, reason)
+		return tx.Create(&entry).Error
+	})
+}
+
+// softDelete помечает комментарий удаленным и вычитает его рейтинг из
+// кармы автора. Голоса и история правок остаются до очистки по сроку.
+func softDelete(tx *gorm.DB, fields map[string]interface{}, query string, args ...interface{}) error {
+	var comment Comment
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "user_id", "score").
+		Where(query, args...).
+		Where("deleted_at IS NULL").
+		Take(&comment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrCommentNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Model(&Comment{}).Where("id = ?", comment.ID).UpdateColumns(fields).Error; err != nil {
+		return err
+	}
+	if comment.Score == 0 {
+		return nil
+	}
+	return tx.Table("users").Where("id = ?", comment.UserID).
+		UpdateColumn("karma", gorm.Expr("karma - ?", comment.Score)).Error
+}
+
+// PurgeDeleted стирает текст и историю правок комментариев, удаленных
+// раньше before, и возвращает их ID. Сама запись остается, чтобы не рвать
+// ветки ответов.
+func (r *repository) PurgeDeleted(before time.Time) ([]uuid.UUID, error) {
+	var purged []Comment
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		expired := tx.Model(&Comment{}).Select("id").
+			Where("deleted_at < ? AND purged_at IS NULL", before)
+		if err := tx.Where("comment_id IN (?)", expired).Delete(&CommentRevision{}).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&purged).Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+			Where("deleted_at < ? AND purged_at IS NULL", before).
+			UpdateColumns(map[string]interface{}{
+				"content":            "",
+				"toxicity_score":     nil,
+				"moderation_details": nil,
+				"purged_at":          time.Now(),
+			}).Error
+	})
+	ids := make([]uuid.UUID, len(purged))
+	for i, comment := range purged {
+		ids[i] = comment.ID
+	}
+	return ids, err
+}
+
+func (r *repository) SetHidden(commentID uuid.UUID, hidden bool, moderatorID uuid.UUID, reason string) error {
+	var hiddenBy *uuid.UUID
+	action := ActionUnhide
+	if hidden {
+		hiddenBy = &moderatorID
+		action = ActionHide
+	}
+
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&Comment{}).Where("id = ?", commentID).Updates(map[string]interface{}{
+			"is_hidden": hidden,
+			"hidden_by": hiddenBy,
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrCommentNotFound
+		}
+		if err := resolveReports(tx, []uuid.UUID{commentID}, moderatorID); err != nil {
+			return err
+		}
+		entry := newAction(commentID, moderatorID, action, reason)
+		return tx.Create(&entry).Error
+	})
+}
+
+// SetModeration выносит решение модератора по комментариям и пишет его
+// в журнал. Возвращает ID найденных комментариев.
+func (r *repository) SetModeration(commentIDs []uuid.UUID, status string, moderatorID uuid.UUID, reason string) ([]uuid.UUID, error) {
+	action := ActionApprove
+	if status == ModerationRejected {
+		action = ActionReject
+	}
+
+	fields := map[string]interface{}{
+		"moderation_status": status,
+		"is_approved":       status == ModerationApproved,
+		"moderation_reason": reason,
+		"moderated_by":      moderatorID,
+		"moderated_at":      time.Now(),
+	}
+	if status == ModerationApproved {
+		// Одобрение снимает автоматическое скрытие по жалобам
+		fields["is_hidden"] = gorm.Expr("is_hidden AND hidden_by IS NOT NULL")
+	}
 
-	// Базовый запрос для комментариев
-	baseQuery := r.db.Table("comments").
-		Select("comments.*, users.email as user_email").
-		Joins("left join users on comments.user_id = users.id").
-		Where("comments.anime_id = ?", animeID).
-		Order("comments.created_at desc")
+	var updated []Comment
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&updated).Clauses(clause.Returning{Columns: []clause.Column{{Name: "id"}}}).
+			Where("id IN ?", commentIDs).
+			Updates(fields).Error
+		if err != nil || len(updated) == 0 {
+			return err
+		}
 
-	// Получаем комментарии
-	if err := baseQuery.Scan(&comments).Error; err != nil {
+		actions := make([]ModerationAction, 0, len(updated))
+		ids := make([]uuid.UUID, 0, len(updated))
+		for _, comment := range updated {
+			actions = append(actions, newAction(comment.ID, moderatorID, action, reason))
+			ids = append(ids, comment.ID)
+		}
+		if err := resolveReports(tx, ids, moderatorID); err != nil {
+			return err
+		}
+		return tx.Create(&actions).Error
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	// Для каждого комментария получаем голоса
-	for i := range comments {
-		up, down, err := r.GetVotes(comments[i].ID)
+	ids := make([]uuid.UUID, 0, len(updated))
+	for _, comment := range updated {
+		ids = append(ids, comment.ID)
+	}
+	return ids, nil
+}
+
+// resolveReports закрывает жалобы на комментарии после решения модератора
+func resolveReports(tx *gorm.DB, commentIDs []uuid.UUID, moderatorID uuid.UUID) error {
+	now := time.Now()
+	err := tx.Model(&CommentReport{}).
+		Where("comment_id IN ? AND status = ?", commentIDs, ReportOpen).
+		Updates(map[string]interface{}{
+			"status":      ReportResolved,
+			"resolved_by": moderatorID,
+			"resolved_at": now,
+		}).Error
+	if err != nil {
+		return err
+	}
+	return tx.Model(&Comment{}).Where("id IN ?", commentIDs).UpdateColumns(map[string]interface{}{
+		"open_reports":        0,
+		"reports_resolved_at": now,
+	}).Error
+}
+
+// AddReport сохраняет жалобу. Жалоба на рассмотренный модератором
+// комментарий сразу считается закрытой. Когда открытых жалоб набирается
+// hideThreshold (0 - не скрывать), комментарий скрывается до решения
+// модератора; hidden_by при этом пустой.
+func (r *repository) AddReport(report *CommentReport, hideThreshold int) (bool, error) {
+	hidden := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var comment Comment
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Select("id", "user_id", "is_hidden", "open_reports", "reports_resolved_at").
+			First(&comment, "id = ? AND deleted_at IS NULL", report.CommentID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCommentNotFound
+		}
 		if err != nil {
-			return nil, err
+			return err
+		}
+		if comment.UserID == report.UserID {
+			return ErrSelfReport
 		}
 
-		comments[i].Upvotes = up
-		comments[i].Downvotes = down
+		report.Status = ReportOpen
+		if comment.ReportsResolvedAt != nil {
+			report.Status = ReportResolved
+		}
+		result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(report)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrAlreadyReported
+		}
+		if report.Status != ReportOpen {
+			return nil
+		}
 
-		if userID != uuid.Nil {
-			vote, err := r.GetUserVote(comments[i].ID, userID)
-			if err != nil {
-				return nil, err
-			}
-			comments[i].UserVote = vote
+		fields := map[string]interface{}{"open_reports": gorm.Expr("open_reports + 1")}
+		if hideThreshold > 0 && !comment.IsHidden && comment.OpenReports+1 >= hideThreshold {
+			fields["is_hidden"] = true
+			hidden = true
 		}
+		return tx.Model(&Comment{}).Where("id = ?", comment.ID).UpdateColumns(fields).Error
+	})
+	return hidden, err
+}
+
+func (r *repository) ListReports(commentID uuid.UUID) ([]CommentReport, error) {
+	reports := []CommentReport{}
+	err := r.db.Where("comment_id = ?", commentID).Order("created_at DESC").Find(&reports).Error
+	return reports, err
+}
+
+// ActionFilter - фильтр журнала модерации, пустые поля не ограничивают выборку
+type ActionFilter struct {
+	CommentID   *uuid.UUID
+	ModeratorID *uuid.UUID
+	Action      string
+	Limit       int
+	Offset      int
+}
+
+func (r *repository) ListActions(filter ActionFilter) ([]ModerationAction, error) {
+	query := r.db.Model(&ModerationAction{})
+	if filter.CommentID != nil {
+		query = query.Where("comment_id = ?", *filter.CommentID)
+	}
+	if filter.ModeratorID != nil {
+		query = query.Where("moderator_id = ?", *filter.ModeratorID)
+	}
+	if filter.Action != "" {
+		query = query.Where("action = ?", filter.Action)
 	}
 
-	return comments, nil
+	actions := []ModerationAction{}
+	err := query.Order("created_at DESC").Limit(filter.Limit).Offset(filter.Offset).Find(&actions).Error
+	return actions, err
 }
-func (r *repository) Delete(commentID uuid.UUID, userID uuid.UUID) error {
-	return r.db.Where("id = ? AND user_id = ?", commentID, userID).Delete(&Comment{}).Error
+
+// ContentUpdate - новый текст комментария и результат его модерации
+type ContentUpdate struct {
+	Content          string
+	ModerationStatus string
+	Verdict          *moderation.Verdict // nil - проверка недоступна
+	Queued           bool                // Проверит воркер, см. ModerationJob
+}
+
+// UpdateContent сохраняет прежний текст в comment_revisions и заменяет его
+// новым. Решение модератора сбрасывается - новый текст проверяется заново.
+func (r *repository) UpdateContent(commentID uuid.UUID, userID uuid.UUID, update ContentUpdate) (*Comment, error) {
+	var comment Comment
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			First(&comment, "id = ? AND user_id = ? AND deleted_at IS NULL", commentID, userID).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCommentNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Create(&CommentRevision{
+			ID:               uuid.New(),
+			CommentID:        comment.ID,
+			Content:          comment.Content,
+			ModerationStatus: comment.ModerationStatus,
+			ToxicityScore:    comment.ToxicityScore,
+		}).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		comment.Content = update.Content
+		comment.EditedAt = &now
+		if update.Queued {
+			markQueued(&comment)
+		} else {
+			applyVerdict(&comment, update.ModerationStatus, update.Verdict)
+		}
+		comment.ModerationReason = ""
+		comment.ModeratedBy = nil
+		comment.ModeratedAt = nil
+		comment.ReportsResolvedAt = nil // На новый текст снова можно пожаловаться
+
+		err = tx.Model(&comment).Select(
+			"content", "edited_at", "moderation_status", "is_approved", "toxicity_score",
+			"moderation_details", "moderation_backend", "moderation_reason", "moderated_by", "moderated_at",
+			"reports_resolved_at", "updated_at",
+		).Updates(&comment).Error
+		if err != nil || !update.Queued {
+			return err
+		}
+		return enqueueModeration(tx, comment.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &comment, nil
 }
 
-func (r *repository) Update(comment *Comment) error {
-	return r.db.Model(comment).Where("id = ? AND user_id = ?", comment.ID, comment.UserID).Updates(comment).Error
+func (r *repository) ListRevisions(commentID uuid.UUID) ([]CommentRevision, error) {
+	revisions := []CommentRevision{}
+	err := r.db.Where("comment_id = ?", commentID).Order("created_at DESC").Find(&revisions).Error
+	return revisions, err
 }
@@ -0,0 +1,129 @@
+package comment
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// dryRunDB - GORM без подключения к базе: запросы только собираются
+func dryRunDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCreatePendingCommentStaysUnapproved(t *testing.T) {
+	for _, status := range []string{ModerationPending, ModerationRejected} {
+		t.Run(status, func(t *testing.T) {
+			comment := &Comment{ID: uuid.New(), AnimeID: "1", Content: "text"}
+			applyVerdict(comment, status, nil)
+
+			stmt := dryRunDB(t).Create(comment).Statement
+			if comment.IsApproved {
+				t.Fatal("create replaced is_approved=false with the column default")
+			}
+			for i, v := range stmt.Vars {
+				if v == true {
+					t.Fatalf("INSERT binds true at position %d: %s", i, stmt.SQL.String())
+				}
+			}
+			if comment.visible() {
+				t.Fatal("pending comment is visible to everyone")
+			}
+		})
+	}
+}
+
+func TestVisibilityDependsOnModerationStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		comment Comment
+		visible bool
+	}{
+		{"approved", Comment{ModerationStatus: ModerationApproved, IsApproved: true}, true},
+		{"pending with stale flag", Comment{ModerationStatus: ModerationPending, IsApproved: true}, false},
+		{"rejected", Comment{ModerationStatus: ModerationRejected}, false},
+		{"hidden", Comment{ModerationStatus: ModerationApproved, IsApproved: true, IsHidden: true}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.comment.visible(); got != tt.visible {
+				t.Errorf("visible() = %v, want %v", got, tt.visible)
+			}
+		})
+	}
+
+	condition := visibleCondition("comments", false)
+	if strings.Contains(condition, "is_approved") || !strings.Contains(condition, "moderation_status = 'approved'") {
+		t.Errorf("visibleCondition must use moderation_status: %s", condition)
+	}
+}
+
+func TestLiveDescendantDoesNotShadowOuterTable(t *testing.T) {
+	for _, table := range []string{"comments", "replies", "thread"} {
+		condition := liveDescendant(table)
+		if !strings.Contains(condition, "FROM comments child WHERE child.parent_id = "+table+".id") {
+			t.Errorf("liveDescendant(%q) does not reference the outer row: %s", table, condition)
+		}
+	}
+}
+
+func TestCommentQueryDoesNotExposeEmail(t *testing.T) {
+	query := commentQuery("comments", "comments", sortKeys[SortNew], false)
+	if strings.Contains(query, "email") {
+		t.Errorf("comment query selects the author's email: %s", query)
+	}
+
+	name := "someone"
+	now := time.Now()
+	comment := CommentWithUser{Comment: Comment{ID: uuid.New(), DeletedAt: &now}, Username: &name}
+	data, err := json.Marshal(comment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "email") {
+		t.Errorf("comment JSON contains email: %s", data)
+	}
+	comment.redact()
+	if comment.Username != nil {
+		t.Error("redact kept the author's username")
+	}
+}
+
+func TestLockCommentRequiresVisibleComment(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{Logger: logger.Discard})
+	var sql string
+	var vars []interface{}
+	db.Callback().Query().After("gorm:query").Register("test:capture", func(tx *gorm.DB) {
+		sql, vars = tx.Statement.SQL.String(), tx.Statement.Vars
+	})
+
+	commentID, voterID := uuid.New(), uuid.New()
+	_, _ = lockComment(db, commentID, voterID)
+	for _, want := range []string{"FOR UPDATE", "comments.deleted_at IS NULL", "comments.moderation_status = 'approved'", "NOT comments.is_hidden"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("lockComment query has no %q:\n%s", want, sql)
+		}
+	}
+	found := false
+	for _, v := range vars {
+		found = found || v == voterID
+	}
+	if !found {
+		t.Errorf("lockComment query does not bind the voter: %v", vars)
+	}
+}
@@ -0,0 +1,136 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Очереди модерации: pending - ждут решения, rejected - отклоненные,
+// flagged - все с токсичностью выше порога проверки, в том числе
+// опубликованные, reported - с нерассмотренными жалобами читателей
+const (
+	QueuePending  = "pending"
+	QueueRejected = "rejected"
+	QueueFlagged  = "flagged"
+	QueueReported = "reported"
+)
+
+// MaxBulkSize - сколько комментариев можно обработать одним запросом
+const MaxBulkSize = 100
+
+var (
+	ErrInvalidQueue        = errors.New("unknown queue, use pending, rejected, flagged or reported")
+	ErrInvalidReviewAction = errors.New("unknown action, use approve or reject")
+	ErrReasonRequired      = errors.New("reason is required to reject a comment")
+	ErrInvalidBulkSize     = errors.New("comment_ids must contain from 1 to 100 ids")
+	ErrInvalidStatus       = errors.New("unknown status, use approved, pending or rejected")
+)
+
+// ReviewResult - итог решения модератора по списку комментариев
+type ReviewResult struct {
+	Updated  []uuid.UUID `json:"updated"`
+	NotFound []uuid.UUID `json:"not_found"`
+}
+
+// GetModerationQueue возвращает страницу очереди модерации, по умолчанию
+// старые комментарии сверху
+func (s *service) GetModerationQueue(ctx context.Context, queue string, page PageRequest) (*CommentPage, error) {
+	opts := ListOptions{IncludeHidden: true, ExcludeDeleted: true}
+	switch queue {
+	case QueuePending, "":
+		opts.ModerationStatus = ModerationPending
+	case QueueRejected:
+		opts.ModerationStatus = ModerationRejected
+	case QueueFlagged:
+		opts.MinToxicity = &s.moderation.reviewThreshold
+	case QueueReported:
+		opts.Reported = true
+	default:
+		return nil, ErrInvalidQueue
+	}
+	return s.commentPage(opts, page, SortOld)
+}
+
+// GetUserComments возвращает комментарии автора, в том числе ждущие
+// проверки и отклоненные, с их статусом модерации
+func (s *service) GetUserComments(ctx context.Context, userID uuid.UUID, status string, page PageRequest) (*CommentPage, error) {
+	switch status {
+	case "", ModerationApproved, ModerationPending, ModerationRejected:
+	default:
+		return nil, ErrInvalidStatus
+	}
+	return s.commentPage(ListOptions{
+		AuthorID:         &userID,
+		ModerationStatus: status,
+		UserID:           userID,
+	}, page, SortNew)
+}
+
+// ReviewComments одобряет или отклоняет комментарии. Для отклонения
+// нужна причина - ее увидит автор.
+func (s *service) ReviewComments(ctx context.Context, commentIDs []uuid.UUID, moderatorID uuid.UUID, action, reason string) (*ReviewResult, error) {
+	if len(commentIDs) == 0 || len(commentIDs) > MaxBulkSize {
+		return nil, ErrInvalidBulkSize
+	}
+
+	reason = strings.TrimSpace(reason)
+	var status string
+	switch action {
+	case ActionApprove:
+		status = ModerationApproved
+	case ActionReject:
+		if reason == "" {
+			return nil, ErrReasonRequired
+		}
+		status = ModerationRejected
+	default:
+		return nil, ErrInvalidReviewAction
+	}
+
+	updated, err := s.repo.SetModeration(commentIDs, status, moderatorID, reason)
+	if err != nil {
+		return nil, err
+	}
+	if status == ModerationRejected {
+		// Ответы и упоминания отклоненного комментария больше не видны
+		s.forget(ctx, updated...)
+	}
+	s.notifyReviewed(ctx, updated)
+
+	found := make(map[uuid.UUID]bool, len(updated))
+	for _, id := range updated {
+		found[id] = true
+	}
+	result := &ReviewResult{Updated: updated, NotFound: []uuid.UUID{}}
+	for _, id := range commentIDs {
+		if !found[id] {
+			result.NotFound = append(result.NotFound, id)
+			found[id] = true // Повторы в запросе не дублируем
+		}
+	}
+	return result, nil
+}
+
+// notifyReviewed сообщает авторам о решении модератора, а после одобрения
+// рассылает уведомления об ответах и упоминаниях
+func (s *service) notifyReviewed(ctx context.Context, commentIDs []uuid.UUID) {
+	for _, id := range commentIDs {
+		comment, err := s.repo.GetByID(id)
+		if err != nil {
+			log.Printf("Failed to load reviewed comment %s: %v", id, err)
+			continue
+		}
+		if err := s.notifier.ModerationDecided(ctx, comment); err != nil {
+			log.Printf("Failed to notify author of comment %s: %v", id, err)
+		}
+		s.announce(ctx, comment)
+	}
+}
+
+func (s *service) ListModerationActions(ctx context.Context, filter ActionFilter) ([]ModerationAction, error) {
+	return s.repo.ListActions(filter)
+}
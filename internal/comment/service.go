@@ -1,157 +1,625 @@
 package comment
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
-	"net/http"
+	"log"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/markup"
+	"github.com/Zipklas/anime-site-backend/internal/moderation"
 	"github.com/google/uuid"
 )
 
-type CommentModerationResult struct {
-	IsApproved    bool               `json:"is_approved"`
-	ToxicityScore float64            `json:"toxicity_score"`
-	Details       map[string]float64 `json:"details"` // Изменяем тип для удобства работы
-}
+var (
+	ErrEmailNotVerified = errors.New("подтвердите email, чтобы оставлять комментарии")
+	ErrInvalidParent    = errors.New("parent comment not found for this anime")
+	ErrEmptyContent     = errors.New("comment content cannot be empty")
+	ErrContentTooLong   = errors.New("comment is too long")
+	ErrInvalidEpisode   = errors.New("episode and season must be positive, season requires episode")
+)
+
+// MaxContentLength - максимальная длина видимого текста комментария в
+// символах, MaxContentBytes - исходного текста вместе с разметкой
+const (
+	MaxContentLength = 1000
+	MaxContentBytes  = 10000
+)
+
+// Глубина дерева комментариев по умолчанию и максимальная
+const (
+	DefaultTreeDepth = 3
+	MaxTreeDepth     = 10
+)
+
+// Сколько ответов дерево загружает на один комментарий и всего на
+// страницу. Остальные подгружаются по RepliesCursor.
+const (
+	TreeRepliesPerNode = 10
+	MaxTreeReplies     = 500
+)
 
 type Service interface {
-	CreateComment(ctx context.Context, animeID, content string, userID uuid.UUID, parentID *uuid.UUID) (*Comment, error)
-	GetComments(ctx context.Context, animeID string, userID uuid.UUID) ([]CommentWithUser, error)
-	DeleteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID) error
-	UpdateComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, content string) error
+	CreateComment(ctx context.Context, thread Thread, content string, userID uuid.UUID, parentID *uuid.UUID) (*Comment, error)
+	GetComments(ctx context.Context, thread Thread, userID uuid.UUID, includeHidden, reveal bool, page PageRequest) (*CommentPage, error)
+	GetCommentTree(ctx context.Context, thread Thread, userID uuid.UUID, includeHidden, reveal bool, depth int, page PageRequest) (*CommentTreePage, error)
+	GetEpisodeCounts(ctx context.Context, animeID string, userID uuid.UUID) ([]EpisodeCount, error)
+	GetReplies(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, includeHidden, reveal bool, depth int, page PageRequest) (*CommentTreePage, error)
+	DeleteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, asModerator bool, reason string) error
+	HideComment(ctx context.Context, commentID uuid.UUID, moderatorID uuid.UUID, hidden bool, reason string) error
+	UpdateComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, content string) (*Comment, error)
+	GetCommentHistory(ctx context.Context, commentID uuid.UUID) (*CommentHistory, error)
+	PurgeDeletedComments(ctx context.Context) (int64, error)
+	ReportComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, category, text string) (*CommentReport, error)
+	GetReports(ctx context.Context, commentID uuid.UUID) ([]CommentReport, error)
 	VoteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, isUpvote bool) error
 	RemoveVote(ctx context.Context, commentID uuid.UUID, userID uuid.UUID) error
-	moderateComment(content string) (*CommentModerationResult, error)
+	GetModerationQueue(ctx context.Context, queue string, page PageRequest) (*CommentPage, error)
+	GetUserComments(ctx context.Context, userID uuid.UUID, status string, page PageRequest) (*CommentPage, error)
+	ReviewComments(ctx context.Context, commentIDs []uuid.UUID, moderatorID uuid.UUID, action, reason string) (*ReviewResult, error)
+	ListModerationActions(ctx context.Context, filter ActionFilter) ([]ModerationAction, error)
+	GetModerationHealth(ctx context.Context) *ModerationHealth
+	RunModerationWorkers(ctx context.Context)
+	ListModerationJobs(ctx context.Context, status string, limit, offset int) ([]ModerationJob, error)
+	RetryModerationJob(ctx context.Context, jobID uuid.UUID) error
 }
 
+// defaultRetention - сколько хранится текст удаленного комментария
+const defaultRetention = 30 * 24 * time.Hour
+
 type service struct {
-	repo          Repository
-	moderationURL string
-	httpClient    *http.Client
+	repo       Repository
+	moderator  moderation.Moderator
+	moderation moderationConfig
+	queue      queueConfig
+	notifier   Notifier
+	markup     *markup.Parser
+	retention  time.Duration
+
+	reportHideThreshold int
 }
 
-func NewService(repo Repository) Service {
+// NewService создает сервис комментариев. COMMENT_RETENTION задает срок
+// хранения текста удаленных комментариев (0 - хранить всегда),
+// COMMENT_REPORT_HIDE_THRESHOLD - после скольких жалоб скрывать комментарий.
+// notifier сообщает авторам о решениях асинхронной модерации, может быть nil.
+func NewService(repo Repository, moderator moderation.Moderator, notifier Notifier) Service {
+	retention := defaultRetention
+	if d, err := time.ParseDuration(os.Getenv("COMMENT_RETENTION")); err == nil && d >= 0 {
+		retention = d
+	}
+	hideThreshold := defaultReportHideThreshold
+	if n, err := strconv.Atoi(os.Getenv("COMMENT_REPORT_HIDE_THRESHOLD")); err == nil && n >= 0 {
+		hideThreshold = n
+	}
+	if notifier == nil {
+		notifier = noopNotifier{}
+	}
 	return &service{
-		repo:          repo,
-		moderationURL: os.Getenv("MODERATION_SERVICE_URL"),
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-		},
+		repo:                repo,
+		moderator:           moderator,
+		moderation:          moderationConfigFromEnv(),
+		queue:               queueConfigFromEnv(),
+		notifier:            notifier,
+		markup:              markup.NewParserFromEnv(),
+		retention:           retention,
+		reportHideThreshold: hideThreshold,
 	}
 }
 
-func (s *service) moderateComment(content string) (*CommentModerationResult, error) {
-	if s.moderationURL == "" {
-		return &CommentModerationResult{IsApproved: true}, nil
+// CreateComment добавляет комментарий в обсуждение thread. Ответ попадает
+// в обсуждение родителя.
+func (s *service) CreateComment(ctx context.Context, thread Thread, content string, userID uuid.UUID, parentID *uuid.UUID) (*Comment, error) {
+	if err := s.validateContent(content); err != nil {
+		return nil, err
 	}
 
-	requestBody, err := json.Marshal(map[string]string{"text": content})
+	verified, err := s.repo.IsUserVerified(userID)
 	if err != nil {
 		return nil, err
 	}
+	if !verified {
+		return nil, ErrEmailNotVerified
+	}
 
-	resp, err := s.httpClient.Post(
-		s.moderationURL+"/moderate",
-		"application/json",
-		bytes.NewBuffer(requestBody),
-	)
+	// Ответ должен относиться к существующему видимому комментарию того же аниме
+	if parentID != nil {
+		parent, err := s.repo.GetByID(*parentID)
+		if err != nil {
+			if errors.Is(err, ErrCommentNotFound) {
+				return nil, ErrInvalidParent
+			}
+			return nil, err
+		}
+		if parent.AnimeID != thread.AnimeID || parent.DeletedAt != nil || (!parent.visible() && parent.UserID != userID) {
+			return nil, ErrInvalidParent
+		}
+		thread.Season, thread.Episode = parent.Season, parent.Episode
+	}
+
+	comment := &Comment{
+		ID:       uuid.New(),
+		AnimeID:  thread.AnimeID,
+		Season:   thread.Season,
+		Episode:  thread.Episode,
+		UserID:   userID,
+		Content:  content,
+		ParentID: parentID,
+	}
+
+	// В асинхронном режиме комментарий проверит воркер
+	if s.queue.async {
+		markQueued(comment)
+		if err := s.repo.CreateQueued(comment); err != nil {
+			return nil, err
+		}
+		s.render(comment)
+		return comment, nil
+	}
+
+	// Модерация комментария
+	status, verdict, err := s.moderationStatus(ctx, content)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
+	if status == ModerationRejected {
+		return nil, &RejectedError{Verdict: verdict}
+	}
+	applyVerdict(comment, status, verdict)
 
-	var result CommentModerationResult
-	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+	if err := s.repo.Create(comment); err != nil {
 		return nil, err
 	}
 
-	return &result, nil
+	s.render(comment)
+	s.announce(ctx, comment)
+	return comment, nil
 }
 
-func (s *service) CreateComment(ctx context.Context, animeID, content string, userID uuid.UUID, parentID *uuid.UUID) (*Comment, error) {
-	if content == "" {
-		return nil, errors.New("comment content cannot be empty")
+// Добавляем новые методы
+func (s *service) VoteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, isUpvote bool) error {
+	before, after, err := s.repo.AddVote(commentID, userID, isUpvote)
+	if err != nil {
+		return err
 	}
 
-	if len(content) > 1000 {
-		return nil, errors.New("comment is too long")
+	// Рейтинг мог перейти отметку, о которой стоит сказать автору. Смена
+	// минуса на плюс двигает его сразу на 2, поэтому сравниваем до и после.
+	milestones := crossedMilestones(before, after)
+	if len(milestones) == 0 {
+		return nil
 	}
+	comment, err := s.repo.GetByID(commentID)
+	if err != nil {
+		log.Printf("Failed to load comment %s for vote milestone: %v", commentID, err)
+		return nil
+	}
+	for _, milestone := range milestones {
+		if err := s.notifier.VoteMilestone(ctx, comment, milestone); err != nil {
+			log.Printf("Failed to notify about vote milestone of comment %s: %v", commentID, err)
+		}
+	}
+	return nil
+}
 
-	// Модерация комментария
-	moderation, err := s.moderateComment(content)
+// crossedMilestones - отметки VoteMilestones, достигнутые при росте
+// рейтинга с before до after
+func crossedMilestones(before, after int) []int {
+	var crossed []int
+	for _, milestone := range VoteMilestones {
+		if before < milestone && milestone <= after {
+			crossed = append(crossed, milestone)
+		}
+	}
+	return crossed
+}
+
+func (s *service) RemoveVote(ctx context.Context, commentID uuid.UUID, userID uuid.UUID) error {
+	return s.repo.RemoveVote(commentID, userID)
+}
+
+// GetComments возвращает страницу всех комментариев обсуждения. Обсуждение
+// серии, до которой пользователь еще не досмотрел, без reveal не отдается.
+func (s *service) GetComments(ctx context.Context, thread Thread, userID uuid.UUID, includeHidden, reveal bool, page PageRequest) (*CommentPage, error) {
+	if !reveal && !includeHidden {
+		behind, err := s.behind(userID, thread)
+		if err != nil {
+			return nil, err
+		}
+		if behind {
+			return &CommentPage{Comments: []CommentWithUser{}, SpoilerHidden: true}, nil
+		}
+	}
+	return s.commentPage(ListOptions{
+		AnimeID:       thread.AnimeID,
+		Season:        thread.Season,
+		Episode:       thread.Episode,
+		UserID:        userID,
+		IncludeHidden: includeHidden,
+	}, page, SortNew)
+}
+
+// behind - серия обсуждения дальше прогресса пользователя в его списке.
+// Сезоны не учитываются: в списке Shikimori у каждого сезона свой ID.
+func (s *service) behind(userID uuid.UUID, thread Thread) (bool, error) {
+	if thread.Episode == nil || userID == uuid.Nil {
+		return false, nil
+	}
+	watched, err := s.repo.WatchedEpisodes(userID, thread.AnimeID)
+	if err != nil || watched == nil {
+		return false, err
+	}
+	return *thread.Episode > *watched, nil
+}
+
+// GetEpisodeCounts возвращает число комментариев к каждой серии и
+// отмечает серии, которые пользователь еще не посмотрел
+func (s *service) GetEpisodeCounts(ctx context.Context, animeID string, userID uuid.UUID) ([]EpisodeCount, error) {
+	counts, err := s.repo.CountByEpisode(animeID)
 	if err != nil {
-		return nil, errors.New("moderation service error")
+		return nil, err
+	}
+	if userID == uuid.Nil || len(counts) == 0 {
+		return counts, nil
+	}
+	watched, err := s.repo.WatchedEpisodes(userID, animeID)
+	if err != nil || watched == nil {
+		return counts, err
+	}
+	for i := range counts {
+		counts[i].Behind = counts[i].Episode > *watched
 	}
+	return counts, nil
+}
 
-	if !moderation.IsApproved {
-		// Формируем детальное сообщение об ошибке
-		var toxicLabels []string
-		for label, score := range moderation.Details {
-			if score > 0.5 && label != "non-toxic" { // Показываем только токсичные категории с высоким скором
-				toxicLabels = append(toxicLabels, fmt.Sprintf("%s (%.0f%%)", label, score*100))
-			}
+// commentPage - страница плоского списка по фильтру opts
+func (s *service) commentPage(opts ListOptions, page PageRequest, defaultSort string) (*CommentPage, error) {
+	comments, next, err := s.listPage(opts, page, defaultSort)
+	if err != nil {
+		return nil, err
+	}
+	if comments == nil {
+		comments = []CommentWithUser{}
+	}
+	return &CommentPage{Comments: comments, NextCursor: next}, nil
+}
+
+// GetCommentTree возвращает страницу корневых комментариев обсуждения
+// с ответами до depth уровней
+func (s *service) GetCommentTree(ctx context.Context, thread Thread, userID uuid.UUID, includeHidden, reveal bool, depth int, page PageRequest) (*CommentTreePage, error) {
+	if !reveal && !includeHidden {
+		behind, err := s.behind(userID, thread)
+		if err != nil {
+			return nil, err
 		}
+		if behind {
+			return &CommentTreePage{Comments: []*CommentNode{}, SpoilerHidden: true}, nil
+		}
+	}
+	roots, next, err := s.listPage(ListOptions{
+		AnimeID:       thread.AnimeID,
+		Season:        thread.Season,
+		Episode:       thread.Episode,
+		RootsOnly:     true,
+		UserID:        userID,
+		IncludeHidden: includeHidden,
+	}, page, SortNew)
+	if err != nil {
+		return nil, err
+	}
+	return s.treePage(roots, next, userID, includeHidden, depth)
+}
 
-		errorMsg := fmt.Sprintf(
-			"Ваш комментарий был отклонен системой модерации. "+
-				"Общий уровень токсичности: %.0f%%. "+
-				"Проблемные категории: %s. "+
-				"Пожалуйста, переформулируйте ваш комментарий.",
-			moderation.ToxicityScore*100,
-			strings.Join(toxicLabels, ", "),
-		)
-		return nil, errors.New(errorMsg)
+// GetReplies возвращает страницу ответов на комментарий (по умолчанию
+// старые сверху) с вложенными ответами до depth уровней. Ответы лежат в
+// обсуждении корневого комментария, и спойлеры скрываются так же, как в нем.
+func (s *service) GetReplies(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, includeHidden, reveal bool, depth int, page PageRequest) (*CommentTreePage, error) {
+	parent, err := s.repo.GetByID(commentID)
+	if err != nil {
+		return nil, err
+	}
+	if !parent.visible() && !includeHidden && parent.UserID != userID {
+		return nil, ErrCommentNotFound
+	}
+	if !reveal && !includeHidden {
+		behind, err := s.behind(userID, parent.thread())
+		if err != nil {
+			return nil, err
+		}
+		if behind {
+			return &CommentTreePage{Comments: []*CommentNode{}, SpoilerHidden: true}, nil
+		}
 	}
 
-	comment := &Comment{
-		ID:         uuid.New(),
-		AnimeID:    animeID,
-		UserID:     userID,
-		Content:    content,
-		ParentID:   parentID,
-		IsApproved: moderation.IsApproved,
+	replies, next, err := s.listPage(ListOptions{
+		ParentID:      &commentID,
+		UserID:        userID,
+		IncludeHidden: includeHidden,
+	}, page, SortOld)
+	if err != nil {
+		return nil, err
 	}
+	return s.treePage(replies, next, userID, includeHidden, depth)
+}
 
-	if err := s.repo.Create(comment); err != nil {
+// listPage загружает страницу и курсор следующей
+func (s *service) listPage(opts ListOptions, page PageRequest, defaultSort string) ([]CommentWithUser, string, error) {
+	page, err := page.normalize(defaultSort)
+	if err != nil {
+		return nil, "", err
+	}
+	after, err := decodeCursor(page.Sort, page.Cursor)
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts.Sort = page.Sort
+	opts.After = after
+	opts.Limit = page.Limit + 1 // Лишняя запись показывает, что есть следующая страница
+	comments, err := s.repo.List(opts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if len(comments) > page.Limit {
+		comments = comments[:page.Limit]
+		next = encodeCursor(page.Sort, &comments[len(comments)-1])
+	}
+	// Модераторам нужен исходный текст удаленных комментариев
+	for i := range comments {
+		s.render(&comments[i].Comment)
+		if !opts.IncludeHidden {
+			comments[i].redact()
+		}
+	}
+	return comments, next, nil
+}
+
+func (s *service) treePage(roots []CommentWithUser, next string, userID uuid.UUID, includeHidden bool, depth int) (*CommentTreePage, error) {
+	depth = clampDepth(depth)
+	ids := make([]uuid.UUID, 0, len(roots))
+	for _, root := range roots {
+		ids = append(ids, root.ID)
+	}
+	descendants, err := s.repo.GetDescendants(ids, depth, TreeRepliesPerNode, MaxTreeReplies, userID, includeHidden)
+	if err != nil {
 		return nil, err
 	}
+	for i := range descendants {
+		s.render(&descendants[i].Comment)
+		if !includeHidden {
+			descendants[i].redact()
+		}
+	}
+	return &CommentTreePage{
+		Comments:   buildTree(roots, descendants),
+		NextCursor: next,
+	}, nil
+}
 
-	return comment, nil
+func clampDepth(depth int) int {
+	if depth <= 0 {
+		return DefaultTreeDepth
+	}
+	return min(depth, MaxTreeDepth)
 }
 
-// Добавляем новые методы
-func (s *service) VoteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, isUpvote bool) error {
+// buildTree раскладывает ответы (упорядоченные по уровню и времени) по
+// корням страницы. Ответы идут от старых к новым. Если загружены не все
+// ответы комментария, RepliesCursor указывает на последний загруженный.
+func buildTree(roots []CommentWithUser, descendants []CommentWithUser) []*CommentNode {
+	nodes := make(map[uuid.UUID]*CommentNode, len(roots)+len(descendants))
+	result := make([]*CommentNode, 0, len(roots))
+	for _, root := range roots {
+		root.Depth = 1
+		node := newNode(root)
+		nodes[root.ID] = node
+		result = append(result, node)
+	}
+	for _, comment := range descendants {
+		node := newNode(comment)
+		nodes[comment.ID] = node
+		if parent, ok := nodes[*comment.ParentID]; ok {
+			parent.Replies = append(parent.Replies, node)
+		}
+	}
+	for _, node := range nodes {
+		node.HasMoreReplies = len(node.Replies) < node.ReplyCount
+		if node.HasMoreReplies && len(node.Replies) > 0 {
+			node.RepliesCursor = encodeCursor(SortOld, &node.Replies[len(node.Replies)-1].CommentWithUser)
+		}
+	}
+	return result
+}
 
-	return s.repo.AddVote(commentID, userID, isUpvote)
+func newNode(comment CommentWithUser) *CommentNode {
+	return &CommentNode{
+		CommentWithUser: comment,
+		Replies:         []*CommentNode{},
+	}
 }
 
-func (s *service) RemoveVote(ctx context.Context, commentID uuid.UUID, userID uuid.UUID) error {
-	return s.repo.RemoveVote(commentID, userID)
+// DeleteComment удаляет комментарий автора, модератор может удалить любой
+// Удаление модератором попадает в журнал модерации.
+func (s *service) DeleteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, asModerator bool, reason string) error {
+	var err error
+	if asModerator {
+		err = s.repo.ForceDelete(commentID, userID, strings.TrimSpace(reason))
+	} else {
+		err = s.repo.Delete(commentID, userID)
+	}
+	if err != nil {
+		return err
+	}
+	s.forget(ctx, commentID)
+	return nil
 }
 
-// Обновляем метод GetComments
-func (s *service) GetComments(ctx context.Context, animeID string, userID uuid.UUID) ([]CommentWithUser, error) {
-	return s.repo.GetByAnimeID(animeID, userID)
+func (s *service) HideComment(ctx context.Context, commentID uuid.UUID, moderatorID uuid.UUID, hidden bool, reason string) error {
+	if err := s.repo.SetHidden(commentID, hidden, moderatorID, strings.TrimSpace(reason)); err != nil {
+		return err
+	}
+	if hidden {
+		s.forget(ctx, commentID)
+	}
+	return nil
+}
+
+// forget убирает уведомления о комментариях, которые больше не видны.
+// Ошибки только логируются.
+func (s *service) forget(ctx context.Context, commentIDs ...uuid.UUID) {
+	if len(commentIDs) == 0 {
+		return
+	}
+	if err := s.notifier.Removed(ctx, commentIDs); err != nil {
+		log.Printf("Failed to remove notifications about comments %v: %v", commentIDs, err)
+	}
 }
 
-func (s *service) DeleteComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID) error {
-	return s.repo.Delete(commentID, userID)
+// validateContent считает длину по видимому тексту, а не по байтам и
+// разметке. Исходный текст с разметкой ограничен MaxContentBytes.
+func (s *service) validateContent(content string) error {
+	if len(content) > MaxContentBytes {
+		return ErrContentTooLong
+	}
+	doc := s.markup.Parse(content)
+	if doc.Empty() {
+		return ErrEmptyContent
+	}
+	if doc.Length > MaxContentLength {
+		return ErrContentTooLong
+	}
+	return nil
 }
 
-func (s *service) UpdateComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, content string) error {
-	if content == "" {
-		return errors.New("comment content cannot be empty")
+// MaxMentions - скольким пользователям из одного комментария придет
+// уведомление об упоминании
+const MaxMentions = 10
+
+// announce уведомляет автора родительского комментария об ответе и
+// упомянутых пользователей. Комментарий, который видят не все, пропускается:
+// уведомления придут, когда его одобрят. Ошибки только логируются.
+func (s *service) announce(ctx context.Context, comment *Comment) {
+	if !comment.visible() || comment.DeletedAt != nil {
+		return
 	}
 
-	return s.repo.Update(&Comment{
-		ID:      commentID,
-		UserID:  userID,
-		Content: content,
+	var parentAuthor uuid.UUID
+	if comment.ParentID != nil {
+		parent, err := s.repo.GetByID(*comment.ParentID)
+		if err != nil {
+			log.Printf("Failed to load parent of comment %s: %v", comment.ID, err)
+		} else if parent.DeletedAt == nil && parent.UserID != comment.UserID {
+			parentAuthor = parent.UserID
+			if err := s.notifier.Replied(ctx, comment, parent); err != nil {
+				log.Printf("Failed to notify about reply %s: %v", comment.ID, err)
+			}
+		}
+	}
+
+	usernames := s.markup.Parse(comment.Content).Users
+	if len(usernames) > MaxMentions {
+		usernames = usernames[:MaxMentions]
+	}
+	ids, err := s.repo.FindUsersByUsername(usernames)
+	if err != nil {
+		log.Printf("Failed to resolve mentions in comment %s: %v", comment.ID, err)
+		return
+	}
+	// Автору родителя хватит уведомления об ответе
+	mentioned := slices.DeleteFunc(ids, func(id uuid.UUID) bool {
+		return id == comment.UserID || id == parentAuthor
 	})
+	if len(mentioned) == 0 {
+		return
+	}
+	if err := s.notifier.Mentioned(ctx, comment, mentioned); err != nil {
+		log.Printf("Failed to notify about mentions in comment %s: %v", comment.ID, err)
+	}
+}
+
+// render разбирает разметку текста для выдачи
+func (s *service) render(comment *Comment) {
+	comment.Rendered = s.markup.Parse(comment.Content)
+}
+
+// UpdateComment меняет текст комментария автора. Новый текст проходит ту же
+// проверку и модерацию, что и при создании, прежний сохраняется в истории.
+func (s *service) UpdateComment(ctx context.Context, commentID uuid.UUID, userID uuid.UUID, content string) (*Comment, error) {
+	if err := s.validateContent(content); err != nil {
+		return nil, err
+	}
+
+	comment, err := s.repo.GetByID(commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment.UserID != userID || comment.DeletedAt != nil {
+		return nil, ErrCommentNotFound
+	}
+	if comment.Content == content {
+		s.render(comment)
+		return comment, nil
+	}
+	rejectedByModerator := comment.ModerationStatus == ModerationRejected && comment.ModeratedBy != nil
+
+	update := ContentUpdate{Content: content, ModerationStatus: ModerationPending, Queued: true}
+	// Отклоненный модератором комментарий проверяем сразу: его результат
+	// все равно смотрит модератор, а воркер не должен его одобрить
+	if !s.queue.async || rejectedByModerator {
+		status, verdict, err := s.moderationStatus(ctx, content)
+		if err != nil {
+			return nil, err
+		}
+		if status == ModerationRejected {
+			return nil, &RejectedError{Verdict: verdict}
+		}
+		// Отклоненный модератором комментарий после правки снова ждет модератора
+		if rejectedByModerator {
+			status = ModerationPending
+		}
+		update = ContentUpdate{Content: content, ModerationStatus: status, Verdict: verdict}
+	}
+
+	updated, err := s.repo.UpdateContent(commentID, userID, update)
+	if err != nil {
+		return nil, err
+	}
+	s.render(updated)
+	// Уже отправленные уведомления не повторяются, новые упоминания дойдут
+	s.announce(ctx, updated)
+	return updated, nil
+}
+
+// PurgeDeletedComments стирает текст комментариев, удаленных дольше
+// COMMENT_RETENTION назад
+func (s *service) PurgeDeletedComments(ctx context.Context) (int64, error) {
+	if s.retention == 0 {
+		return 0, nil
+	}
+	purged, err := s.repo.PurgeDeleted(time.Now().Add(-s.retention))
+	if err != nil {
+		return 0, err
+	}
+	s.forget(ctx, purged...)
+	return int64(len(purged)), nil
+}
+
+// GetCommentHistory возвращает комментарий и его прежние версии
+func (s *service) GetCommentHistory(ctx context.Context, commentID uuid.UUID) (*CommentHistory, error) {
+	comment, err := s.repo.GetByID(commentID)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := s.repo.ListRevisions(commentID)
+	if err != nil {
+		return nil, err
+	}
+	s.render(comment)
+	return &CommentHistory{Comment: comment, Revisions: revisions}, nil
 }
@@ -0,0 +1,81 @@
+package comment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/user"
+	"github.com/google/uuid"
+)
+
+func TestWatchedProgress(t *testing.T) {
+	tests := []struct {
+		status string
+		want   *int
+	}{
+		{"", nil}, // только в избранном
+		{user.StatusCompleted, nil},
+		{user.StatusRewatching, nil},
+		{user.StatusWatching, intPtr(3)},
+		{user.StatusPlanned, intPtr(3)},
+		{user.StatusDropped, intPtr(3)},
+	}
+	for _, tt := range tests {
+		got := watchedProgress(tt.status, 3)
+		if (got == nil) != (tt.want == nil) || got != nil && *got != *tt.want {
+			t.Errorf("watchedProgress(%q) = %v, want %v", tt.status, got, tt.want)
+		}
+	}
+}
+
+func intPtr(v int) *int { return &v }
+
+// spoilerRepo - прогресс пользователя в списке и пустые страницы ответов
+type spoilerRepo struct {
+	*fakeRepo
+	watched *int
+}
+
+func (r *spoilerRepo) WatchedEpisodes(userID uuid.UUID, animeID string) (*int, error) {
+	return r.watched, nil
+}
+
+func (r *spoilerRepo) List(opts ListOptions) ([]CommentWithUser, error) {
+	return []CommentWithUser{}, nil
+}
+
+func (r *spoilerRepo) GetDescendants(parentIDs []uuid.UUID, maxDepth, perParent, limit int, userID uuid.UUID, includeHidden bool) ([]CommentWithUser, error) {
+	return nil, nil
+}
+
+func TestGetRepliesHidesSpoilers(t *testing.T) {
+	root := &Comment{ID: uuid.New(), AnimeID: "1", Episode: intPtr(5), UserID: uuid.New(), ModerationStatus: ModerationApproved, IsApproved: true}
+	viewer := uuid.New()
+	tests := []struct {
+		name          string
+		watched       *int
+		viewer        uuid.UUID
+		moderator     bool
+		reveal        bool
+		spoilerHidden bool
+	}{
+		{"behind", intPtr(3), viewer, false, false, true},
+		{"behind with reveal", intPtr(3), viewer, false, true, false},
+		{"moderator", intPtr(3), viewer, true, false, false},
+		{"caught up", intPtr(5), viewer, false, false, false},
+		{"not in list", nil, viewer, false, false, false},
+		{"anonymous", intPtr(0), uuid.Nil, false, false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestService(&spoilerRepo{fakeRepo: newFakeRepo(root), watched: tt.watched}, nil, &recordingNotifier{})
+			page, err := s.GetReplies(context.Background(), root.ID, tt.viewer, tt.moderator, tt.reveal, 0, PageRequest{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.SpoilerHidden != tt.spoilerHidden {
+				t.Errorf("spoiler hidden = %v, want %v", page.SpoilerHidden, tt.spoilerHidden)
+			}
+		})
+	}
+}
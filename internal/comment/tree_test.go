@@ -0,0 +1,225 @@
+package comment
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// treeRepo - ветки комментариев в памяти. List и GetDescendants повторяют
+// порядок и ограничения SQL-запросов: ответы от старых к новым, не больше
+// perParent на комментарий и limit всего, верхние уровни первыми.
+type treeRepo struct {
+	*fakeRepo
+	children map[uuid.UUID][]CommentWithUser
+}
+
+func newTreeRepo(root *Comment) *treeRepo {
+	return &treeRepo{fakeRepo: newFakeRepo(root), children: map[uuid.UUID][]CommentWithUser{}}
+}
+
+// reply добавляет n ответов на parent, каждый новее предыдущего
+func (r *treeRepo) reply(parent uuid.UUID, n int) []uuid.UUID {
+	ids := make([]uuid.UUID, 0, n)
+	for i := 0; i < n; i++ {
+		id := uuid.New()
+		created := time.Date(2026, 1, 1, 0, 0, len(r.comments), 0, time.UTC)
+		c := &Comment{ID: id, AnimeID: "1", ParentID: &parent, UserID: uuid.New(), CreatedAt: created,
+			ModerationStatus: ModerationApproved, IsApproved: true}
+		r.comments[id] = c
+		r.children[parent] = append(r.children[parent], CommentWithUser{Comment: *c})
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func (r *treeRepo) withCount(c CommentWithUser) CommentWithUser {
+	c.ReplyCount = len(r.children[c.ID])
+	return c
+}
+
+func (r *treeRepo) List(opts ListOptions) ([]CommentWithUser, error) {
+	var page []CommentWithUser
+	for _, c := range r.children[*opts.ParentID] {
+		if opts.After != nil && !c.CreatedAt.After(opts.After.CreatedAt) {
+			continue
+		}
+		if len(page) == opts.Limit {
+			break
+		}
+		page = append(page, r.withCount(c))
+	}
+	return page, nil
+}
+
+func (r *treeRepo) GetDescendants(parentIDs []uuid.UUID, maxDepth, perParent, limit int, userID uuid.UUID, includeHidden bool) ([]CommentWithUser, error) {
+	var result, level []CommentWithUser
+	parents := parentIDs
+	for depth := 2; depth <= maxDepth && len(parents) > 0; depth++ {
+		level = level[:0]
+		for _, parent := range parents {
+			children := r.children[parent]
+			for _, c := range children[:min(perParent, len(children))] {
+				c = r.withCount(c)
+				c.Depth = depth
+				level = append(level, c)
+			}
+		}
+		sort.Slice(level, func(i, j int) bool { return level[i].CreatedAt.Before(level[j].CreatedAt) })
+		parents = parents[:0:0]
+		for _, c := range level {
+			if len(result) == limit {
+				return result, nil
+			}
+			result = append(result, c)
+			parents = append(parents, c.ID)
+		}
+	}
+	return result, nil
+}
+
+func TestGetRepliesCapsChildrenPerNode(t *testing.T) {
+	root := &Comment{ID: uuid.New(), AnimeID: "1", ModerationStatus: ModerationApproved, IsApproved: true}
+	repo := newTreeRepo(root)
+	busy := repo.reply(root.ID, 1)[0]
+	repo.reply(busy, TreeRepliesPerNode+5)
+	quiet := repo.reply(root.ID, 1)[0]
+	repo.reply(quiet, 2)
+
+	s := newTestService(repo, nil, &recordingNotifier{})
+	page, err := s.GetReplies(context.Background(), root.ID, uuid.Nil, false, false, 2, PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Comments) != 2 {
+		t.Fatalf("roots = %d, want 2", len(page.Comments))
+	}
+
+	tests := []struct {
+		node       *CommentNode
+		replies    int
+		hasMore    bool
+		withCursor bool
+	}{
+		{page.Comments[0], TreeRepliesPerNode, true, true},
+		{page.Comments[1], 2, false, false},
+	}
+	for i, tt := range tests {
+		if len(tt.node.Replies) != tt.replies || tt.node.HasMoreReplies != tt.hasMore || (tt.node.RepliesCursor != "") != tt.withCursor {
+			t.Errorf("node %d: replies = %d, has more = %v, cursor = %q", i, len(tt.node.Replies), tt.node.HasMoreReplies, tt.node.RepliesCursor)
+		}
+	}
+	// Ответы второго уровня при depth=2 не загружаются, но они есть
+	if leaf := page.Comments[1].Replies[0]; leaf.HasMoreReplies || leaf.RepliesCursor != "" {
+		t.Errorf("leaf without replies: has more = %v, cursor = %q", leaf.HasMoreReplies, leaf.RepliesCursor)
+	}
+}
+
+func TestRepliesCursorContinuesTruncatedNode(t *testing.T) {
+	root := &Comment{ID: uuid.New(), AnimeID: "1", ModerationStatus: ModerationApproved, IsApproved: true}
+	repo := newTreeRepo(root)
+	busy := repo.reply(root.ID, 1)[0]
+	all := repo.reply(busy, TreeRepliesPerNode+5)
+
+	s := newTestService(repo, nil, &recordingNotifier{})
+	page, err := s.GetReplies(context.Background(), root.ID, uuid.Nil, false, false, 2, PageRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	node := page.Comments[0]
+
+	seen := make([]uuid.UUID, 0, len(all))
+	for _, reply := range node.Replies {
+		seen = append(seen, reply.ID)
+	}
+	cursor := node.RepliesCursor
+	for pages := 0; cursor != ""; pages++ {
+		if pages > len(all) {
+			t.Fatal("cursor does not advance")
+		}
+		next, err := s.GetReplies(context.Background(), busy, uuid.Nil, false, false, 1, PageRequest{Cursor: cursor, Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, reply := range next.Comments {
+			seen = append(seen, reply.ID)
+		}
+		cursor = next.NextCursor
+	}
+
+	if len(seen) != len(all) {
+		t.Fatalf("loaded %d replies, want %d", len(seen), len(all))
+	}
+	for i := range all {
+		if seen[i] != all[i] {
+			t.Fatalf("reply %d is %s, want %s: replies skipped or repeated", i, seen[i], all[i])
+		}
+	}
+}
+
+func TestGetRepliesCapsTotal(t *testing.T) {
+	root := &Comment{ID: uuid.New(), AnimeID: "1", ModerationStatus: ModerationApproved, IsApproved: true}
+	repo := newTreeRepo(root)
+	branches := MaxTreeReplies/TreeRepliesPerNode + 10
+	for _, branch := range repo.reply(root.ID, branches) {
+		repo.reply(branch, TreeRepliesPerNode)
+	}
+
+	s := newTestService(repo, nil, &recordingNotifier{})
+	page, err := s.GetReplies(context.Background(), root.ID, uuid.Nil, false, false, 2, PageRequest{Limit: MaxPageSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, unloaded := 0, 0
+	for _, node := range page.Comments {
+		loaded += len(node.Replies)
+		if len(node.Replies) == 0 {
+			unloaded++
+			if !node.HasMoreReplies || node.RepliesCursor != "" {
+				t.Errorf("branch without loaded replies: has more = %v, cursor = %q", node.HasMoreReplies, node.RepliesCursor)
+			}
+		}
+	}
+	if loaded != MaxTreeReplies {
+		t.Errorf("loaded %d replies, want %d", loaded, MaxTreeReplies)
+	}
+	if unloaded != branches-MaxTreeReplies/TreeRepliesPerNode {
+		t.Errorf("branches without replies = %d, want %d", unloaded, branches-MaxTreeReplies/TreeRepliesPerNode)
+	}
+}
+
+func TestGetDescendantsQueryIsCapped(t *testing.T) {
+	db := dryRunDB(t).Session(&gorm.Session{Logger: logger.Discard})
+	var sql string
+	var vars []interface{}
+	db.Callback().Row().After("gorm:row").Register("test:capture", func(tx *gorm.DB) {
+		sql, vars = tx.Statement.SQL.String(), tx.Statement.Vars
+	})
+
+	_, err := NewRepository(db).GetDescendants([]uuid.UUID{uuid.New()}, 3, 7, 40, uuid.New(), false)
+	if err != nil && !errors.Is(err, gorm.ErrDryRunModeUnsupported) {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"CROSS JOIN LATERAL", "ORDER BY comments.created_at, comments.id LIMIT $", "ORDER BY thread.depth, thread.created_at, thread.id\nLIMIT $"} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("query has no %q:\n%s", want, sql)
+		}
+	}
+	for _, want := range []interface{}{7, 40} {
+		found := false
+		for _, v := range vars {
+			found = found || v == want
+		}
+		if !found {
+			t.Errorf("query vars %v have no %v", vars, want)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package markup
+
+import (
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Render собирает HTML из дерева. Текст экранируется, атрибуты задаются
+// только здесь, поэтому результат можно вставлять в страницу как есть.
+func Render(nodes []*Node) string {
+	var b strings.Builder
+	render(&b, nodes)
+	return b.String()
+}
+
+func render(b *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Type {
+		case NodeParagraph:
+			wrap(b, "<p>", n.Children, "</p>")
+		case NodeQuote:
+			wrap(b, "<blockquote>", n.Children, "</blockquote>")
+		case NodeText:
+			b.WriteString(html.EscapeString(n.Text))
+		case NodeBreak:
+			b.WriteString("<br>")
+		case NodeBold:
+			wrap(b, "<strong>", n.Children, "</strong>")
+		case NodeItalic:
+			wrap(b, "<em>", n.Children, "</em>")
+		case NodeSpoiler:
+			wrap(b, `<span class="spoiler">`, n.Children, "</span>")
+		case NodeLink:
+			open := `<a href="` + html.EscapeString(n.URL) + `" rel="nofollow ugc noopener" target="_blank">`
+			wrap(b, open, n.Children, "</a>")
+		case NodeAnime:
+			id := strconv.Itoa(n.AnimeID)
+			b.WriteString(`<a class="anime-mention" href="/anime/` + id + `" data-anime-id="` + id + `">#` + id + `</a>`)
+		case NodeMention:
+			name := html.EscapeString(n.Username)
+			b.WriteString(`<a class="user-mention" href="/users/` + name + `" data-username="` + name + `">@` + name + `</a>`)
+		}
+	}
+}
+
+func wrap(b *strings.Builder, open string, children []*Node, close string) {
+	b.WriteString(open)
+	render(b, children)
+	b.WriteString(close)
+}
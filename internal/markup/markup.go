@@ -0,0 +1,370 @@
+package markup
+
+import (
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Разметка комментариев:
+//
+//	**жирный**, *курсив*, ||спойлер||
+//	[текст](https://shikimori.one/...) и просто https://shikimori.one/...
+//	[anime=5114] - упоминание аниме по Shikimori ID
+//	@username - упоминание пользователя
+//	> цитата (строки с > в начале, вложенные - >>)
+//
+// \ перед служебным символом выводит его как есть. Ссылки разрешены только
+// на домены из списка, остальные остаются текстом. Все, что не разобрано
+// как разметка, выводится текстом, поэтому HTML в комментарии невозможен.
+
+// Типы узлов дерева
+const (
+	NodeParagraph = "paragraph"
+	NodeQuote     = "quote"
+	NodeText      = "text"
+	NodeBreak     = "break"
+	NodeBold      = "bold"
+	NodeItalic    = "italic"
+	NodeSpoiler   = "spoiler"
+	NodeLink      = "link"
+	NodeAnime     = "anime"
+	NodeMention   = "mention"
+)
+
+const (
+	maxQuoteDepth  = 3
+	maxInlineDepth = 8
+)
+
+// defaultLinkHosts - куда можно ссылаться, если MARKUP_LINK_HOSTS не задан
+var defaultLinkHosts = []string{
+	"shikimori.one", "shikimori.me", "myanimelist.net", "anilist.co",
+	"youtube.com", "youtu.be", "wikipedia.org",
+}
+
+// Node - узел разобранного текста
+type Node struct {
+	Type     string  `json:"type"`
+	Text     string  `json:"text,omitempty"`     // NodeText
+	URL      string  `json:"url,omitempty"`      // NodeLink
+	AnimeID  int     `json:"anime_id,omitempty"` // NodeAnime
+	Username string  `json:"username,omitempty"` // NodeMention, в нижнем регистре
+	Children []*Node `json:"children,omitempty"`
+}
+
+// Document - разобранный комментарий
+type Document struct {
+	Nodes    []*Node  `json:"ast"`
+	HTML     string   `json:"html"`
+	Length   int      `json:"length"`                   // Видимых символов, см. Parse
+	Mentions []int    `json:"anime_mentions,omitempty"` // Упомянутые аниме без повторов
+	Users    []string `json:"user_mentions,omitempty"`  // Упомянутые пользователи без повторов
+}
+
+// Parser разбирает разметку. Безопасен для одновременного использования.
+type Parser struct {
+	hosts []string // Разрешенные домены ссылок вместе с поддоменами
+}
+
+func NewParser(hosts []string) *Parser {
+	p := &Parser{}
+	for _, host := range hosts {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host != "" {
+			p.hosts = append(p.hosts, host)
+		}
+	}
+	return p
+}
+
+// NewParserFromEnv берет разрешенные домены ссылок из MARKUP_LINK_HOSTS
+// (через запятую)
+func NewParserFromEnv() *Parser {
+	if hosts := os.Getenv("MARKUP_LINK_HOSTS"); hosts != "" {
+		return NewParser(strings.Split(hosts, ","))
+	}
+	return NewParser(defaultLinkHosts)
+}
+
+// Parse разбирает текст. Length считает символы, которые видит читатель:
+// без служебных символов разметки и адресов ссылок с подписью; перенос
+// строки и упоминание аниме - по одному символу.
+func (p *Parser) Parse(text string) *Document {
+	text = strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(text)
+	doc := &Document{Nodes: p.blocks(text, 0)}
+	doc.HTML = Render(doc.Nodes)
+
+	seen := map[int]bool{}
+	seenUsers := map[string]bool{}
+	walk(doc.Nodes, func(n *Node) {
+		switch n.Type {
+		case NodeText:
+			doc.Length += utf8.RuneCountInString(n.Text)
+		case NodeBreak:
+			doc.Length++
+		case NodeAnime:
+			doc.Length++
+			if !seen[n.AnimeID] {
+				seen[n.AnimeID] = true
+				doc.Mentions = append(doc.Mentions, n.AnimeID)
+			}
+		case NodeMention:
+			doc.Length += 1 + utf8.RuneCountInString(n.Username)
+			if !seenUsers[n.Username] {
+				seenUsers[n.Username] = true
+				doc.Users = append(doc.Users, n.Username)
+			}
+		}
+	})
+	return doc
+}
+
+// Empty - в тексте нет ничего, кроме пробелов и пустой разметки
+func (d *Document) Empty() bool {
+	empty := true
+	walk(d.Nodes, func(n *Node) {
+		if n.Type == NodeAnime || n.Type == NodeMention || n.Type == NodeText && strings.TrimSpace(n.Text) != "" {
+			empty = false
+		}
+	})
+	return empty
+}
+
+func walk(nodes []*Node, fn func(*Node)) {
+	for _, n := range nodes {
+		fn(n)
+		walk(n.Children, fn)
+	}
+}
+
+// blocks делит текст на абзацы (разделены пустой строкой) и цитаты
+func (p *Parser) blocks(text string, depth int) []*Node {
+	var (
+		nodes []*Node
+		para  []string
+		quote []string
+	)
+	flushPara := func() {
+		if len(para) > 0 {
+			nodes = append(nodes, &Node{Type: NodeParagraph, Children: p.inline(strings.Join(para, "\n"), 0, false)})
+			para = nil
+		}
+	}
+	flushQuote := func() {
+		if len(quote) > 0 {
+			nodes = append(nodes, &Node{Type: NodeQuote, Children: p.blocks(strings.Join(quote, "\n"), depth+1)})
+			quote = nil
+		}
+	}
+	for _, line := range strings.Split(text, "\n") {
+		if depth < maxQuoteDepth && strings.HasPrefix(line, ">") {
+			flushPara()
+			line = strings.TrimPrefix(line[1:], " ")
+			quote = append(quote, line)
+			continue
+		}
+		flushQuote()
+		if strings.TrimSpace(line) == "" {
+			flushPara()
+			continue
+		}
+		para = append(para, line)
+	}
+	flushPara()
+	flushQuote()
+	return nodes
+}
+
+// inline разбирает выделение, спойлеры, ссылки и упоминания внутри абзаца
+func (p *Parser) inline(s string, depth int, inLink bool) []*Node {
+	var (
+		nodes []*Node
+		text  strings.Builder
+	)
+	flush := func() {
+		if text.Len() > 0 {
+			nodes = append(nodes, &Node{Type: NodeText, Text: text.String()})
+			text.Reset()
+		}
+	}
+	add := func(n *Node) {
+		flush()
+		nodes = append(nodes, n)
+	}
+
+	for i := 0; i < len(s); {
+		rest := s[i:]
+		switch {
+		case rest[0] == '\\' && len(rest) > 1 && strings.IndexByte(`\*|[]()>@`, rest[1]) >= 0:
+			text.WriteByte(rest[1])
+			i += 2
+			continue
+
+		case rest[0] == '\n':
+			add(&Node{Type: NodeBreak})
+			i++
+			continue
+
+		case depth < maxInlineDepth && strings.HasPrefix(rest, "||"):
+			if end := findClose(rest, 2, "||"); end > 2 {
+				add(&Node{Type: NodeSpoiler, Children: p.inline(rest[2:end], depth+1, inLink)})
+				i += end + 2
+				continue
+			}
+
+		case depth < maxInlineDepth && strings.HasPrefix(rest, "**"):
+			if end := findClose(rest, 2, "**"); end > 2 && flanked(rest[2:end]) {
+				add(&Node{Type: NodeBold, Children: p.inline(rest[2:end], depth+1, inLink)})
+				i += end + 2
+				continue
+			}
+
+		case depth < maxInlineDepth && rest[0] == '*':
+			if end := findClose(rest, 1, "*"); end > 1 && flanked(rest[1:end]) {
+				add(&Node{Type: NodeItalic, Children: p.inline(rest[1:end], depth+1, inLink)})
+				i += end + 1
+				continue
+			}
+
+		case strings.HasPrefix(rest, "[anime="):
+			if end := strings.IndexByte(rest, ']'); end > 0 {
+				if id, err := strconv.Atoi(rest[len("[anime="):end]); err == nil && id > 0 && !inLink {
+					add(&Node{Type: NodeAnime, AnimeID: id})
+					i += end + 1
+					continue
+				}
+			}
+
+		case rest[0] == '[' && !inLink && depth < maxInlineDepth:
+			if label, link, n, ok := p.parseLink(rest); ok {
+				add(&Node{Type: NodeLink, URL: link, Children: p.inline(label, depth+1, true)})
+				i += n
+				continue
+			}
+
+		case rest[0] == '@' && !inLink && wordStart(s, i):
+			if name := username(rest[1:]); name != "" {
+				add(&Node{Type: NodeMention, Username: strings.ToLower(name)})
+				i += 1 + len(name)
+				continue
+			}
+
+		case !inLink && (strings.HasPrefix(rest, "https://") || strings.HasPrefix(rest, "http://")) && wordStart(s, i):
+			raw := autolink(rest)
+			if link, ok := p.allowedURL(raw); ok {
+				add(&Node{Type: NodeLink, URL: link, Children: []*Node{{Type: NodeText, Text: raw}}})
+				i += len(raw)
+				continue
+			}
+		}
+		// Не разметка - копируем символ целиком
+		_, size := utf8.DecodeRuneInString(rest)
+		text.WriteString(rest[:size])
+		i += size
+	}
+	flush()
+	return nodes
+}
+
+// findClose ищет закрывающий delim начиная с from, пропуская
+// экранированные символы. Одиночная * не закрывается парой **.
+func findClose(s string, from int, delim string) int {
+	for k := from; k < len(s); {
+		switch {
+		case s[k] == '\\':
+			k += 2
+		case delim == "*" && strings.HasPrefix(s[k:], "**"):
+			k += 2
+		case strings.HasPrefix(s[k:], delim):
+			return k
+		default:
+			k++
+		}
+	}
+	return -1
+}
+
+// flanked - выделение не начинается и не кончается пробелом, чтобы
+// "2 * 3 * 4" осталось текстом
+func flanked(inner string) bool {
+	first, _ := utf8.DecodeRuneInString(inner)
+	last, _ := utf8.DecodeLastRuneInString(inner)
+	return !unicode.IsSpace(first) && !unicode.IsSpace(last)
+}
+
+// parseLink разбирает [подпись](адрес) и возвращает длину разметки
+func (p *Parser) parseLink(s string) (label, link string, n int, ok bool) {
+	closeLabel := findClose(s, 1, "]")
+	if closeLabel <= 1 || !strings.HasPrefix(s[closeLabel:], "](") {
+		return "", "", 0, false
+	}
+	closeURL := strings.IndexByte(s[closeLabel+2:], ')')
+	if closeURL < 0 {
+		return "", "", 0, false
+	}
+	raw := s[closeLabel+2 : closeLabel+2+closeURL]
+	link, ok = p.allowedURL(raw)
+	if !ok {
+		return "", "", 0, false
+	}
+	return s[1:closeLabel], link, closeLabel + 3 + closeURL, true
+}
+
+// wordStart - голая ссылка начинается не посреди слова
+func wordStart(s string, i int) bool {
+	if i == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(s[:i])
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+// username выделяет имя после @: 3-20 латинских букв, цифр или _,
+// за которыми не идут другие символы имени
+func username(s string) string {
+	n := 0
+	for n < len(s) && usernameChar(s[n]) {
+		n++
+	}
+	if n < 3 || n > 20 {
+		return ""
+	}
+	return s[:n]
+}
+
+func usernameChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// autolink выделяет адрес до пробела без знаков препинания в конце
+func autolink(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || strings.ContainsRune(`<>"'|`, r)
+	})
+	if end < 0 {
+		end = len(s)
+	}
+	return strings.TrimRight(s[:end], ".,;:!?)*")
+}
+
+// allowedURL проверяет схему и домен ссылки
+func (p *Parser) allowedURL(raw string) (string, bool) {
+	if strings.IndexFunc(raw, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0 {
+		return "", false
+	}
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.User != nil {
+		return "", false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, allowed := range p.hosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return u.String(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,39 @@
+package markup
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SpoilerPlaceholder заменяет скрытый текст в PlainText
+const SpoilerPlaceholder = "[спойлер]"
+
+// PlainText собирает из дерева текст без разметки для превью и уведомлений.
+// Содержимое спойлеров не выводится, у ссылок остается только подпись.
+func PlainText(nodes []*Node) string {
+	var b strings.Builder
+	plain(&b, nodes)
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+func plain(b *strings.Builder, nodes []*Node) {
+	for _, n := range nodes {
+		switch n.Type {
+		case NodeText:
+			b.WriteString(n.Text)
+		case NodeBreak:
+			b.WriteString(" ")
+		case NodeParagraph, NodeQuote:
+			plain(b, n.Children)
+			b.WriteString(" ")
+		case NodeSpoiler:
+			b.WriteString(SpoilerPlaceholder)
+		case NodeAnime:
+			b.WriteString("#" + strconv.Itoa(n.AnimeID))
+		case NodeMention:
+			b.WriteString("@" + n.Username)
+		default:
+			plain(b, n.Children)
+		}
+	}
+}
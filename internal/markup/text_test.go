@@ -0,0 +1,27 @@
+package markup
+
+import "testing"
+
+func TestPlainText(t *testing.T) {
+	p := NewParser([]string{"shikimori.one"})
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"просто текст", "просто текст"},
+		{"**жирный** и *курсив*", "жирный и курсив"},
+		{"до ||секрет|| после", "до " + SpoilerPlaceholder + " после"},
+		{"||**вложенный** секрет||", SpoilerPlaceholder},
+		{"[подпись](https://shikimori.one/animes/1)", "подпись"},
+		{"https://shikimori.one/animes/1", "https://shikimori.one/animes/1"},
+		{"смотри [anime=5114] @Someone", "смотри #5114 @someone"},
+		{"> цитата\n\nответ", "цитата ответ"},
+		{"строка\nстрока", "строка строка"},
+		{"<b>&amp;</b>", "<b>&amp;</b>"},
+	}
+	for _, tt := range tests {
+		if got := PlainText(p.Parse(tt.in).Nodes); got != tt.want {
+			t.Errorf("PlainText(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
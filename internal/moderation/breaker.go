@@ -0,0 +1,92 @@
+package moderation
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// Состояния предохранителя
+const (
+	BreakerClosed   = "closed"    // Запросы идут
+	BreakerOpen     = "open"      // Запросы не отправляются до конца паузы
+	BreakerHalfOpen = "half_open" // Пропускается один пробный запрос
+)
+
+var ErrCircuitOpen = errors.New("moderation service circuit is open")
+
+// breaker - предохранитель: после failures ошибок подряд перестает
+// обращаться к сервису на cooldown, затем пробует один запрос
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	cooldown  time.Duration
+	state     string
+	streak    int
+	openedAt  time.Time
+	probing   bool
+	lastError string
+}
+
+func newBreaker(failures int, cooldown time.Duration) *breaker {
+	return &breaker{failures: failures, cooldown: cooldown, state: BreakerClosed}
+}
+
+// allow сообщает, можно ли отправить запрос
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerClosed
+	b.streak = 0
+	b.probing = false
+}
+
+func (b *breaker) failure(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.streak++
+	b.probing = false
+	b.lastError = err.Error()
+	if b.state == BreakerHalfOpen || (b.failures > 0 && b.streak >= b.failures) {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// release завершает пробный запрос без результата
+func (b *breaker) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+func (b *breaker) snapshot() (state string, streak int, lastError string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	state = b.state
+	if state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		state = BreakerHalfOpen
+	}
+	return state, b.streak, b.lastError
+}
@@ -0,0 +1,82 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	failed := errors.New("service unavailable")
+
+	t.Run("opens after consecutive failures", func(t *testing.T) {
+		b := newBreaker(3, time.Minute)
+		for i := 0; i < 2; i++ {
+			if err := b.allow(); err != nil {
+				t.Fatalf("attempt %d: %v", i, err)
+			}
+			b.failure(failed)
+		}
+		if state, streak, _ := b.snapshot(); state != BreakerClosed || streak != 2 {
+			t.Fatalf("state = %s, streak = %d, want closed after 2 failures", state, streak)
+		}
+		b.failure(failed)
+		if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("allow() = %v, want ErrCircuitOpen", err)
+		}
+		if state, _, lastError := b.snapshot(); state != BreakerOpen || lastError != failed.Error() {
+			t.Fatalf("state = %s, lastError = %q", state, lastError)
+		}
+	})
+
+	t.Run("success resets the streak", func(t *testing.T) {
+		b := newBreaker(2, time.Minute)
+		b.failure(failed)
+		b.success()
+		b.failure(failed)
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() = %v, want closed circuit", err)
+		}
+	})
+
+	t.Run("zero failures never opens", func(t *testing.T) {
+		b := newBreaker(0, time.Minute)
+		for i := 0; i < 10; i++ {
+			b.failure(failed)
+		}
+		if err := b.allow(); err != nil {
+			t.Fatalf("allow() = %v", err)
+		}
+	})
+
+	tests := []struct {
+		name   string
+		finish func(b *breaker)
+		want   string
+	}{
+		{"probe succeeds", func(b *breaker) { b.success() }, BreakerClosed},
+		{"probe fails", func(b *breaker) { b.failure(failed) }, BreakerOpen},
+		{"probe released", func(b *breaker) { b.release() }, BreakerHalfOpen},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker(1, time.Minute)
+			b.failure(failed)
+			b.openedAt = time.Now().Add(-time.Minute) // Пауза прошла
+
+			if state, _, _ := b.snapshot(); state != BreakerHalfOpen {
+				t.Fatalf("state after cooldown = %s, want half_open", state)
+			}
+			if err := b.allow(); err != nil {
+				t.Fatalf("probe: %v", err)
+			}
+			if err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("second request during probe = %v, want ErrCircuitOpen", err)
+			}
+			tt.finish(b)
+			if state, _, _ := b.snapshot(); state != tt.want {
+				t.Fatalf("state = %s, want %s", state, tt.want)
+			}
+		})
+	}
+}
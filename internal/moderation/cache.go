@@ -0,0 +1,78 @@
+package moderation
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// verdictCache - LRU-кэш вердиктов по хешу текста
+type verdictCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List // Новые в начале
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type cacheEntry struct {
+	key       [sha256.Size]byte
+	verdict   Verdict
+	expiresAt time.Time
+}
+
+func newVerdictCache(size int, ttl time.Duration) *verdictCache {
+	return &verdictCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+func (c *verdictCache) get(text string) (*Verdict, bool) {
+	if c.size <= 0 {
+		return nil, false
+	}
+	key := sha256.Sum256([]byte(text))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	verdict := entry.verdict
+	return &verdict, true
+}
+
+func (c *verdictCache) put(text string, verdict *Verdict) {
+	if c.size <= 0 {
+		return
+	}
+	key := sha256.Sum256([]byte(text))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.Remove(element)
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{
+		key:       key,
+		verdict:   *verdict,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
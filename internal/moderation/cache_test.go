@@ -0,0 +1,69 @@
+package moderation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVerdictCache(t *testing.T) {
+	t.Run("returns a copy", func(t *testing.T) {
+		c := newVerdictCache(10, time.Minute)
+		c.put("текст", &Verdict{Decision: Approve})
+		got, ok := c.get("текст")
+		if !ok || got.Decision != Approve {
+			t.Fatalf("get() = %v, %v", got, ok)
+		}
+		got.Decision = Reject
+		if again, _ := c.get("текст"); again.Decision != Approve {
+			t.Fatal("changing a returned verdict changed the cache")
+		}
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		c := newVerdictCache(2, time.Minute)
+		c.put("a", &Verdict{Decision: Approve})
+		c.put("b", &Verdict{Decision: Review})
+		c.get("a")
+		c.put("c", &Verdict{Decision: Reject})
+		if _, ok := c.get("b"); ok {
+			t.Error("b should have been evicted")
+		}
+		for _, text := range []string{"a", "c"} {
+			if _, ok := c.get(text); !ok {
+				t.Errorf("%s was evicted", text)
+			}
+		}
+	})
+
+	t.Run("put replaces", func(t *testing.T) {
+		c := newVerdictCache(2, time.Minute)
+		c.put("a", &Verdict{Decision: Approve})
+		c.put("a", &Verdict{Decision: Reject})
+		if got, _ := c.get("a"); got.Decision != Reject {
+			t.Fatalf("decision = %s, want reject", got.Decision)
+		}
+		if c.order.Len() != 1 {
+			t.Fatalf("entries = %d, want 1", c.order.Len())
+		}
+	})
+
+	t.Run("expires", func(t *testing.T) {
+		c := newVerdictCache(10, time.Minute)
+		c.put("a", &Verdict{Decision: Approve})
+		c.order.Front().Value.(*cacheEntry).expiresAt = time.Now().Add(-time.Second)
+		if _, ok := c.get("a"); ok {
+			t.Fatal("expired verdict returned")
+		}
+		if len(c.entries) != 0 {
+			t.Fatal("expired verdict was not removed")
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		c := newVerdictCache(0, time.Minute)
+		c.put("a", &Verdict{Decision: Approve})
+		if _, ok := c.get("a"); ok {
+			t.Fatal("cache of size 0 returned a verdict")
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package moderation
+
+import (
+	"sync"
+	"time"
+)
+
+// BackendHealth - состояние одной проверки
+type BackendHealth struct {
+	Backend             string     `json:"backend"`
+	Healthy             bool       `json:"healthy"`
+	Breaker             string     `json:"breaker,omitempty"` // См. BreakerClosed и др.
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	LastError           string     `json:"last_error,omitempty"`
+	LastSuccessAt       *time.Time `json:"last_success_at,omitempty"`
+	Requests            int64      `json:"requests"`
+	Failures            int64      `json:"failures"`
+	AvgLatencyMS        float64    `json:"avg_latency_ms"` // Скользящее среднее
+	LastLatencyMS       float64    `json:"last_latency_ms"`
+}
+
+// HealthReporter реализуют проверки, которые могут быть недоступны
+type HealthReporter interface {
+	Health() []BackendHealth
+}
+
+// Health собирает состояние проверок цепочки. Локальные проверки всегда
+// считаются исправными.
+func (c Chain) Health() []BackendHealth {
+	var health []BackendHealth
+	for _, m := range c {
+		if reporter, ok := m.(HealthReporter); ok {
+			health = append(health, reporter.Health()...)
+			continue
+		}
+		health = append(health, BackendHealth{Backend: m.Name(), Healthy: true, Breaker: BreakerClosed})
+	}
+	return health
+}
+
+// latencyStats - счетчики запросов и задержка (EWMA, вес нового замера 0.2)
+type latencyStats struct {
+	mu            sync.Mutex
+	requests      int64
+	failures      int64
+	avg           time.Duration
+	last          time.Duration
+	lastSuccessAt *time.Time
+}
+
+func (s *latencyStats) observe(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	s.last = latency
+	if s.avg == 0 {
+		s.avg = latency
+	} else {
+		s.avg = (s.avg*4 + latency) / 5
+	}
+	if err != nil {
+		s.failures++
+		return
+	}
+	now := time.Now()
+	s.lastSuccessAt = &now
+}
+
+func (s *latencyStats) snapshot() BackendHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return BackendHealth{
+		Requests:      s.requests,
+		Failures:      s.failures,
+		AvgLatencyMS:  float64(s.avg) / float64(time.Millisecond),
+		LastLatencyMS: float64(s.last) / float64(time.Millisecond),
+		LastSuccessAt: s.lastSuccessAt,
+	}
+}
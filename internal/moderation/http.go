@@ -0,0 +1,294 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HTTPOptions - настройки клиента сервиса токсичности
+type HTTPOptions struct {
+	Timeout         time.Duration // На одну попытку
+	Retries         int           // Повторы после первой попытки
+	RetryBackoff    time.Duration // Базовая пауза, растет вдвое с каждой попыткой
+	BreakerFailures int           // Ошибок подряд до размыкания, 0 - не размыкать
+	BreakerCooldown time.Duration
+	CacheSize       int // 0 - без кэша
+	CacheTTL        time.Duration
+}
+
+// HTTPOptionsFromEnv читает MODERATION_TIMEOUT, MODERATION_RETRIES,
+// MODERATION_BREAKER_FAILURES, MODERATION_BREAKER_COOLDOWN,
+// MODERATION_CACHE_SIZE и MODERATION_CACHE_TTL
+func HTTPOptionsFromEnv() HTTPOptions {
+	return HTTPOptions{
+		Timeout:         envDuration("MODERATION_TIMEOUT", 3*time.Second),
+		Retries:         envInt("MODERATION_RETRIES", 2),
+		RetryBackoff:    100 * time.Millisecond,
+		BreakerFailures: envInt("MODERATION_BREAKER_FAILURES", 5),
+		BreakerCooldown: envDuration("MODERATION_BREAKER_COOLDOWN", 30*time.Second),
+		CacheSize:       envInt("MODERATION_CACHE_SIZE", 1000),
+		CacheTTL:        envDuration("MODERATION_CACHE_TTL", 10*time.Minute),
+	}
+}
+
+// HTTPModerator - сервис токсичности (comment-moderation). Ожидает ответ
+// {"toxicity_score": 0.1, "details": {"insult": 0.05, ...}}.
+//
+// Запрос идемпотентен, поэтому сетевые ошибки, 5xx и 429 повторяются с
+// паузой со случайным разбросом. После серии ошибок предохранитель
+// перестает обращаться к сервису, и проверка сразу возвращает
+// ErrCircuitOpen. Одинаковые тексты проверяются один раз: вердикт
+// кэшируется, а одновременные запросы с тем же текстом ждут общий запрос.
+// Общий запрос не зависит от отмены контекста того, кто его начал, -
+// ожидающие получат результат, даже если первый вызывающий ушел.
+//
+// Пакетной отправки нет: сервис принимает один текст на запрос.
+type HTTPModerator struct {
+	url        string
+	thresholds Thresholds
+	opts       HTTPOptions
+	httpClient *http.Client
+	breaker    *breaker
+	cache      *verdictCache
+
+	mu       sync.Mutex
+	inflight map[string]*call
+	stats    latencyStats
+}
+
+// call - выполняющийся запрос, его результат получат все ожидающие
+type call struct {
+	done    chan struct{}
+	verdict *Verdict
+	err     error
+}
+
+func NewHTTPModerator(url string, thresholds Thresholds, opts HTTPOptions) *HTTPModerator {
+	return &HTTPModerator{
+		url:        strings.TrimRight(url, "/"),
+		thresholds: thresholds,
+		opts:       opts,
+		httpClient: &http.Client{},
+		breaker:    newBreaker(opts.BreakerFailures, opts.BreakerCooldown),
+		cache:      newVerdictCache(opts.CacheSize, opts.CacheTTL),
+		inflight:   map[string]*call{},
+	}
+}
+
+func (m *HTTPModerator) Name() string { return "toxicity" }
+
+func (m *HTTPModerator) Moderate(ctx context.Context, text string) (*Verdict, error) {
+	if verdict, ok := m.cache.get(text); ok {
+		return verdict, nil
+	}
+
+	m.mu.Lock()
+	c, ok := m.inflight[text]
+	if !ok {
+		c = &call{done: make(chan struct{})}
+		m.inflight[text] = c
+		go m.run(ctx, text, c)
+	}
+	m.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.verdict, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// run выполняет общий запрос. Контекст отвязан от отмены вызывающего и
+// ограничен callTimeout, значения контекста сохраняются.
+func (m *HTTPModerator) run(ctx context.Context, text string, c *call) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.callTimeout())
+	defer cancel()
+
+	c.verdict, c.err = m.moderate(ctx, text)
+	if c.err == nil {
+		m.cache.put(text, c.verdict)
+	}
+
+	m.mu.Lock()
+	delete(m.inflight, text)
+	m.mu.Unlock()
+	close(c.done)
+}
+
+// callTimeout - предел общего запроса: все попытки и паузы между ними
+func (m *HTTPModerator) callTimeout() time.Duration {
+	if m.opts.Timeout <= 0 {
+		return maxCallTimeout
+	}
+	attempts := time.Duration(m.opts.Retries + 1)
+	return attempts*m.opts.Timeout + m.opts.RetryBackoff<<(m.opts.Retries+1)
+}
+
+// maxCallTimeout - предел общего запроса без таймаута на попытку
+const maxCallTimeout = time.Minute
+
+// moderate отправляет запрос с повторами через предохранитель
+func (m *HTTPModerator) moderate(ctx context.Context, text string) (*Verdict, error) {
+	if err := m.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	for attempt := 0; attempt <= m.opts.Retries; attempt++ {
+		if attempt > 0 {
+			// Полный разброс: пауза от 0 до base * 2^attempt
+			backoff := m.opts.RetryBackoff << attempt
+			select {
+			case <-time.After(rand.N(backoff + 1)):
+			case <-ctx.Done():
+				m.breaker.release()
+				return nil, ctx.Err()
+			}
+		}
+
+		var verdict *Verdict
+		started := time.Now()
+		verdict, err = m.request(ctx, text)
+		m.stats.observe(time.Since(started), err)
+		if err == nil {
+			m.breaker.success()
+			return verdict, nil
+		}
+		if ctx.Err() != nil {
+			// Запрос отменил вызывающий - сервис в этом не виноват
+			m.breaker.release()
+			return nil, ctx.Err()
+		}
+		if !retryable(err) {
+			break
+		}
+	}
+	m.breaker.failure(err)
+	return nil, err
+}
+
+// statusError - ответ сервиса с неуспешным кодом
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "moderation service returned " + e.status
+}
+
+func retryable(err error) bool {
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.code >= 500 || status.code == http.StatusTooManyRequests
+	}
+	var invalid *invalidResponseError
+	return !errors.As(err, &invalid)
+}
+
+// invalidResponseError - ответ сервиса не соответствует ожидаемой схеме
+type invalidResponseError struct {
+	reason string
+}
+
+func (e *invalidResponseError) Error() string {
+	return "invalid moderation response: " + e.reason
+}
+
+func (m *HTTPModerator) request(ctx context.Context, text string) (*Verdict, error) {
+	requestBody, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return nil, err
+	}
+
+	if m.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.opts.Timeout)
+		defer cancel()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url+"/moderate", bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := m.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+		return nil, &statusError{code: resp.StatusCode, status: resp.Status}
+	}
+
+	var result struct {
+		ToxicityScore *float64           `json:"toxicity_score"`
+		Details       map[string]float64 `json:"details"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&result); err != nil {
+		return nil, &invalidResponseError{reason: err.Error()}
+	}
+	if result.ToxicityScore == nil {
+		return nil, &invalidResponseError{reason: "toxicity_score is missing"}
+	}
+	if !isProbability(*result.ToxicityScore) {
+		return nil, &invalidResponseError{reason: "toxicity_score is out of range"}
+	}
+	for label, score := range result.Details {
+		if !isProbability(score) {
+			return nil, &invalidResponseError{reason: fmt.Sprintf("score of %q is out of range", label)}
+		}
+	}
+
+	verdict := &Verdict{
+		Decision: m.thresholds.Decide(*result.ToxicityScore),
+		Score:    *result.ToxicityScore,
+		Details:  result.Details,
+		Backend:  m.Name(),
+	}
+	if verdict.Decision != Approve {
+		verdict.Reasons = m.labels(result.Details)
+	}
+	return verdict, nil
+}
+
+func isProbability(value float64) bool {
+	return value >= 0 && value <= 1
+}
+
+// labels - токсичные категории с оценкой выше порога Label
+func (m *HTTPModerator) labels(details map[string]float64) []string {
+	var labels []string
+	for label, score := range details {
+		if score > m.thresholds.Label && label != "non-toxic" {
+			labels = append(labels, fmt.Sprintf("%s (%.0f%%)", label, score*100))
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
+
+// Health - состояние сервиса для мониторинга
+func (m *HTTPModerator) Health() []BackendHealth {
+	state, streak, lastError := m.breaker.snapshot()
+	health := m.stats.snapshot()
+	health.Backend = m.Name()
+	health.Breaker = state
+	health.ConsecutiveFailures = streak
+	health.LastError = lastError
+	health.Healthy = state == BreakerClosed
+	return []BackendHealth{health}
+}
@@ -0,0 +1,81 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHTTPModeratorSharesCallAfterLeaderCancels(t *testing.T) {
+	var requests atomic.Int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Write([]byte(`{"toxicity_score": 0.1, "details": {"non-toxic": 0.9}}`))
+	}))
+	defer server.Close()
+
+	m := NewHTTPModerator(server.URL, Thresholds{Review: 0.5, Reject: 0.8}, HTTPOptions{
+		Timeout:   time.Second,
+		CacheSize: 10,
+		CacheTTL:  time.Minute,
+	})
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leader := make(chan error, 1)
+	go func() {
+		_, err := m.Moderate(leaderCtx, "текст")
+		leader <- err
+	}()
+	for requests.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	follower := make(chan *Verdict, 1)
+	go func() {
+		verdict, err := m.Moderate(context.Background(), "текст")
+		if err != nil {
+			t.Errorf("follower: %v", err)
+		}
+		follower <- verdict
+	}()
+
+	cancelLeader()
+	if err := <-leader; !errors.Is(err, context.Canceled) {
+		t.Fatalf("leader error = %v, want context.Canceled", err)
+	}
+	close(release)
+
+	verdict := <-follower
+	if verdict == nil || verdict.Decision != Approve {
+		t.Fatalf("follower verdict = %+v", verdict)
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("requests = %d, want 1", n)
+	}
+	if _, ok := m.cache.get("текст"); !ok {
+		t.Fatal("verdict of the shared call was not cached")
+	}
+}
+
+func TestHTTPModeratorCallTimeout(t *testing.T) {
+	tests := []struct {
+		opts HTTPOptions
+		want time.Duration
+	}{
+		{HTTPOptions{}, maxCallTimeout},
+		{HTTPOptions{Timeout: time.Second}, time.Second},
+		{HTTPOptions{Timeout: time.Second, Retries: 2, RetryBackoff: 100 * time.Millisecond}, 3*time.Second + 800*time.Millisecond},
+	}
+	for _, tt := range tests {
+		m := NewHTTPModerator("http://moderation.local", Thresholds{}, tt.opts)
+		if got := m.callTimeout(); got != tt.want {
+			t.Errorf("callTimeout(%+v) = %v, want %v", tt.opts, got, tt.want)
+		}
+	}
+}
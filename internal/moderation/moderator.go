@@ -0,0 +1,201 @@
+// Package moderation проверяет тексты пользователей. Проверки (Moderator)
+// собираются в цепочку: локальные правила, HTTP-сервис токсичности и др.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Решения проверки по возрастанию строгости
+const (
+	Approve = "approve"
+	Review  = "review" // Нужна проверка человеком
+	Reject  = "reject"
+)
+
+var severity = map[string]int{Approve: 0, Review: 1, Reject: 2}
+
+// Verdict - результат проверки текста
+type Verdict struct {
+	Decision string             `json:"decision"`
+	Score    float64            `json:"score"`   // Токсичность от 0 до 1
+	Details  map[string]float64 `json:"details"` // Оценки по категориям
+	Reasons  []string           `json:"reasons"` // Понятные пользователю причины
+	Backend  string             `json:"backend"` // Какая проверка приняла решение
+}
+
+// Moderator - одна проверка текста
+type Moderator interface {
+	Name() string
+	Moderate(ctx context.Context, text string) (*Verdict, error)
+}
+
+// Thresholds - пороги токсичности: от Review текст уходит на проверку,
+// от Reject отклоняется. Label - с какой оценки категория попадает
+// в причины отказа.
+type Thresholds struct {
+	Review float64
+	Reject float64
+	Label  float64
+}
+
+// ThresholdsFromEnv читает MODERATION_REVIEW_THRESHOLD,
+// MODERATION_REJECT_THRESHOLD и MODERATION_LABEL_THRESHOLD
+func ThresholdsFromEnv() Thresholds {
+	return Thresholds{
+		Review: envFloat("MODERATION_REVIEW_THRESHOLD", 0.5),
+		Reject: envFloat("MODERATION_REJECT_THRESHOLD", 0.9),
+		Label:  envFloat("MODERATION_LABEL_THRESHOLD", 0.5),
+	}
+}
+
+// Decide переводит оценку токсичности в решение
+func (t Thresholds) Decide(score float64) string {
+	switch {
+	case score >= t.Reject:
+		return Reject
+	case score >= t.Review:
+		return Review
+	}
+	return Approve
+}
+
+// Chain запускает проверки по очереди и возвращает самый строгий вердикт.
+// После Reject остальные проверки не вызываются. Если проверка вернула
+// ошибку, а текст уже отправлен на ручную проверку, возвращается этот
+// вердикт - человек все равно его посмотрит.
+type Chain []Moderator
+
+func (c Chain) Name() string {
+	names := make([]string, 0, len(c))
+	for _, m := range c {
+		names = append(names, m.Name())
+	}
+	return strings.Join(names, ",")
+}
+
+func (c Chain) Moderate(ctx context.Context, text string) (*Verdict, error) {
+	result := &Verdict{Decision: Approve, Details: map[string]float64{}}
+	for _, m := range c {
+		verdict, err := m.Moderate(ctx, text)
+		if err != nil {
+			if result.Decision == Review {
+				log.Printf("moderation backend %s failed: %v", m.Name(), err)
+				return result, nil
+			}
+			return nil, err
+		}
+
+		result.Score = max(result.Score, verdict.Score)
+		for label, score := range verdict.Details {
+			result.Details[label] = score
+		}
+		result.Reasons = append(result.Reasons, verdict.Reasons...)
+		// Одобрение записываем за последней проверкой, остальное - за самой строгой
+		if severity[verdict.Decision] > severity[result.Decision] || result.Decision == Approve {
+			result.Decision = verdict.Decision
+			result.Backend = verdict.Backend
+		}
+		if result.Decision == Reject {
+			break
+		}
+	}
+	return result, nil
+}
+
+// Noop одобряет любой текст
+type Noop struct{}
+
+func (Noop) Name() string { return "noop" }
+
+func (Noop) Moderate(ctx context.Context, text string) (*Verdict, error) {
+	return &Verdict{Decision: Approve, Backend: "noop"}, nil
+}
+
+// Health возвращает состояние moderator, если оно известно
+func Health(moderator Moderator) []BackendHealth {
+	if reporter, ok := moderator.(HealthReporter); ok {
+		return reporter.Health()
+	}
+	return []BackendHealth{{Backend: moderator.Name(), Healthy: true}}
+}
+
+// NewFromEnv собирает цепочку из MODERATION_BACKENDS (через запятую:
+// rules, toxicity, noop). По умолчанию - только сервис токсичности, если
+// задан MODERATION_SERVICE_URL, иначе модерации нет, как и раньше.
+// Правила включаются явно: MODERATION_BACKENDS=rules,toxicity.
+func NewFromEnv() (Moderator, error) {
+	thresholds := ThresholdsFromEnv()
+	names := os.Getenv("MODERATION_BACKENDS")
+	if names == "" {
+		names = "toxicity"
+	}
+
+	var chain Chain
+	for _, name := range strings.Split(names, ",") {
+		switch name = strings.TrimSpace(name); name {
+		case "rules":
+			rules, err := NewRulesFromEnv()
+			if err != nil {
+				return nil, fmt.Errorf("load moderation rules: %w", err)
+			}
+			chain = append(chain, rules)
+		case "toxicity":
+			if url := os.Getenv("MODERATION_SERVICE_URL"); url != "" {
+				chain = append(chain, NewHTTPModerator(url, thresholds, HTTPOptionsFromEnv()))
+			}
+		case "noop", "":
+		default:
+			log.Printf("unknown moderation backend %q, skipping", name)
+		}
+	}
+	if len(chain) == 0 {
+		return Noop{}, nil
+	}
+	return chain, nil
+}
+
+func envFloat(name string, fallback float64) float64 {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		log.Printf("invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
+
+func envInt(name string, fallback int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("invalid %s %q, using %v", name, value, fallback)
+		return fallback
+	}
+	return parsed
+}
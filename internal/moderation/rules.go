@@ -0,0 +1,248 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Rules - локальные правила без внешних сервисов: списки запрещенных
+// слов, ссылки, капс и повторы символов.
+//
+// Списки слов - файлы по одному слову на строку, # - комментарий. Слово
+// задает основу и ищется в любом месте слова текста: "дурак" совпадет с
+// "дураки" и "придурак", так ловятся формы с приставками. Строка с "="
+// в начале требует точного совпадения слова. Перед сравнением текст
+// приводится к нижнему регистру, ё заменяется на е, латиница и цифры,
+// похожие на кириллицу, - на кириллицу, повторы букв схлопываются, а
+// слова, разбитые на буквы ("д у р а к", "д.у.р.а.к"), склеиваются.
+type Rules struct {
+	banned     wordList // Отклонить
+	suspicious wordList // На проверку
+	maxLinks   int      // Больше ссылок - на проверку
+	maxRepeat  int      // Столько одинаковых символов подряд - на проверку
+	capsLength int      // С какой длины текста проверять капс
+}
+
+// NewRulesFromEnv читает MODERATION_BANNED_WORDS и
+// MODERATION_SUSPICIOUS_WORDS (пути к спискам), MODERATION_MAX_LINKS
+// (по умолчанию 2) и MODERATION_MAX_REPEAT (по умолчанию 10)
+func NewRulesFromEnv() (*Rules, error) {
+	banned, err := loadWordList(os.Getenv("MODERATION_BANNED_WORDS"))
+	if err != nil {
+		return nil, err
+	}
+	suspicious, err := loadWordList(os.Getenv("MODERATION_SUSPICIOUS_WORDS"))
+	if err != nil {
+		return nil, err
+	}
+	return &Rules{
+		banned:     banned,
+		suspicious: suspicious,
+		maxLinks:   envInt("MODERATION_MAX_LINKS", 2),
+		maxRepeat:  envInt("MODERATION_MAX_REPEAT", 10),
+		capsLength: 20,
+	}, nil
+}
+
+func (r *Rules) Name() string { return "rules" }
+
+func (r *Rules) Moderate(ctx context.Context, text string) (*Verdict, error) {
+	verdict := &Verdict{Decision: Approve, Details: map[string]float64{}, Backend: r.Name()}
+	flag := func(decision, rule, reason string) {
+		verdict.Details["rule:"+rule] = 1
+		verdict.Reasons = append(verdict.Reasons, reason)
+		if severity[decision] > severity[verdict.Decision] {
+			verdict.Decision = decision
+		}
+	}
+
+	words := normalizeWords(text)
+	if word, ok := r.banned.match(words); ok {
+		flag(Reject, "banned_word", fmt.Sprintf("запрещенное слово %q", word))
+	}
+	if word, ok := r.suspicious.match(words); ok {
+		flag(Review, "suspicious_word", fmt.Sprintf("подозрительное слово %q", word))
+	}
+	if links := countLinks(text); r.maxLinks >= 0 && links > r.maxLinks {
+		flag(Review, "links", fmt.Sprintf("слишком много ссылок (%d)", links))
+	}
+	if r.maxRepeat > 1 && longestRun(text) >= r.maxRepeat {
+		flag(Review, "repeated_chars", "повторяющиеся символы")
+	}
+	if r.capsLength > 0 && isShouting(text, r.capsLength) {
+		flag(Review, "caps", "текст написан капсом")
+	}
+
+	switch verdict.Decision {
+	case Reject:
+		verdict.Score = 1
+	case Review:
+		verdict.Score = 0.5
+	}
+	return verdict, nil
+}
+
+// linkPattern ищет ссылки. \b в Go понимает только ASCII, поэтому границы
+// слова заданы явно, иначе домены .рф не находятся.
+var linkPattern = regexp.MustCompile(`(?i)(https?://|www\.)\S+|(?:^|[^\p{L}\d])[\p{L}\d-]+\.(?:ru|com|net|org|io|me|su|xyz|top|info|рф)`)
+
+// countLinks считает ссылки в тексте. Конец домена проверяется отдельно:
+// граница в самом выражении съела бы разделитель перед следующей ссылкой.
+func countLinks(text string) int {
+	count := 0
+	for _, match := range linkPattern.FindAllStringIndex(text, -1) {
+		next, _ := utf8.DecodeRuneInString(text[match[1]:])
+		if !unicode.IsLetter(next) && !unicode.IsDigit(next) {
+			count++
+		}
+	}
+	return count
+}
+
+// wordList - основы слов и слова для точного совпадения
+type wordList struct {
+	stems []string
+	exact map[string]bool
+}
+
+func loadWordList(path string) (wordList, error) {
+	list := wordList{exact: map[string]bool{}}
+	if path == "" {
+		return list, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return list, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if exact, ok := strings.CutPrefix(line, "="); ok {
+			list.exact[normalizeWord(exact)] = true
+			continue
+		}
+		list.stems = append(list.stems, normalizeWord(line))
+	}
+	return list, scanner.Err()
+}
+
+// match возвращает первое слово текста из списка
+func (l wordList) match(words []string) (string, bool) {
+	for _, word := range words {
+		if l.exact[word] {
+			return word, true
+		}
+		for _, stem := range l.stems {
+			if stem != "" && strings.Contains(word, stem) {
+				return word, true
+			}
+		}
+	}
+	return "", false
+}
+
+// lookalikes - латиница и цифры, которыми подменяют кириллицу
+var lookalikes = map[rune]rune{
+	'a': 'а', 'b': 'в', 'c': 'с', 'e': 'е', 'h': 'н', 'k': 'к', 'm': 'м',
+	'o': 'о', 'p': 'р', 't': 'т', 'x': 'х', 'y': 'у', 'u': 'и',
+	'0': 'о', '3': 'з', '4': 'ч', '6': 'б', '@': 'а', 'ё': 'е',
+}
+
+// normalizeWord приводит слово к виду для сравнения со списками.
+// Латиница заменяется, только если в слове есть кириллица, чтобы не
+// портить английские слова.
+func normalizeWord(word string) string {
+	word = strings.ToLower(word)
+	cyrillic := false
+	for _, r := range word {
+		if unicode.Is(unicode.Cyrillic, r) {
+			cyrillic = true
+			break
+		}
+	}
+
+	var b strings.Builder
+	var prev rune
+	for _, r := range word {
+		if cyrillic || r == 'ё' {
+			if replacement, ok := lookalikes[r]; ok {
+				r = replacement
+			}
+		}
+		if r == prev {
+			continue // "дуууурак" -> "дурак"
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+// normalizeWords разбивает текст на нормализованные слова. Подряд идущие
+// одиночные буквы склеиваются в отдельное слово.
+func normalizeWords(text string) []string {
+	fields := strings.FieldsFunc(text, func(r rune) bool {
+		_, lookalike := lookalikes[r]
+		return !unicode.IsLetter(r) && !lookalike
+	})
+
+	words := make([]string, 0, len(fields)+1)
+	var letters strings.Builder
+	flush := func() {
+		if letters.Len() > 0 {
+			words = append(words, normalizeWord(letters.String()))
+			letters.Reset()
+		}
+	}
+	for _, field := range fields {
+		if len([]rune(field)) == 1 {
+			letters.WriteString(field)
+			continue
+		}
+		flush()
+		words = append(words, normalizeWord(field))
+	}
+	flush()
+	return words
+}
+
+// longestRun - самая длинная серия одинаковых символов, кроме пробелов
+func longestRun(text string) int {
+	longest, run := 0, 0
+	var prev rune
+	for _, r := range text {
+		if r == prev && !unicode.IsSpace(r) {
+			run++
+		} else {
+			run = 1
+		}
+		prev = r
+		longest = max(longest, run)
+	}
+	return longest
+}
+
+// isShouting - в тексте не короче minLength букв почти все заглавные
+func isShouting(text string, minLength int) bool {
+	letters, upper := 0, 0
+	for _, r := range text {
+		if unicode.IsLetter(r) {
+			letters++
+			if unicode.IsUpper(r) {
+				upper++
+			}
+		}
+	}
+	return letters >= minLength && upper*10 >= letters*9
+}
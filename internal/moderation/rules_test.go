@@ -0,0 +1,163 @@
+package moderation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeWordList(t *testing.T, lines string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte(lines), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCountLinks(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"без ссылок", 0},
+		{"https://example.com/path и www.example.org", 2},
+		{"заходи на example.ru", 1},
+		{"заходи на пример.рф", 1},
+		{"пример.рф,сайт.рф", 2},
+		{"a.ru b.ru c.com", 3},
+		{"(сайт.рф)", 1},
+		{"example.company и файл.russian", 0},
+		{"v1.info", 1},
+	}
+	for _, tt := range tests {
+		if got := countLinks(tt.text); got != tt.want {
+			t.Errorf("countLinks(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeWords(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Ёлка", []string{"елка"}},
+		{"дуууурак", []string{"дурак"}},
+		{"д у р а к", []string{"дурак"}},
+		{"д.у.р.а.к!", []string{"дурак"}},
+		{"дyp@к", []string{"дурак"}},
+		{"hello world", []string{"helo", "world"}},
+	}
+	for _, tt := range tests {
+		got := normalizeWords(tt.text)
+		if len(got) != len(tt.want) {
+			t.Errorf("normalizeWords(%q) = %q, want %q", tt.text, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("normalizeWords(%q) = %q, want %q", tt.text, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestRulesModerate(t *testing.T) {
+	t.Setenv("MODERATION_BANNED_WORDS", writeWordList(t, "# основы\nхуй\nебал\n=бля\n"))
+	t.Setenv("MODERATION_SUSPICIOUS_WORDS", writeWordList(t, "дурак\n"))
+	t.Setenv("MODERATION_MAX_LINKS", "1")
+	t.Setenv("MODERATION_MAX_REPEAT", "5")
+	rules, err := NewRulesFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		text string
+		want string
+		rule string
+	}{
+		{"clean", "отличная серия", Approve, ""},
+		{"stem", "хуйня какая-то", Reject, "banned_word"},
+		{"prefixed", "да мне похуй", Reject, "banned_word"},
+		{"prefixed verb", "заебал уже", Reject, "banned_word"},
+		{"exact", "бля", Reject, "banned_word"},
+		{"exact only", "блямба", Approve, ""},
+		{"spaced", "х у й", Reject, "banned_word"},
+		{"suspicious prefixed", "ну ты придурак", Review, "suspicious_word"},
+		{"links", "смотри example.ru и пример.рф", Review, "links"},
+		{"one link", "смотри пример.рф", Approve, ""},
+		{"repeat", "нууууу", Review, "repeated_chars"},
+		{"caps", "ЭТО ЛУЧШАЯ СЕРИЯ ЗА ВЕСЬ СЕЗОН", Review, "caps"},
+		{"reject wins", "ДУРАК ЗАЕБАЛ СО СВОИМИ СПОЙЛЕРАМИ", Reject, "banned_word"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verdict, err := rules.Moderate(context.Background(), tt.text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if verdict.Decision != tt.want {
+				t.Fatalf("decision = %q (%v), want %q", verdict.Decision, verdict.Reasons, tt.want)
+			}
+			if tt.rule != "" && verdict.Details["rule:"+tt.rule] != 1 {
+				t.Errorf("rule %q not flagged: %v", tt.rule, verdict.Details)
+			}
+		})
+	}
+}
+
+func TestNewFromEnv(t *testing.T) {
+	tests := []struct {
+		name     string
+		backends string
+		url      string
+		words    string
+		want     []string
+		wantErr  bool
+	}{
+		{name: "default without service", want: []string{"noop"}},
+		{name: "default with service", url: "http://moderation.local", want: []string{"toxicity"}},
+		{name: "rules explicitly", backends: "rules,toxicity", want: []string{"rules"}},
+		{name: "noop", backends: "noop", url: "http://moderation.local", want: []string{"noop"}},
+		{name: "missing word list", backends: "rules", words: "/nonexistent/words.txt", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MODERATION_BACKENDS", tt.backends)
+			t.Setenv("MODERATION_SERVICE_URL", tt.url)
+			t.Setenv("MODERATION_BANNED_WORDS", tt.words)
+			t.Setenv("MODERATION_SUSPICIOUS_WORDS", "")
+			moderator, err := NewFromEnv()
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			if chain, ok := moderator.(Chain); ok {
+				for _, m := range chain {
+					names = append(names, m.Name())
+				}
+			} else {
+				names = append(names, moderator.Name())
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("backends = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Fatalf("backends = %v, want %v", names, tt.want)
+				}
+			}
+		})
+	}
+}
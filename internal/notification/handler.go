@@ -0,0 +1,121 @@
+package notification
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+type Handler struct {
+	service Service
+}
+
+func NewHandler(service Service) *Handler {
+	return &Handler{service: service}
+}
+
+// List - GET /notifications?unread=true, новые сверху
+func (h *Handler) List(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	limit, offset := 50, 0
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(c.QueryParam("offset")); err == nil && o > 0 {
+		offset = o
+	}
+	unreadOnly, _ := strconv.ParseBool(c.QueryParam("unread"))
+
+	page, err := h.service.List(c.Request().Context(), userID, unreadOnly, limit, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+// UnreadCount - GET /notifications/unread-count
+func (h *Handler) UnreadCount(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	count, err := h.service.UnreadCount(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, echo.Map{"unread_count": count})
+}
+
+// MarkRead - POST /notifications/read, без ids - прочитать все
+func (h *Handler) MarkRead(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		IDs []uuid.UUID `json:"ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	marked, err := h.service.MarkRead(c.Request().Context(), userID, req.IDs)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, echo.Map{"marked": marked})
+}
+
+// GetPreferences - GET /notifications/preferences
+func (h *Handler) GetPreferences(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	prefs, err := h.service.GetPreferences(c.Request().Context(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+// SetPreferences - PUT /notifications/preferences, {"reply": false, ...}
+func (h *Handler) SetPreferences(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req map[string]bool
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	prefs, err := h.service.SetPreferences(c.Request().Context(), userID, req)
+	if err != nil {
+		if errors.Is(err, ErrUnknownType) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	return c.JSON(http.StatusOK, prefs)
+}
+
+func currentUserID(c echo.Context) (uuid.UUID, error) {
+	current, ok := auth.GetUser(c)
+	if !ok {
+		return uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return current.ID, nil
+}
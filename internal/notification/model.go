@@ -0,0 +1,53 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Типы уведомлений
+const (
+	TypeReply         = "reply"          // Ответ на комментарий
+	TypeMention       = "mention"        // @упоминание в комментарии
+	TypeVoteMilestone = "vote_milestone" // Рейтинг комментария достиг отметки
+	TypeModeration    = "moderation"     // Комментарий одобрен или отклонен
+)
+
+// Types - все типы, которые можно отключить в настройках
+var Types = []string{TypeReply, TypeMention, TypeVoteMilestone, TypeModeration}
+
+// Notification - уведомление пользователя. Data зависит от типа: оценка
+// для vote_milestone, статус и причина для moderation.
+type Notification struct {
+	ID        uuid.UUID              `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID              `gorm:"type:uuid;not null;index:idx_notifications_user_created,priority:1;uniqueIndex:idx_notifications_dedup,priority:1" json:"-"`
+	Type      string                 `gorm:"not null" json:"type"`
+	ActorID   *uuid.UUID             `gorm:"type:uuid" json:"actor_id,omitempty"` // Кто ответил или упомянул
+	CommentID *uuid.UUID             `gorm:"type:uuid;index" json:"comment_id,omitempty"`
+	AnimeID   string                 `json:"anime_id,omitempty"`
+	Excerpt   string                 `json:"excerpt,omitempty"` // Начало текста комментария
+	Data      map[string]interface{} `gorm:"serializer:json;type:jsonb" json:"data,omitempty"`
+	// Повторное уведомление с тем же ключом не создается: ответ,
+	// одобренный модератором после правки, не придет дважды
+	DedupKey  *string    `gorm:"uniqueIndex:idx_notifications_dedup,priority:2" json:"-"`
+	ReadAt    *time.Time `json:"read_at,omitempty"`
+	CreatedAt time.Time  `gorm:"index:idx_notifications_user_created,priority:2" json:"created_at"`
+}
+
+// Preference - включен ли тип уведомлений. Нет записи - включен.
+type Preference struct {
+	UserID  uuid.UUID `gorm:"type:uuid;primaryKey"`
+	Type    string    `gorm:"primaryKey"`
+	Enabled bool      `gorm:"not null"`
+}
+
+func (Preference) TableName() string {
+	return "notification_preferences"
+}
+
+// Page - страница уведомлений и общее число непрочитанных
+type Page struct {
+	Notifications []Notification `json:"notifications"`
+	UnreadCount   int64          `json:"unread_count"`
+}
@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Repository interface {
+	Create(n *Notification) error
+	List(userID uuid.UUID, unreadOnly bool, limit, offset int) ([]Notification, error)
+	CountUnread(userID uuid.UUID) (int64, error)
+	MarkRead(userID uuid.UUID, ids []uuid.UUID) (int64, error)
+	IsEnabled(userID uuid.UUID, notificationType string) (bool, error)
+	GetPreferences(userID uuid.UUID) ([]Preference, error)
+	SavePreferences(prefs []Preference) error
+	DeleteByComments(commentIDs []uuid.UUID) (int64, error)
+}
+
+type repository struct {
+	db *gorm.DB
+}
+
+func NewRepository(db *gorm.DB) Repository {
+	return &repository{db: db}
+}
+
+// Create сохраняет уведомление. Повтор по DedupKey молча пропускается.
+func (r *repository) Create(n *Notification) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(n).Error
+}
+
+// List возвращает уведомления пользователя, новые сверху
+func (r *repository) List(userID uuid.UUID, unreadOnly bool, limit, offset int) ([]Notification, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	notifications := []Notification{}
+	err := query.Order("created_at DESC, id DESC").Limit(limit).Offset(offset).Find(&notifications).Error
+	return notifications, err
+}
+
+func (r *repository) CountUnread(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// MarkRead отмечает прочитанными уведомления ids, при пустом ids - все
+func (r *repository) MarkRead(userID uuid.UUID, ids []uuid.UUID) (int64, error) {
+	query := r.db.Model(&Notification{}).Where("user_id = ? AND read_at IS NULL", userID)
+	if len(ids) > 0 {
+		query = query.Where("id IN ?", ids)
+	}
+	result := query.Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func (r *repository) IsEnabled(userID uuid.UUID, notificationType string) (bool, error) {
+	var muted int64
+	err := r.db.Model(&Preference{}).
+		Where("user_id = ? AND type = ? AND NOT enabled", userID, notificationType).
+		Count(&muted).Error
+	return muted == 0, err
+}
+
+func (r *repository) GetPreferences(userID uuid.UUID) ([]Preference, error) {
+	var prefs []Preference
+	err := r.db.Where("user_id = ?", userID).Find(&prefs).Error
+	return prefs, err
+}
+
+// DeleteByComments удаляет уведомления о комментариях commentIDs
+func (r *repository) DeleteByComments(commentIDs []uuid.UUID) (int64, error) {
+	if len(commentIDs) == 0 {
+		return 0, nil
+	}
+	result := r.db.Where("comment_id IN ?", commentIDs).Delete(&Notification{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *repository) SavePreferences(prefs []Preference) error {
+	if len(prefs) == 0 {
+		return nil
+	}
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "type"}},
+		DoUpdates: clause.AssignmentColumns([]string{"enabled"}),
+	}).Create(&prefs).Error
+}
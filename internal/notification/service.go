@@ -0,0 +1,103 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"slices"
+
+	"github.com/google/uuid"
+)
+
+var ErrUnknownType = errors.New("unknown notification type")
+
+type Service interface {
+	Notify(ctx context.Context, n *Notification) error
+	List(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit, offset int) (*Page, error)
+	UnreadCount(ctx context.Context, userID uuid.UUID) (int64, error)
+	MarkRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (int64, error)
+	GetPreferences(ctx context.Context, userID uuid.UUID) (map[string]bool, error)
+	SetPreferences(ctx context.Context, userID uuid.UUID, prefs map[string]bool) (map[string]bool, error)
+	ForgetComments(ctx context.Context, commentIDs []uuid.UUID) error
+}
+
+type service struct {
+	repo Repository
+}
+
+func NewService(repo Repository) Service {
+	return &service{repo: repo}
+}
+
+// Notify сохраняет уведомление, если получатель не отключил этот тип.
+// О своих действиях пользователь уведомлений не получает.
+func (s *service) Notify(ctx context.Context, n *Notification) error {
+	if n.ActorID != nil && *n.ActorID == n.UserID {
+		return nil
+	}
+	enabled, err := s.repo.IsEnabled(n.UserID, n.Type)
+	if err != nil || !enabled {
+		return err
+	}
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return s.repo.Create(n)
+}
+
+func (s *service) List(ctx context.Context, userID uuid.UUID, unreadOnly bool, limit, offset int) (*Page, error) {
+	notifications, err := s.repo.List(userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	unread, err := s.repo.CountUnread(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Page{Notifications: notifications, UnreadCount: unread}, nil
+}
+
+func (s *service) UnreadCount(ctx context.Context, userID uuid.UUID) (int64, error) {
+	return s.repo.CountUnread(userID)
+}
+
+func (s *service) MarkRead(ctx context.Context, userID uuid.UUID, ids []uuid.UUID) (int64, error) {
+	return s.repo.MarkRead(userID, ids)
+}
+
+// ForgetComments удаляет уведомления о комментариях, которые удалены или
+// скрыты: в них остался бы отрывок текста
+func (s *service) ForgetComments(ctx context.Context, commentIDs []uuid.UUID) error {
+	_, err := s.repo.DeleteByComments(commentIDs)
+	return err
+}
+
+// GetPreferences возвращает все типы уведомлений: true - включен
+func (s *service) GetPreferences(ctx context.Context, userID uuid.UUID) (map[string]bool, error) {
+	saved, err := s.repo.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	prefs := make(map[string]bool, len(Types))
+	for _, t := range Types {
+		prefs[t] = true
+	}
+	for _, pref := range saved {
+		prefs[pref.Type] = pref.Enabled
+	}
+	return prefs, nil
+}
+
+// SetPreferences меняет переданные типы, остальные не трогает
+func (s *service) SetPreferences(ctx context.Context, userID uuid.UUID, prefs map[string]bool) (map[string]bool, error) {
+	rows := make([]Preference, 0, len(prefs))
+	for t, enabled := range prefs {
+		if !slices.Contains(Types, t) {
+			return nil, ErrUnknownType
+		}
+		rows = append(rows, Preference{UserID: userID, Type: t, Enabled: enabled})
+	}
+	if err := s.repo.SavePreferences(rows); err != nil {
+		return nil, err
+	}
+	return s.GetPreferences(ctx, userID)
+}
@@ -0,0 +1,93 @@
+package notification
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// fakeRepo хранит уведомления и отключенные типы в памяти
+type fakeRepo struct {
+	Repository
+	created []*Notification
+	muted   map[string]bool
+	saved   []Preference
+}
+
+func (r *fakeRepo) Create(n *Notification) error {
+	r.created = append(r.created, n)
+	return nil
+}
+
+func (r *fakeRepo) IsEnabled(userID uuid.UUID, notificationType string) (bool, error) {
+	return !r.muted[notificationType], nil
+}
+
+func (r *fakeRepo) GetPreferences(userID uuid.UUID) ([]Preference, error) {
+	var prefs []Preference
+	for t, muted := range r.muted {
+		prefs = append(prefs, Preference{UserID: userID, Type: t, Enabled: !muted})
+	}
+	return prefs, nil
+}
+
+func (r *fakeRepo) SavePreferences(prefs []Preference) error {
+	r.saved = append(r.saved, prefs...)
+	for _, pref := range prefs {
+		r.muted[pref.Type] = !pref.Enabled
+	}
+	return nil
+}
+
+func TestNotify(t *testing.T) {
+	recipient := uuid.New()
+	other := uuid.New()
+	tests := []struct {
+		name    string
+		n       Notification
+		muted   string
+		created bool
+	}{
+		{"reply", Notification{UserID: recipient, Type: TypeReply, ActorID: &other}, "", true},
+		{"own action", Notification{UserID: recipient, Type: TypeReply, ActorID: &recipient}, "", false},
+		{"muted type", Notification{UserID: recipient, Type: TypeMention, ActorID: &other}, TypeMention, false},
+		{"other type muted", Notification{UserID: recipient, Type: TypeModeration}, TypeMention, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &fakeRepo{muted: map[string]bool{tt.muted: tt.muted != ""}}
+			n := tt.n
+			if err := NewService(repo).Notify(context.Background(), &n); err != nil {
+				t.Fatal(err)
+			}
+			if got := len(repo.created) == 1; got != tt.created {
+				t.Fatalf("created = %v, want %v", got, tt.created)
+			}
+			if tt.created && repo.created[0].ID == uuid.Nil {
+				t.Error("notification saved without an ID")
+			}
+		})
+	}
+}
+
+func TestPreferences(t *testing.T) {
+	repo := &fakeRepo{muted: map[string]bool{}}
+	s := NewService(repo)
+	userID := uuid.New()
+
+	prefs, err := s.SetPreferences(context.Background(), userID, map[string]bool{TypeVoteMilestone: false})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, typ := range Types {
+		if want := typ != TypeVoteMilestone; prefs[typ] != want {
+			t.Errorf("%s enabled = %v, want %v", typ, prefs[typ], want)
+		}
+	}
+
+	if _, err := s.SetPreferences(context.Background(), userID, map[string]bool{"unknown": true}); !errors.Is(err, ErrUnknownType) {
+		t.Errorf("unknown type: err = %v, want ErrUnknownType", err)
+	}
+}
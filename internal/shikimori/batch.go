@@ -0,0 +1,100 @@
+package shikimori
+
+import (
+	"context"
+	"log"
+	"sync"
+)
+
+const (
+	// Shikimori отдает не больше 50 аниме на страницу
+	maxIDsPerRequest = 50
+	// Сколько запросов к Shikimori выполняется одновременно
+	batchWorkers = 4
+)
+
+// FetchAnimesByIDs загружает аниме пачками через GetAnimesByIDs, сохраняя
+// порядок ids. Вторым значением возвращаются ID, которые не удалось получить.
+func (s *Service) FetchAnimesByIDs(ctx context.Context, ids []string) ([]Anime, []string) {
+	unique := make([]string, 0, len(ids))
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+
+	var chunks [][]string
+	for start := 0; start < len(unique); start += maxIDsPerRequest {
+		end := min(start+maxIDsPerRequest, len(unique))
+		chunks = append(chunks, unique[start:end])
+	}
+
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		found = make(map[string]Anime, len(unique))
+		sem   = make(chan struct{}, batchWorkers)
+	)
+	for _, chunk := range chunks {
+		wg.Add(1)
+		go func(chunk []string) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			defer func() { <-sem }()
+
+			animes, err := s.GetAnimesByIDs(ctx, chunk)
+			if err != nil {
+				log.Printf("Ошибка пакетного запроса аниме (%d шт.): %v", len(chunk), err)
+				return
+			}
+
+			mu.Lock()
+			for _, anime := range animes {
+				found[anime.ID] = anime
+			}
+			mu.Unlock()
+		}(chunk)
+	}
+	wg.Wait()
+
+	result := make([]Anime, 0, len(ids))
+	var failed []string
+	for _, id := range ids {
+		anime, ok := found[id]
+		if !ok {
+			failed = append(failed, id)
+			continue
+		}
+		result = append(result, anime)
+	}
+	return result, failed
+}
+
+// GetAnimesByMalIDs ищет аниме по ID MyAnimeList (до 50 за запрос) и
+// возвращает их по MAL ID. Shikimori берет ID аниме у MyAnimeList, поэтому
+// кандидаты запрашиваются по тем же ID, но засчитываются только совпавшие
+// по malId: у аниме, которых нет на MyAnimeList, собственные ID.
+func (s *Service) GetAnimesByMalIDs(ctx context.Context, malIDs []string) (map[string]Anime, error) {
+	animes, err := s.GetAnimesByIDs(ctx, malIDs)
+	if err != nil {
+		return nil, err
+	}
+	wanted := make(map[string]bool, len(malIDs))
+	for _, id := range malIDs {
+		wanted[id] = true
+	}
+	found := make(map[string]Anime, len(animes))
+	for _, anime := range animes {
+		if anime.MalID != "" && wanted[anime.MalID] {
+			found[anime.MalID] = anime
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,51 @@
+package shikimori
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori/shikimoritest"
+)
+
+func newTestService(t *testing.T, animes ...shikimoritest.Anime) *Service {
+	t.Helper()
+	provider := shikimoritest.NewProvider(time.Hour)
+	provider.AddAnime(animes...)
+	server := httptest.NewServer(provider)
+	t.Cleanup(server.Close)
+
+	t.Setenv("SHIKIMORI_GRAPHQL_URL", server.URL+"/api/graphql")
+	return NewService()
+}
+
+func TestGetAnimesByIDsRequestsKind(t *testing.T) {
+	s := newTestService(t, shikimoritest.Anime{ID: "1", MalID: "1", Name: "Cowboy Bebop", Kind: "tv", Episodes: 26})
+
+	animes, err := s.GetAnimesByIDs(context.Background(), []string{"1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(animes) != 1 || animes[0].Kind != "tv" || animes[0].Episodes != 26 {
+		t.Errorf("animes = %+v, want kind tv and 26 episodes", animes)
+	}
+}
+
+func TestGetAnimesByMalIDs(t *testing.T) {
+	s := newTestService(t,
+		shikimoritest.Anime{ID: "1", MalID: "1", Name: "Cowboy Bebop"},
+		// ID совпал с запрошенным MAL ID, но это другое аниме
+		shikimoritest.Anime{ID: "5", MalID: "50", Name: "Other"},
+		// Аниме, которого нет на MyAnimeList
+		shikimoritest.Anime{ID: "7", Name: "Shikimori only"},
+	)
+
+	found, err := s.GetAnimesByMalIDs(context.Background(), []string{"1", "5", "7", "404"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 1 || found["1"].ID != "1" {
+		t.Errorf("found = %+v, want only MAL ID 1", found)
+	}
+}
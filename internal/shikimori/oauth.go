@@ -0,0 +1,238 @@
+package shikimori
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultOAuthURL = "https://shikimori.one"
+
+// OAuthToken - ответ /oauth/token
+type OAuthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+	Scope        string `json:"scope"`
+}
+
+// ExpiresAt - момент истечения access-токена
+func (t *OAuthToken) ExpiresAt() time.Time {
+	created := time.Unix(t.CreatedAt, 0)
+	if t.CreatedAt == 0 {
+		created = time.Now()
+	}
+	return created.Add(time.Duration(t.ExpiresIn) * time.Second)
+}
+
+// OAuthUser - ответ /api/users/whoami
+type OAuthUser struct {
+	ID       int64  `json:"id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+
+// OAuthClient реализует authorization code flow Shikimori.
+// Базовый адрес настраивается, чтобы работать с локальным фейковым
+// провайдером (cmd/fakeshikimori).
+type OAuthClient struct {
+	baseURL      string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	userAgent    string
+	httpClient   *http.Client
+
+	// Shikimori ограничивает частоту запросов (5 в секунду, 90 в минуту)
+	mu          sync.Mutex
+	minInterval time.Duration
+	lastRequest time.Time
+}
+
+// NewOAuthClientFromEnv читает SHIKIMORI_OAUTH_URL, SHIKIMORI_CLIENT_ID,
+// SHIKIMORI_CLIENT_SECRET, SHIKIMORI_REDIRECT_URL и SHIKIMORI_APP_NAME.
+// SHIKIMORI_API_INTERVAL задает паузу между запросами (для фейкового провайдера).
+func NewOAuthClientFromEnv() *OAuthClient {
+	baseURL := os.Getenv("SHIKIMORI_OAUTH_URL")
+	if baseURL == "" {
+		baseURL = defaultOAuthURL
+	}
+	userAgent := os.Getenv("SHIKIMORI_APP_NAME")
+	if userAgent == "" {
+		userAgent = "shiki_api_test"
+	}
+	minInterval := 700 * time.Millisecond
+	if d, err := time.ParseDuration(os.Getenv("SHIKIMORI_API_INTERVAL")); err == nil && d >= 0 {
+		minInterval = d
+	}
+	return &OAuthClient{
+		baseURL:      strings.TrimRight(baseURL, "/"),
+		clientID:     os.Getenv("SHIKIMORI_CLIENT_ID"),
+		clientSecret: os.Getenv("SHIKIMORI_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("SHIKIMORI_REDIRECT_URL"),
+		userAgent:    userAgent,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+		minInterval:  minInterval,
+	}
+}
+
+func (c *OAuthClient) Configured() bool {
+	return c.clientID != "" && c.clientSecret != "" && c.redirectURL != ""
+}
+
+// AuthorizeURL - адрес, на который нужно отправить пользователя
+func (c *OAuthClient) AuthorizeURL(state string) string {
+	query := url.Values{}
+	query.Set("client_id", c.clientID)
+	query.Set("redirect_uri", c.redirectURL)
+	query.Set("response_type", "code")
+	query.Set("scope", "user_rates")
+	query.Set("state", state)
+	return c.baseURL + "/oauth/authorize?" + query.Encode()
+}
+
+// Exchange обменивает код авторизации на токены
+func (c *OAuthClient) Exchange(ctx context.Context, code string) (*OAuthToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", c.redirectURL)
+	return c.requestToken(ctx, form)
+}
+
+// RefreshToken обновляет истекший access-токен
+func (c *OAuthClient) RefreshToken(ctx context.Context, refreshToken string) (*OAuthToken, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	return c.requestToken(ctx, form)
+}
+
+// WhoAmI возвращает пользователя Shikimori, которому выдан токен
+func (c *OAuthClient) WhoAmI(ctx context.Context, accessToken string) (*OAuthUser, error) {
+	var user OAuthUser
+	if err := c.DoAPI(ctx, accessToken, http.MethodGet, "/api/users/whoami", nil, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, errors.New("shikimori: empty whoami response")
+	}
+	return &user, nil
+}
+
+// DoAPI выполняет запрос к REST API Shikimori от имени пользователя
+func (c *OAuthClient) DoAPI(ctx context.Context, accessToken, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = strings.NewReader(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if err := c.wait(ctx); err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("User-Agent", c.userAgent)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("shikimori %s %s failed: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return &APIError{StatusCode: resp.StatusCode, Body: string(snippet)}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode shikimori response: %w", err)
+	}
+	return nil
+}
+
+// APIError - ответ Shikimori с кодом не 2xx
+type APIError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("shikimori responded with %d: %s", e.StatusCode, e.Body)
+}
+
+// wait выдерживает паузу между запросами к API
+func (c *OAuthClient) wait(ctx context.Context) error {
+	c.mu.Lock()
+	next := c.lastRequest.Add(c.minInterval)
+	now := time.Now()
+	if next.Before(now) {
+		next = now
+	}
+	c.lastRequest = next
+	c.mu.Unlock()
+
+	timer := time.NewTimer(time.Until(next))
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (c *OAuthClient) requestToken(ctx context.Context, form url.Values) (*OAuthToken, error) {
+	form.Set("client_id", c.clientID)
+	form.Set("client_secret", c.clientSecret)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/oauth/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.userAgent)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("shikimori token request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &APIError{StatusCode: resp.StatusCode, Body: string(snippet)}
+	}
+
+	var token OAuthToken
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return nil, fmt.Errorf("failed to decode shikimori token: %w", err)
+	}
+	if token.AccessToken == "" {
+		return nil, errors.New("shikimori: empty access token")
+	}
+	return &token, nil
+}
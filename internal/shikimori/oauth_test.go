@@ -0,0 +1,102 @@
+package shikimori
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori/shikimoritest"
+)
+
+func newTestOAuthClient(t *testing.T) *OAuthClient {
+	t.Helper()
+	server := httptest.NewServer(shikimoritest.NewProvider(time.Hour))
+	t.Cleanup(server.Close)
+
+	t.Setenv("SHIKIMORI_OAUTH_URL", server.URL)
+	t.Setenv("SHIKIMORI_CLIENT_ID", "client")
+	t.Setenv("SHIKIMORI_CLIENT_SECRET", "secret")
+	t.Setenv("SHIKIMORI_REDIRECT_URL", "http://localhost/callback")
+	t.Setenv("SHIKIMORI_API_INTERVAL", "0s")
+	return NewOAuthClientFromEnv()
+}
+
+func TestOAuthExchangeAndWhoAmI(t *testing.T) {
+	client := newTestOAuthClient(t)
+	ctx := context.Background()
+
+	code, state, err := shikimoritest.Authorize(client.AuthorizeURL("state-1"), 42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "state-1" {
+		t.Errorf("state = %q, want state-1", state)
+	}
+
+	token, err := client.Exchange(ctx, code)
+	if err != nil {
+		t.Fatalf("Exchange: %v", err)
+	}
+	if token.AccessToken == "" || token.RefreshToken == "" {
+		t.Fatalf("empty tokens: %+v", token)
+	}
+	if until := time.Until(token.ExpiresAt()); until < 59*time.Minute || until > time.Hour {
+		t.Errorf("token expires in %s, want about an hour", until)
+	}
+
+	user, err := client.WhoAmI(ctx, token.AccessToken)
+	if err != nil {
+		t.Fatalf("WhoAmI: %v", err)
+	}
+	if user.ID != 42 {
+		t.Errorf("whoami id = %d, want 42", user.ID)
+	}
+
+	// Код одноразовый
+	var apiErr *APIError
+	if _, err := client.Exchange(ctx, code); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest {
+		t.Errorf("second Exchange = %v, want 400 APIError", err)
+	}
+}
+
+func TestOAuthRefreshToken(t *testing.T) {
+	client := newTestOAuthClient(t)
+	ctx := context.Background()
+
+	code, _, err := shikimoritest.Authorize(client.AuthorizeURL("state"), 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := client.Exchange(ctx, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refreshed, err := client.RefreshToken(ctx, first.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken: %v", err)
+	}
+	if refreshed.AccessToken == first.AccessToken {
+		t.Error("refresh returned the same access token")
+	}
+	if user, err := client.WhoAmI(ctx, refreshed.AccessToken); err != nil || user.ID != 7 {
+		t.Errorf("WhoAmI with refreshed token = %+v, %v", user, err)
+	}
+
+	// Refresh-токен ротируется
+	if _, err := client.RefreshToken(ctx, first.RefreshToken); err == nil {
+		t.Error("reused refresh token was accepted")
+	}
+}
+
+func TestOAuthWhoAmIRejectsUnknownToken(t *testing.T) {
+	client := newTestOAuthClient(t)
+
+	var apiErr *APIError
+	if _, err := client.WhoAmI(context.Background(), "unknown"); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Errorf("WhoAmI = %v, want 401 APIError", err)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/machinebox/graphql"
 )
@@ -12,9 +13,17 @@ type Service struct {
 	graphqlClient *graphql.Client
 }
 
+const defaultGraphQLURL = "https://shikimori.one/api/graphql"
+
+// NewService создает клиент GraphQL API Shikimori. SHIKIMORI_GRAPHQL_URL
+// меняет адрес API (для тестов и локального провайдера).
 func NewService() *Service {
+	endpoint := os.Getenv("SHIKIMORI_GRAPHQL_URL")
+	if endpoint == "" {
+		endpoint = defaultGraphQLURL
+	}
 	// Инициализация клиента для запросов к API Shikimori
-	graphqlClient := graphql.NewClient("https://shikimori.one/api/graphql")
+	graphqlClient := graphql.NewClient(endpoint)
 
 	return &Service{
 		graphqlClient: graphqlClient,
@@ -140,21 +149,32 @@ func (s *Service) GetAnimeByID(ctx context.Context, id string) (*Anime, error) {
 
 	return &resp.Animes[0], nil
 }
+
+// GetAnimesByIDs загружает до 50 аниме одним запросом
 func (s *Service) GetAnimesByIDs(ctx context.Context, ids []string) ([]Anime, error) {
 	req := graphql.NewRequest(`
-        query($ids: [String!]!) {
-            animes(ids: $ids) {
+        query($ids: String, $limit: PositiveInt) {
+            animes(ids: $ids, limit: $limit) {
                 id
+                malId
                 name
                 russian
 				description
+                kind
+                episodes
                 score
                 status
+                poster {
+                    id
+                    originalUrl
+                    mainUrl
+                }
             }
         }
     `)
 
-	req.Var("ids", ids)
+	req.Var("ids", strings.Join(ids, ","))
+	req.Var("limit", len(ids))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Origin", "https://shikimori.one")
@@ -0,0 +1,431 @@
+// Пакет shikimoritest - фейковый OAuth-провайдер, повторяющий API Shikimori,
+// для тестов (httptest.NewServer) и локального запуска через cmd/fakeshikimori.
+//
+// /oauth/authorize сразу подтверждает доступ и перенаправляет на redirect_uri.
+// Параметры user_id и nickname задают пользователя, которому выдается код.
+// Списки пользователей (/api/v2/user_rates) хранятся в памяти.
+package shikimoritest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeUser struct {
+	ID       int64  `json:"id"`
+	Nickname string `json:"nickname"`
+	Avatar   string `json:"avatar"`
+}
+
+// Anime - запись каталога для /api/graphql
+type Anime struct {
+	ID       string  `json:"id"`
+	MalID    string  `json:"malId,omitempty"`
+	Name     string  `json:"name"`
+	Russian  string  `json:"russian,omitempty"`
+	Kind     string  `json:"kind,omitempty"`
+	Episodes int     `json:"episodes"`
+	Score    float64 `json:"score,omitempty"`
+	Status   string  `json:"status,omitempty"`
+}
+
+type userRate struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	TargetID   int64     `json:"target_id"`
+	TargetType string    `json:"target_type"`
+	Score      int       `json:"score"`
+	Status     string    `json:"status"`
+	Rewatches  int       `json:"rewatches"`
+	Episodes   int       `json:"episodes"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Provider - фейковый Shikimori: OAuth (authorization code и refresh_token),
+// /api/users/whoami, /api/v2/user_rates и каталог аниме для /api/graphql
+// в памяти.
+type Provider struct {
+	mu            sync.Mutex
+	codes         map[string]fakeUser
+	accessTokens  map[string]fakeUser
+	refreshTokens map[string]fakeUser
+	tokenTTL      time.Duration
+
+	rates      map[int64]*userRate
+	nextRateID int64
+
+	animes map[string]Anime
+
+	mux *http.ServeMux
+}
+
+// NewProvider создает провайдер, выдающий access-токены на tokenTTL
+func NewProvider(tokenTTL time.Duration) *Provider {
+	p := &Provider{
+		codes:         map[string]fakeUser{},
+		accessTokens:  map[string]fakeUser{},
+		refreshTokens: map[string]fakeUser{},
+		tokenTTL:      tokenTTL,
+		rates:         map[int64]*userRate{},
+		animes:        map[string]Anime{},
+		mux:           http.NewServeMux(),
+	}
+	p.mux.HandleFunc("/oauth/authorize", p.authorize)
+	p.mux.HandleFunc("/oauth/token", p.token)
+	p.mux.HandleFunc("/api/users/whoami", p.whoami)
+	p.mux.HandleFunc("GET /api/v2/user_rates", p.listRates)
+	p.mux.HandleFunc("POST /api/v2/user_rates", p.createRate)
+	p.mux.HandleFunc("GET /api/v2/user_rates/{id}", p.getRate)
+	p.mux.HandleFunc("PATCH /api/v2/user_rates/{id}", p.updateRate)
+	p.mux.HandleFunc("PUT /api/v2/user_rates/{id}", p.updateRate)
+	p.mux.HandleFunc("DELETE /api/v2/user_rates/{id}", p.deleteRate)
+	p.mux.HandleFunc("POST /api/graphql", p.graphql)
+	return p
+}
+
+// AddAnime добавляет аниме в каталог
+func (p *Provider) AddAnime(animes ...Anime) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, anime := range animes {
+		p.animes[anime.ID] = anime
+	}
+}
+
+func (p *Provider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	p.mux.ServeHTTP(w, r)
+}
+
+// Authorize проходит authURL, как браузер пользователя userID, и возвращает
+// code и state из перенаправления на redirect_uri
+func Authorize(authURL string, userID int64) (code, state string, err error) {
+	target, err := url.Parse(authURL)
+	if err != nil {
+		return "", "", err
+	}
+	query := target.Query()
+	query.Set("user_id", strconv.FormatInt(userID, 10))
+	target.RawQuery = query.Encode()
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(target.String())
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		return "", "", fmt.Errorf("authorize responded with %d", resp.StatusCode)
+	}
+
+	redirect, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return "", "", err
+	}
+	return redirect.Query().Get("code"), redirect.Query().Get("state"), nil
+}
+
+func (p *Provider) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil || redirect.Scheme == "" || query.Get("response_type") != "code" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	user := fakeUser{ID: 1, Nickname: "fake_user"}
+	if id, err := strconv.ParseInt(query.Get("user_id"), 10, 64); err == nil && id > 0 {
+		user.ID = id
+		user.Nickname = "fake_user_" + query.Get("user_id")
+	}
+	if nickname := query.Get("nickname"); nickname != "" {
+		user.Nickname = nickname
+	}
+
+	code := randomString()
+	p.mu.Lock()
+	p.codes[code] = user
+	p.mu.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (p *Provider) token(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("client_id") == "" || r.PostForm.Get("client_secret") == "" {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		user fakeUser
+		ok   bool
+	)
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		code := r.PostForm.Get("code")
+		user, ok = p.codes[code]
+		delete(p.codes, code)
+	case "refresh_token":
+		refresh := r.PostForm.Get("refresh_token")
+		user, ok = p.refreshTokens[refresh]
+		delete(p.refreshTokens, refresh)
+	}
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	access, refresh := randomString(), randomString()
+	p.accessTokens[access] = user
+	p.refreshTokens[refresh] = user
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  access,
+		"refresh_token": refresh,
+		"token_type":    "Bearer",
+		"expires_in":    int64(p.tokenTTL.Seconds()),
+		"created_at":    time.Now().Unix(),
+		"scope":         "user_rates",
+	})
+}
+
+func (p *Provider) whoami(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.userFromRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	writeJSON(w, http.StatusOK, user)
+}
+
+func (p *Provider) listRates(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	userID, _ := strconv.ParseInt(query.Get("user_id"), 10, 64)
+	targetID, _ := strconv.ParseInt(query.Get("target_id"), 10, 64)
+	page, limit := 1, 1000
+	if v, err := strconv.Atoi(query.Get("page")); err == nil && v > 0 {
+		page = v
+	}
+	if v, err := strconv.Atoi(query.Get("limit")); err == nil && v > 0 && v <= 1000 {
+		limit = v
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	matched := []*userRate{}
+	for _, rate := range p.rates {
+		if (userID == 0 || rate.UserID == userID) &&
+			(targetID == 0 || rate.TargetID == targetID) &&
+			(query.Get("target_type") == "" || rate.TargetType == query.Get("target_type")) {
+			matched = append(matched, rate)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool { return matched[i].ID < matched[j].ID })
+
+	start := (page - 1) * limit
+	if start > len(matched) {
+		start = len(matched)
+	}
+	end := start + limit
+	if end > len(matched) {
+		end = len(matched)
+	}
+	writeJSON(w, http.StatusOK, matched[start:end])
+}
+
+func (p *Provider) createRate(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.userFromRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	var req struct {
+		UserRate userRate `json:"user_rate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.UserRate.UserID != user.ID {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "invalid user_rate"})
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, rate := range p.rates {
+		if rate.UserID == user.ID && rate.TargetID == req.UserRate.TargetID && rate.TargetType == req.UserRate.TargetType {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "user_rate already exists"})
+			return
+		}
+	}
+
+	p.nextRateID++
+	rate := req.UserRate
+	rate.ID = p.nextRateID
+	rate.CreatedAt = time.Now()
+	rate.UpdatedAt = rate.CreatedAt
+	p.rates[rate.ID] = &rate
+	writeJSON(w, http.StatusCreated, rate)
+}
+
+func (p *Provider) getRate(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate, ok := p.rateFromPath(r)
+	if !ok {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, rate)
+}
+
+func (p *Provider) updateRate(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.userFromRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+	var req struct {
+		UserRate map[string]json.RawMessage `json:"user_rate"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": "invalid user_rate"})
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate, ok := p.rateFromPath(r)
+	if !ok || rate.UserID != user.ID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	for field, target := range map[string]interface{}{
+		"status":    &rate.Status,
+		"score":     &rate.Score,
+		"episodes":  &rate.Episodes,
+		"rewatches": &rate.Rewatches,
+		"text":      &rate.Text,
+	} {
+		if raw, ok := req.UserRate[field]; ok {
+			_ = json.Unmarshal(raw, target)
+		}
+	}
+	rate.UpdatedAt = time.Now()
+	writeJSON(w, http.StatusOK, rate)
+}
+
+func (p *Provider) deleteRate(w http.ResponseWriter, r *http.Request) {
+	user, ok := p.userFromRequest(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	rate, ok := p.rateFromPath(r)
+	if !ok || rate.UserID != user.ID {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "not found"})
+		return
+	}
+	delete(p.rates, rate.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// graphql отвечает только на запрос animes(ids: ...): аниме каталога с
+// переданными ID. Как и настоящий API, отдает только запрошенные поля;
+// остальные аргументы не учитываются.
+func (p *Provider) graphql(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Query     string `json:"query"`
+		Variables struct {
+			IDs string `json:"ids"`
+		} `json:"variables"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || !strings.Contains(req.Query, "animes(") {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"errors": []map[string]string{{"message": "unsupported query"}},
+		})
+		return
+	}
+
+	selected := map[string]bool{}
+	for _, field := range strings.FieldsFunc(req.Query, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) {
+		selected[field] = true
+	}
+
+	p.mu.Lock()
+	animes := []map[string]interface{}{}
+	for _, id := range strings.Split(req.Variables.IDs, ",") {
+		anime, ok := p.animes[strings.TrimSpace(id)]
+		if !ok {
+			continue
+		}
+		var fields map[string]interface{}
+		data, _ := json.Marshal(anime)
+		_ = json.Unmarshal(data, &fields)
+		for name := range fields {
+			if !selected[name] {
+				delete(fields, name)
+			}
+		}
+		animes = append(animes, fields)
+	}
+	p.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": map[string]interface{}{"animes": animes}})
+}
+
+// rateFromPath вызывается под p.mu
+func (p *Provider) rateFromPath(r *http.Request) (*userRate, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		return nil, false
+	}
+	rate, ok := p.rates[id]
+	return rate, ok
+}
+
+func (p *Provider) userFromRequest(r *http.Request) (fakeUser, bool) {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	user, ok := p.accessTokens[token]
+	return user, ok
+}
+
+func randomString() string {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(raw)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
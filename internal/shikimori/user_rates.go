@@ -0,0 +1,133 @@
+package shikimori
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const userRatesPageSize = 1000
+
+// UserRate - запись списка пользователя Shikimori (/api/v2/user_rates)
+type UserRate struct {
+	ID         int64     `json:"id"`
+	UserID     int64     `json:"user_id"`
+	TargetID   int64     `json:"target_id"`
+	TargetType string    `json:"target_type"`
+	Score      int       `json:"score"` // 0 - без оценки
+	Status     string    `json:"status"`
+	Rewatches  int       `json:"rewatches"`
+	Episodes   int       `json:"episodes"`
+	Text       string    `json:"text"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// UserRateUpdate - изменяемые поля записи
+type UserRateUpdate struct {
+	Status   string `json:"status"`
+	Score    int    `json:"score"`
+	Episodes int    `json:"episodes"`
+}
+
+// ListUserRates возвращает весь список аниме пользователя Shikimori
+func (c *OAuthClient) ListUserRates(ctx context.Context, accessToken string, shikimoriUserID int64) ([]UserRate, error) {
+	var all []UserRate
+	for page := 1; ; page++ {
+		query := url.Values{}
+		query.Set("user_id", strconv.FormatInt(shikimoriUserID, 10))
+		query.Set("target_type", "Anime")
+		query.Set("page", strconv.Itoa(page))
+		query.Set("limit", strconv.Itoa(userRatesPageSize))
+
+		var rates []UserRate
+		if err := c.DoAPI(ctx, accessToken, http.MethodGet, "/api/v2/user_rates?"+query.Encode(), nil, &rates); err != nil {
+			return nil, err
+		}
+		all = append(all, rates...)
+		if len(rates) < userRatesPageSize {
+			return all, nil
+		}
+	}
+}
+
+// FindUserRate ищет запись пользователя для аниме, nil - записи нет
+func (c *OAuthClient) FindUserRate(ctx context.Context, accessToken string, shikimoriUserID int64, animeID string) (*UserRate, error) {
+	query := url.Values{}
+	query.Set("user_id", strconv.FormatInt(shikimoriUserID, 10))
+	query.Set("target_id", animeID)
+	query.Set("target_type", "Anime")
+
+	var rates []UserRate
+	if err := c.DoAPI(ctx, accessToken, http.MethodGet, "/api/v2/user_rates?"+query.Encode(), nil, &rates); err != nil {
+		return nil, err
+	}
+	if len(rates) == 0 {
+		return nil, nil
+	}
+	return &rates[0], nil
+}
+
+// GetUserRate возвращает запись по ID, nil - запись удалена
+func (c *OAuthClient) GetUserRate(ctx context.Context, accessToken string, rateID int64) (*UserRate, error) {
+	var rate UserRate
+	err := c.DoAPI(ctx, accessToken, http.MethodGet, fmt.Sprintf("/api/v2/user_rates/%d", rateID), nil, &rate)
+	if isNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (c *OAuthClient) CreateUserRate(ctx context.Context, accessToken string, shikimoriUserID int64, animeID string, update UserRateUpdate) (*UserRate, error) {
+	targetID, err := strconv.ParseInt(animeID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid anime id %q", animeID)
+	}
+	body := map[string]interface{}{
+		"user_rate": map[string]interface{}{
+			"user_id":     shikimoriUserID,
+			"target_id":   targetID,
+			"target_type": "Anime",
+			"status":      update.Status,
+			"score":       update.Score,
+			"episodes":    update.Episodes,
+		},
+	}
+
+	var rate UserRate
+	if err := c.DoAPI(ctx, accessToken, http.MethodPost, "/api/v2/user_rates", body, &rate); err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+func (c *OAuthClient) UpdateUserRate(ctx context.Context, accessToken string, rateID int64, update UserRateUpdate) (*UserRate, error) {
+	body := map[string]interface{}{"user_rate": update}
+
+	var rate UserRate
+	if err := c.DoAPI(ctx, accessToken, http.MethodPatch, fmt.Sprintf("/api/v2/user_rates/%d", rateID), body, &rate); err != nil {
+		return nil, err
+	}
+	return &rate, nil
+}
+
+// DeleteUserRate удаляет запись. Уже удаленная запись не считается ошибкой.
+func (c *OAuthClient) DeleteUserRate(ctx context.Context, accessToken string, rateID int64) error {
+	err := c.DoAPI(ctx, accessToken, http.MethodDelete, fmt.Sprintf("/api/v2/user_rates/%d", rateID), nil, nil)
+	if isNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func isNotFound(err error) bool {
+	var apiErr *APIError
+	return errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound
+}
@@ -1,10 +1,15 @@
 package user
 
 import (
+	"errors"
+	"io"
 	"log"
+	"mime"
 	"net/http"
+	"strconv"
 
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
 
 	"github.com/labstack/echo/v4"
 )
@@ -28,7 +33,73 @@ func (h *Handler) Register(c echo.Context) error {
 	if err := h.service.Register(req.Email, req.Password); err != nil {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
-	return c.JSON(http.StatusOK, map[string]string{"message": "registered"})
+	return c.JSON(http.StatusOK, map[string]string{"message": "registered, check your email to verify the address"})
+}
+
+func (h *Handler) VerifyEmail(c echo.Context) error {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := h.service.VerifyEmail(req.Token); err != nil {
+		return accountTokenError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "email verified"})
+}
+
+func (h *Handler) ResendVerification(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := h.service.ResendVerification(req.Email); err != nil {
+		log.Printf("Failed to resend verification: %v", err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "if the account exists and is not verified, an email has been sent"})
+}
+
+func (h *Handler) ForgotPassword(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	// Не раскрываем, существует ли аккаунт
+	if err := h.service.ForgotPassword(req.Email); err != nil {
+		log.Printf("Failed to start password reset: %v", err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "if the account exists, a reset link has been sent"})
+}
+
+func (h *Handler) ResetPassword(c echo.Context) error {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := h.service.ResetPassword(req.Token, req.Password); err != nil {
+		return accountTokenError(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "password changed, please log in again"})
+}
+
+func accountTokenError(c echo.Context, err error) error {
+	if errors.Is(err, ErrInvalidUserToken) || errors.Is(err, ErrWeakPassword) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	log.Printf("Account token error: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "internal error"})
 }
 
 func (h *Handler) Login(c echo.Context) error {
@@ -39,28 +110,426 @@ func (h *Handler) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return err
 	}
-	token, err := h.service.Login(req.Email, req.Password)
+	tokens, err := h.service.Login(req.Email, req.Password, sessionMeta(c))
 	if err != nil {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
 	}
-	log.Println(token)
-	return c.JSON(http.StatusOK, echo.Map{"token": token})
+	return c.JSON(http.StatusOK, tokens)
 }
 
-func (h *Handler) Profile(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+// Refresh выдает новую пару токенов в обмен на refresh-токен
+func (h *Handler) Refresh(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	tokens, err := h.service.Refresh(req.RefreshToken, sessionMeta(c))
+	if err != nil {
+		if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+		}
+		log.Printf("Failed to refresh token: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to refresh token"})
 	}
+	return c.JSON(http.StatusOK, tokens)
+}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+// Logout завершает текущую сессию
+func (h *Handler) Logout(c echo.Context) error {
+	userID, sessionID, err := currentSession(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.Logout(userID, sessionID); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// LogoutAll завершает все сессии пользователя, включая текущую
+func (h *Handler) LogoutAll(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.LogoutAll(userID); err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) Sessions(c echo.Context) error {
+	userID, sessionID, err := currentSession(c)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := h.service.ListSessions(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	type sessionResponse struct {
+		Session
+		Current bool `json:"current"`
+	}
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		response = append(response, sessionResponse{
+			Session: session,
+			Current: session.ID.String() == sessionID,
+		})
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *Handler) RevokeSession(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.RevokeSession(userID, c.Param("session_id")); err != nil {
+		if errors.Is(err, ErrSessionNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListUsers - GET /admin/users?role=&limit=&offset=
+func (h *Handler) ListUsers(c echo.Context) error {
+	limit, offset := 50, 0
+	if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 && l <= 200 {
+		limit = l
+	}
+	if o, err := strconv.Atoi(c.QueryParam("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	users, err := h.service.ListUsers(c.QueryParam("role"), limit, offset)
+	if err != nil {
+		return roleError(c, err)
+	}
+	return c.JSON(http.StatusOK, users)
+}
+
+// SetUserRole - PUT /admin/users/:user_id/role
+func (h *Handler) SetUserRole(c echo.Context) error {
+	actorID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	userID := c.Param("user_id")
+	if err := h.service.SetRole(actorID, userID, req.Role); err != nil {
+		return roleError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{
+		"user_id": userID,
+		"role":    req.Role,
+	})
+}
+
+func roleError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidRole), errors.Is(err, ErrOwnRoleChange):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrUserNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+// ShikimoriLoginURL - GET /auth/shikimori/url
+func (h *Handler) ShikimoriLoginURL(c echo.Context) error {
+	url, err := h.service.ShikimoriAuthURL(OAuthPurposeLogin, "")
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"url": url})
+}
+
+// ShikimoriLogin - POST /auth/shikimori/callback, фронтенд передает code и state
+func (h *Handler) ShikimoriLogin(c echo.Context) error {
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	tokens, err := h.service.LoginWithShikimori(c.Request().Context(), req.Code, req.State, sessionMeta(c))
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, tokens)
+}
+
+// ShikimoriLinkURL - POST /profile/shikimori/link
+func (h *Handler) ShikimoriLinkURL(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	url, err := h.service.ShikimoriAuthURL(OAuthPurposeLink, userID)
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"url": url})
+}
+
+// LinkShikimori - POST /profile/shikimori/callback
+func (h *Handler) LinkShikimori(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	account, err := h.service.LinkShikimori(c.Request().Context(), userID, req.Code, req.State)
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// ShikimoriAccount - GET /profile/shikimori
+func (h *Handler) ShikimoriAccount(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	account, err := h.service.GetShikimoriAccount(userID)
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, account)
+}
+
+// UnlinkShikimori - DELETE /profile/shikimori
+func (h *Handler) UnlinkShikimori(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.UnlinkShikimori(userID); err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ImportShikimori - POST /profile/shikimori/import, импорт идет в фоне
+func (h *Handler) ImportShikimori(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.ImportShikimori(userID); err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusAccepted, echo.Map{"message": "import started"})
+}
+
+// SetShikimoriPush - PUT /profile/shikimori/push
+func (h *Handler) SetShikimoriPush(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.service.SetShikimoriPush(userID, req.Enabled); err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, echo.Map{"push_enabled": req.Enabled})
+}
+
+// ShikimoriSyncStatus - GET /profile/shikimori/sync
+func (h *Handler) ShikimoriSyncStatus(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	status, err := h.service.GetShikimoriSyncStatus(userID)
+	if err != nil {
+		return shikimoriError(c, err)
+	}
+	return c.JSON(http.StatusOK, status)
+}
+
+// maxImportFormOverhead - сколько сверх файла может занять остальная
+// multipart-форма: границы частей, заголовки и другие поля
+const maxImportFormOverhead = 1 << 20
+
+// ImportList - POST /profile/import?overwrite=true, принимает файл экспорта
+// MyAnimeList (XML или XML.gz) в поле file multipart-формы или телом
+// запроса другого типа
+func (h *Handler) ImportList(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	req := c.Request()
+	req.Body = http.MaxBytesReader(c.Response(), req.Body, maxImportFileSize+maxImportFormOverhead)
+
+	var body io.Reader = req.Body
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get(echo.HeaderContentType)); mediaType == echo.MIMEMultipartForm {
+		file, err := c.FormFile("file")
+		if err != nil {
+			if errors.Is(err, http.ErrMissingFile) {
+				return c.JSON(http.StatusBadRequest, echo.Map{"error": "multipart form has no file field"})
+			}
+			return importReadError(c, err)
+		}
+		src, err := file.Open()
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		defer src.Close()
+		body = src
+	}
+	data, err := io.ReadAll(io.LimitReader(body, maxImportFileSize+1))
+	if err != nil {
+		return importReadError(c, err)
+	}
+	if len(data) > maxImportFileSize {
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"error": "file is too large"})
+	}
+
+	job, err := h.service.StartListImport(userID, data, c.QueryParam("overwrite") == "true")
+	if err != nil {
+		if errors.Is(err, ErrInvalidImportFile) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// importReadError - ошибка чтения загружаемого файла: 413 при превышении
+// размера, иначе 400
+func importReadError(c echo.Context, err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return c.JSON(http.StatusRequestEntityTooLarge, echo.Map{"error": "file is too large"})
+	}
+	return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+}
+
+// GetListImport - GET /profile/import/:job_id, прогресс и отчет импорта
+func (h *Handler) GetListImport(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	job, err := h.service.GetListImport(userID, c.Param("job_id"))
+	if err != nil {
+		if errors.Is(err, ErrImportNotFound) {
+			return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// ExportList - GET /profile/export?format=mal|json|csv
+func (h *Handler) ExportList(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = "json"
+	}
+	data, err := h.service.ExportList(c.Request().Context(), userID, format)
+	if err != nil {
+		if errors.Is(err, ErrUnsupportedExportFormat) {
+			return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+		}
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+
+	contentType, filename := echo.MIMEApplicationJSONCharsetUTF8, "anime-list.json"
+	switch format {
+	case "mal":
+		contentType, filename = echo.MIMEApplicationXMLCharsetUTF8, "anime-list.xml"
+	case "csv":
+		contentType, filename = "text/csv; charset=utf-8", "anime-list.csv"
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	return c.Blob(http.StatusOK, contentType, data)
+}
+
+func shikimoriError(c echo.Context, err error) error {
+	var apiErr *shikimori.APIError
+	switch {
+	case errors.Is(err, ErrInvalidOAuthState), errors.Is(err, ErrLastLoginMethod):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrShikimoriNotLinked):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrShikimoriLinkedElsewhere), errors.Is(err, ErrImportInProgress):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrShikimoriOAuthDisabled):
+		return c.JSON(http.StatusServiceUnavailable, echo.Map{"error": err.Error()})
+	case errors.As(err, &apiErr):
+		// Shikimori отклонил код авторизации или токен
+		log.Printf("Shikimori OAuth error: %v", err)
+		return c.JSON(http.StatusBadGateway, echo.Map{"error": "shikimori authorization failed"})
+	default:
+		log.Printf("Shikimori account error: %v", err)
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": "internal error"})
+	}
+}
+
+func sessionMeta(c echo.Context) SessionMeta {
+	return SessionMeta{
+		UserAgent: c.Request().UserAgent(),
+		IP:        c.RealIP(),
+	}
+}
+
+func (h *Handler) Profile(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
 	user, err := h.service.GetProfile(userID)
@@ -70,28 +539,55 @@ func (h *Handler) Profile(c echo.Context) error {
 		})
 	}
 
+	watched, favorites, err := h.service.GetListIDs(userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
+	}
+
 	return c.JSON(http.StatusOK, echo.Map{
 		"user_id":            user.ID,
 		"email":              user.Email,
-		"watched_anime_ids":  user.WatchedAnimeIDs,
-		"favorite_anime_ids": user.FavoriteAnimeIDs,
+		"email_verified":     user.EmailVerifiedAt != nil,
+		"role":               user.Role,
+		"karma":              user.Karma,
+		"username":           user.Username,
+		"watched_anime_ids":  watched,
+		"favorite_anime_ids": favorites,
 	})
 }
 
-func (h *Handler) AddWatched(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+// SetUsername - PUT /profile/username, имя для @упоминаний
+func (h *Handler) SetUsername(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	var req struct {
+		Username string `json:"username"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	username, err := h.service.SetUsername(userID, req.Username)
+	switch {
+	case errors.Is(err, ErrInvalidUsername):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrUsernameTaken):
+		return c.JSON(http.StatusConflict, echo.Map{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+	return c.JSON(http.StatusOK, echo.Map{"username": username})
+}
+
+func (h *Handler) AddWatched(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
 	animeID := c.Param("anime_id")
@@ -112,19 +608,9 @@ func (h *Handler) AddWatched(c echo.Context) error {
 }
 
 func (h *Handler) AddFavorite(c echo.Context) error {
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
-	}
-
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
-	}
-
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
 	animeID := c.Param("anime_id")
@@ -144,24 +630,29 @@ func (h *Handler) AddFavorite(c echo.Context) error {
 	})
 }
 func (h *Handler) GetWatchedAnime(c echo.Context) error {
-	// Получаем userID из JWT токена
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	// Получаем список аниме с деталями
+	animeList, err := h.service.GetWatchedAnimeDetails(c.Request().Context(), userID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, echo.Map{
+			"error": err.Error(),
+		})
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	return c.JSON(http.StatusOK, animeList)
+}
+func (h *Handler) GetFavouriteAnime(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
 	// Получаем список аниме с деталями
-	animeList, err := h.service.GetWatchedAnimeDetails(userID)
+	animeList, err := h.service.GetFavouriteAnimeDetails(c.Request().Context(), userID)
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, echo.Map{
 			"error": err.Error(),
@@ -170,30 +661,152 @@ func (h *Handler) GetWatchedAnime(c echo.Context) error {
 
 	return c.JSON(http.StatusOK, animeList)
 }
-func (h *Handler) GetFavouriteAnime(c echo.Context) error {
-	// Получаем userID из JWT токена
-	userToken, ok := c.Get("user").(*jwt.Token)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid token")
+
+// GetList возвращает список аниме пользователя, ?status= фильтрует по статусу
+func (h *Handler) GetList(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
-	claims, ok := userToken.Claims.(jwt.MapClaims)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "invalid claims")
+	entries, err := h.service.GetList(userID, c.QueryParam("status"))
+	if err != nil {
+		return listError(c, err)
 	}
 
-	userID, ok := claims["user_id"].(string)
-	if !ok {
-		return echo.NewHTTPError(http.StatusUnauthorized, "user_id missing")
+	return c.JSON(http.StatusOK, entries)
+}
+
+func (h *Handler) GetListEntry(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
 	}
 
-	// Получаем список аниме с деталями
-	animeList, err := h.service.GetFavouriteAnimeDetails(userID)
+	entry, err := h.service.GetListEntry(userID, c.Param("anime_id"))
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, echo.Map{
-			"error": err.Error(),
-		})
+		return listError(c, err)
 	}
 
-	return c.JSON(http.StatusOK, animeList)
+	return c.JSON(http.StatusOK, entry)
+}
+
+// SaveListEntry создает или полностью заменяет запись списка
+func (h *Handler) SaveListEntry(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	animeID := c.Param("anime_id")
+	if animeID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "anime_id is required")
+	}
+
+	var req AnimeEntryUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	entry, err := h.service.SaveListEntry(userID, animeID, req)
+	if err != nil {
+		return listError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, entry)
+}
+
+func (h *Handler) RemoveListEntry(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.RemoveListEntry(userID, c.Param("anime_id")); err != nil {
+		return listError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *Handler) RemoveWatched(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	animeID := c.Param("anime_id")
+	if err := h.service.RemoveWatched(userID, animeID); err != nil {
+		return listError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":   "removed from watched",
+		"anime_id": animeID,
+	})
+}
+
+func (h *Handler) RemoveFavorite(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	animeID := c.Param("anime_id")
+	if err := h.service.RemoveFavorite(userID, animeID); err != nil {
+		return listError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, echo.Map{
+		"status":   "removed from favorites",
+		"anime_id": animeID,
+	})
+}
+
+// ReorderFavorites задает порядок избранного: перечисленные аниме идут первыми
+func (h *Handler) ReorderFavorites(c echo.Context) error {
+	userID, err := currentUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		AnimeIDs []string `json:"anime_ids"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := h.service.ReorderFavorites(userID, req.AnimeIDs); err != nil {
+		return listError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func listError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidListEntry):
+		return c.JSON(http.StatusBadRequest, echo.Map{"error": err.Error()})
+	case errors.Is(err, ErrEntryNotFound):
+		return c.JSON(http.StatusNotFound, echo.Map{"error": err.Error()})
+	default:
+		return c.JSON(http.StatusInternalServerError, echo.Map{"error": err.Error()})
+	}
+}
+
+func currentSession(c echo.Context) (string, string, error) {
+	user, ok := auth.GetUser(c)
+	if !ok {
+		return "", "", echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return user.ID.String(), user.SessionID.String(), nil
+}
+
+func currentUserID(c echo.Context) (string, error) {
+	user, ok := auth.GetUser(c)
+	if !ok {
+		return "", echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return user.ID.String(), nil
 }
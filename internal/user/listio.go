@@ -0,0 +1,272 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUnsupportedExportFormat = errors.New("unsupported export format, use mal, json or csv")
+	ErrImportInterrupted       = errors.New("import was interrupted by a server restart, upload the file again")
+)
+
+const (
+	// Сколько записей файла сопоставляется с Shikimori за один запрос
+	importChunkSize   = 50
+	listImportTimeout = 30 * time.Minute
+)
+
+// StartListImport разбирает файл экспорта MyAnimeList и запускает импорт
+// в фоне. Записи, которые уже есть в списке, не меняются без overwrite.
+func (s *service) StartListImport(userID string, data []byte, overwrite bool) (*ListImportJob, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, ErrUserNotFound
+	}
+	list, err := parseMALExport(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(list.Anime) == 0 {
+		return nil, fmt.Errorf("%w: no anime entries", ErrInvalidImportFile)
+	}
+
+	job := &ListImportJob{
+		ID:        uuid.New(),
+		UserID:    uid,
+		Format:    "mal",
+		Status:    ImportStatusPending,
+		Total:     len(list.Anime),
+		Unmatched: []UnmatchedListItem{},
+	}
+	if err := s.repo.CreateImportJob(job); err != nil {
+		return nil, err
+	}
+
+	go s.runListImport(*job, list.Anime, overwrite)
+	return job, nil
+}
+
+// FailInterruptedImports завершает ошибкой импорты, оставшиеся незавершенными
+// с прошлого запуска: импорт идет в горутине процесса, и после перезапуска
+// его некому продолжить. Вызывается при старте сервера.
+func (s *service) FailInterruptedImports() (int64, error) {
+	return s.repo.FailUnfinishedImportJobs(ErrImportInterrupted.Error())
+}
+
+func (s *service) GetListImport(userID, jobID string) (*ListImportJob, error) {
+	if _, err := uuid.Parse(jobID); err != nil {
+		return nil, ErrImportNotFound
+	}
+	return s.repo.GetImportJob(userID, jobID)
+}
+
+func (s *service) runListImport(job ListImportJob, items []malAnime, overwrite bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), listImportTimeout)
+	defer cancel()
+	userID := job.UserID.String()
+
+	save := func() {
+		if err := s.repo.UpdateImportJob(&job); err != nil {
+			log.Printf("Failed to update import %s: %v", job.ID, err)
+		}
+	}
+	fail := func(err error) {
+		log.Printf("List import %s failed: %v", job.ID, err)
+		now := time.Now()
+		job.Status = ImportStatusFailed
+		job.Error = err.Error()
+		job.FinishedAt = &now
+		save()
+	}
+
+	job.Status = ImportStatusRunning
+	save()
+
+	entries, err := s.repo.GetEntries(userID, "")
+	if err != nil {
+		fail(err)
+		return
+	}
+	inList := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		if entry.Status != "" {
+			inList[entry.AnimeID] = true
+		}
+	}
+
+	for start := 0; start < len(items); start += importChunkSize {
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+			return
+		}
+		chunk := items[start:min(start+importChunkSize, len(items))]
+
+		ids := make([]string, 0, len(chunk))
+		for _, item := range chunk {
+			ids = append(ids, strconv.Itoa(item.SeriesID))
+		}
+		// В файле ID MyAnimeList, в списке - ID Shikimori
+		byMalID, err := s.shikimoriService.GetAnimesByMalIDs(ctx, ids)
+		if err != nil {
+			log.Printf("List import %s: shikimori request failed: %v", job.ID, err)
+		}
+
+		for _, item := range chunk {
+			unmatched := func(reason string) {
+				job.Unmatched = append(job.Unmatched, UnmatchedListItem{
+					ExternalID: strconv.Itoa(item.SeriesID),
+					Title:      item.Title.Value,
+					Reason:     reason,
+				})
+			}
+
+			anime, ok := byMalID[strconv.Itoa(item.SeriesID)]
+			switch {
+			case !ok && err != nil:
+				unmatched("shikimori is unavailable")
+				continue
+			case !ok:
+				unmatched("not found on shikimori")
+				continue
+			}
+
+			update, convErr := item.entryUpdate()
+			if convErr == nil {
+				convErr = validateEntryUpdate(update)
+			}
+			if convErr != nil {
+				unmatched(convErr.Error())
+				continue
+			}
+			if inList[anime.ID] && !overwrite {
+				job.Skipped++
+				continue
+			}
+			if _, err := s.repo.SaveEntry(userID, anime.ID, update); err != nil {
+				log.Printf("List import %s: failed to save %s: %v", job.ID, anime.ID, err)
+				unmatched("failed to save")
+				continue
+			}
+			inList[anime.ID] = true
+			job.Imported++
+		}
+
+		job.Processed += len(chunk)
+		save()
+	}
+
+	now := time.Now()
+	job.Status = ImportStatusCompleted
+	job.FinishedAt = &now
+	save()
+}
+
+// ExportList выгружает список пользователя в формате mal, json или csv
+func (s *service) ExportList(ctx context.Context, userID, format string) ([]byte, error) {
+	entries, err := s.repo.GetEntries(userID, "")
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "json":
+		if entries == nil {
+			entries = []AnimeEntry{}
+		}
+		return json.MarshalIndent(exportedList{Entries: entries}, "", "  ")
+	case "csv":
+		return exportCSV(entries)
+	case "mal":
+		return s.exportMAL(ctx, entries)
+	default:
+		return nil, ErrUnsupportedExportFormat
+	}
+}
+
+type exportedList struct {
+	Entries []AnimeEntry `json:"entries"`
+}
+
+func exportCSV(entries []AnimeEntry) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{
+		"anime_id", "status", "score", "episodes_watched", "rewatch_count",
+		"started_at", "finished_at", "is_favorite", "notes",
+	})
+	for _, entry := range entries {
+		score := ""
+		if entry.Score != nil {
+			score = strconv.Itoa(*entry.Score)
+		}
+		_ = writer.Write([]string{
+			entry.AnimeID,
+			entry.Status,
+			score,
+			strconv.Itoa(entry.EpisodesWatched),
+			strconv.Itoa(entry.RewatchCount),
+			formatCSVDate(entry.StartedAt),
+			formatCSVDate(entry.FinishedAt),
+			strconv.FormatBool(entry.IsFavorite),
+			entry.Notes,
+		})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func formatCSVDate(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(malDateLayout)
+}
+
+// exportMAL выгружает записи со статусом. Названия и MAL ID берутся с
+// Shikimori, при его недоступности ID Shikimori используется как MAL ID.
+func (s *service) exportMAL(ctx context.Context, entries []AnimeEntry) ([]byte, error) {
+	var ids []string
+	for _, entry := range entries {
+		if entry.Status != "" {
+			ids = append(ids, entry.AnimeID)
+		}
+	}
+
+	details := map[string]shikimori.Anime{}
+	if len(ids) > 0 {
+		animes, _ := s.shikimoriService.FetchAnimesByIDs(ctx, ids)
+		for _, anime := range animes {
+			details[anime.ID] = anime
+		}
+	}
+
+	items := make([]malExportItem, 0, len(ids))
+	for _, entry := range entries {
+		if entry.Status == "" {
+			continue
+		}
+		item := malExportItem{Entry: entry}
+		item.MalID, _ = strconv.Atoi(entry.AnimeID)
+		if anime, ok := details[entry.AnimeID]; ok {
+			if malID, err := strconv.Atoi(anime.MalID); err == nil {
+				item.MalID = malID
+			}
+			item.Title = anime.Name
+			item.Type = malTypes[anime.Kind]
+			item.Episodes = anime.Episodes
+		}
+		items = append(items, item)
+	}
+	return writeMALExport(items)
+}
@@ -0,0 +1,217 @@
+package user
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori/shikimoritest"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// listRepo хранит список и задачу импорта в памяти
+type listRepo struct {
+	Repository
+	entries map[string]AnimeEntry
+	job     ListImportJob
+}
+
+func (r *listRepo) GetEntries(userID string, status string) ([]AnimeEntry, error) {
+	var entries []AnimeEntry
+	for _, entry := range r.entries {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (r *listRepo) SaveEntry(userID string, animeID string, update AnimeEntryUpdate) (*AnimeEntry, error) {
+	entry := AnimeEntry{AnimeID: animeID, Status: update.Status, EpisodesWatched: update.EpisodesWatched, Score: update.Score}
+	r.entries[animeID] = entry
+	return &entry, nil
+}
+
+func (r *listRepo) UpdateImportJob(job *ListImportJob) error {
+	r.job = *job
+	return nil
+}
+
+func newListTestService(t *testing.T, entries ...AnimeEntry) (*service, *listRepo) {
+	t.Helper()
+	provider := shikimoritest.NewProvider(time.Hour)
+	provider.AddAnime(
+		shikimoritest.Anime{ID: "1", MalID: "1", Name: "Cowboy Bebop", Kind: "tv", Episodes: 26},
+		shikimoritest.Anime{ID: "5", MalID: "50", Name: "Other", Kind: "movie", Episodes: 1},
+		shikimoritest.Anime{ID: "z7", Name: "Shikimori only", Kind: "ona", Episodes: 12},
+	)
+	server := httptest.NewServer(provider)
+	t.Cleanup(server.Close)
+	t.Setenv("SHIKIMORI_GRAPHQL_URL", server.URL+"/api/graphql")
+
+	repo := &listRepo{entries: map[string]AnimeEntry{}}
+	for _, entry := range entries {
+		repo.entries[entry.AnimeID] = entry
+	}
+	return &service{repo: repo, shikimoriService: shikimori.NewService()}, repo
+}
+
+func TestListImportMatchesByMalID(t *testing.T) {
+	s, repo := newListTestService(t)
+	items := []malAnime{
+		{SeriesID: 1, Title: cdata{Value: "Cowboy Bebop"}, Status: "Completed", WatchedEps: 26},
+		// На Shikimori есть аниме с ID 5, но его MAL ID - 50
+		{SeriesID: 5, Title: cdata{Value: "Mismatched"}, Status: "Watching", WatchedEps: 1},
+		{SeriesID: 404, Title: cdata{Value: "Missing"}, Status: "Watching"},
+	}
+
+	s.runListImport(ListImportJob{ID: uuid.New(), UserID: uuid.New(), Total: len(items)}, items, false)
+
+	if repo.job.Status != ImportStatusCompleted || repo.job.Imported != 1 {
+		t.Fatalf("job = %+v, want completed with 1 imported", repo.job)
+	}
+	if _, ok := repo.entries["1"]; !ok || len(repo.entries) != 1 {
+		t.Errorf("entries = %v, want only anime 1", repo.entries)
+	}
+	var unmatched []string
+	for _, item := range repo.job.Unmatched {
+		unmatched = append(unmatched, item.ExternalID)
+	}
+	if strings.Join(unmatched, ",") != "5,404" {
+		t.Errorf("unmatched = %v, want [5 404]", unmatched)
+	}
+}
+
+func TestExportMALWritesSeriesType(t *testing.T) {
+	s, _ := newListTestService(t)
+	entries := []AnimeEntry{
+		{AnimeID: "1", Status: StatusCompleted, EpisodesWatched: 26},
+		{AnimeID: "5", Status: StatusPlanned},
+	}
+
+	data, err := s.exportMAL(context.Background(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := parseMALExport(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]string{1: "TV", 50: "Movie"}
+	if len(list.Anime) != len(want) {
+		t.Fatalf("exported %d entries, want %d", len(list.Anime), len(want))
+	}
+	for _, anime := range list.Anime {
+		if want[anime.SeriesID] != anime.Type {
+			t.Errorf("series %d: type %q, want %q", anime.SeriesID, anime.Type, want[anime.SeriesID])
+		}
+	}
+}
+
+// importService запоминает файл, переданный обработчиком
+type importService struct {
+	Service
+	data []byte
+}
+
+func (s *importService) StartListImport(userID string, data []byte, overwrite bool) (*ListImportJob, error) {
+	s.data = data
+	return &ListImportJob{ID: uuid.New(), Status: ImportStatusPending}, nil
+}
+
+// zeros - бесконечный поток нулевых байт для больших тел запроса
+type zeros struct{}
+
+func (zeros) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// multipartBody собирает форму с полем field и содержимым content
+func multipartBody(t *testing.T, field string, content io.Reader) (io.Reader, string) {
+	t.Helper()
+	var head bytes.Buffer
+	w := multipart.NewWriter(&head)
+	if _, err := w.CreateFormFile(field, "animelist.xml"); err != nil {
+		t.Fatal(err)
+	}
+	tail := "\r\n--" + w.Boundary() + "--\r\n"
+	return io.MultiReader(&head, content, strings.NewReader(tail)), w.FormDataContentType()
+}
+
+func TestImportListUpload(t *testing.T) {
+	const file = "<myanimelist></myanimelist>"
+	tooLarge := func() io.Reader { return io.LimitReader(zeros{}, maxImportFileSize+1) }
+
+	tests := []struct {
+		name        string
+		body        func(t *testing.T) (io.Reader, string)
+		status      int
+		wantFile    bool
+		wantMessage string
+	}{
+		{
+			name:     "multipart file",
+			body:     func(t *testing.T) (io.Reader, string) { return multipartBody(t, "file", strings.NewReader(file)) },
+			status:   http.StatusAccepted,
+			wantFile: true,
+		},
+		{
+			name:        "multipart without file field",
+			body:        func(t *testing.T) (io.Reader, string) { return multipartBody(t, "other", strings.NewReader(file)) },
+			status:      http.StatusBadRequest,
+			wantMessage: "multipart form has no file field",
+		},
+		{
+			name:     "raw body",
+			body:     func(t *testing.T) (io.Reader, string) { return strings.NewReader(file), echo.MIMEApplicationXML },
+			status:   http.StatusAccepted,
+			wantFile: true,
+		},
+		{
+			name:   "raw body too large",
+			body:   func(t *testing.T) (io.Reader, string) { return tooLarge(), echo.MIMEOctetStream },
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "multipart too large",
+			body: func(t *testing.T) (io.Reader, string) {
+				return multipartBody(t, "file", io.LimitReader(zeros{}, maxImportFileSize+maxImportFormOverhead))
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := &importService{}
+			body, contentType := tt.body(t)
+			req := httptest.NewRequest(http.MethodPost, "/profile/import", body)
+			req.Header.Set(echo.HeaderContentType, contentType)
+			req = req.WithContext(auth.WithUser(req.Context(), &auth.CurrentUser{ID: uuid.New(), Role: auth.RoleUser}))
+			rec := httptest.NewRecorder()
+
+			if err := NewHandler(service).ImportList(echo.New().NewContext(req, rec)); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.wantFile && string(service.data) != file {
+				t.Errorf("imported %q, want %q", service.data, file)
+			}
+			if !tt.wantFile && service.data != nil {
+				t.Error("import started for a rejected request")
+			}
+			if tt.wantMessage != "" && !strings.Contains(rec.Body.String(), tt.wantMessage) {
+				t.Errorf("body = %s, want %q", rec.Body, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package user
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrInvalidImportFile = errors.New("file is not a valid MyAnimeList export")
+
+// Распакованный файл больше этого размера не принимается
+const maxImportFileSize = 50 << 20
+
+// malList - файл экспорта MyAnimeList (animelist_*.xml)
+type malList struct {
+	XMLName xml.Name   `xml:"myanimelist"`
+	Info    malInfo    `xml:"myinfo"`
+	Anime   []malAnime `xml:"anime"`
+}
+
+type malInfo struct {
+	ExportType int `xml:"user_export_type"`
+	Total      int `xml:"user_total_anime"`
+}
+
+type malAnime struct {
+	SeriesID       int    `xml:"series_animedb_id"`
+	Title          cdata  `xml:"series_title"`
+	Type           string `xml:"series_type"`
+	Episodes       int    `xml:"series_episodes"`
+	MyID           int    `xml:"my_id"`
+	WatchedEps     int    `xml:"my_watched_episodes"`
+	StartDate      string `xml:"my_start_date"`
+	FinishDate     string `xml:"my_finish_date"`
+	Score          int    `xml:"my_score"`
+	Status         string `xml:"my_status"`
+	Comments       cdata  `xml:"my_comments"`
+	TimesWatched   int    `xml:"my_times_watched"`
+	Rewatching     int    `xml:"my_rewatching"`
+	UpdateOnImport int    `xml:"update_on_import"`
+}
+
+// cdata пишется в XML как CDATA, как в файлах MyAnimeList
+type cdata struct {
+	Value string `xml:",cdata"`
+}
+
+// Статусы MyAnimeList: текстовые и числовые (старые экспорты)
+var malStatuses = map[string]string{
+	"watching":      StatusWatching,
+	"completed":     StatusCompleted,
+	"on-hold":       StatusOnHold,
+	"dropped":       StatusDropped,
+	"plan to watch": StatusPlanned,
+	"1":             StatusWatching,
+	"2":             StatusCompleted,
+	"3":             StatusOnHold,
+	"4":             StatusDropped,
+	"6":             StatusPlanned,
+}
+
+var malStatusNames = map[string]string{
+	StatusWatching:   "Watching",
+	StatusRewatching: "Watching",
+	StatusCompleted:  "Completed",
+	StatusOnHold:     "On-Hold",
+	StatusDropped:    "Dropped",
+	StatusPlanned:    "Plan to Watch",
+}
+
+// Типы аниме Shikimori в обозначениях MyAnimeList
+var malTypes = map[string]string{
+	"tv":      "TV",
+	"movie":   "Movie",
+	"ova":     "OVA",
+	"ona":     "ONA",
+	"special": "Special",
+	"music":   "Music",
+}
+
+const malDateLayout = "2006-01-02"
+
+// parseMALExport разбирает XML, сжатый gzip или нет
+func parseMALExport(data []byte) (*malList, error) {
+	var reader io.Reader = bytes.NewReader(data)
+	if len(data) > 2 && data[0] == 0x1f && data[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return nil, ErrInvalidImportFile
+		}
+		defer gz.Close()
+		reader = gz
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(reader, maxImportFileSize+1))
+	if err != nil {
+		return nil, ErrInvalidImportFile
+	}
+	if len(raw) > maxImportFileSize {
+		return nil, fmt.Errorf("%w: file is too large", ErrInvalidImportFile)
+	}
+
+	var list malList
+	if err := xml.Unmarshal(raw, &list); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+	return &list, nil
+}
+
+// entryUpdate переводит запись MyAnimeList в формат списка
+func (a *malAnime) entryUpdate() (AnimeEntryUpdate, error) {
+	status, ok := malStatuses[strings.ToLower(strings.TrimSpace(a.Status))]
+	if !ok {
+		return AnimeEntryUpdate{}, fmt.Errorf("unknown status %q", a.Status)
+	}
+	if a.Rewatching == 1 {
+		status = StatusRewatching
+	}
+
+	update := AnimeEntryUpdate{
+		Status:          status,
+		EpisodesWatched: a.WatchedEps,
+		RewatchCount:    a.TimesWatched,
+		StartedAt:       parseMALDate(a.StartDate),
+		FinishedAt:      parseMALDate(a.FinishDate),
+		Notes:           strings.TrimSpace(a.Comments.Value),
+	}
+	if a.Score > 0 {
+		score := a.Score
+		update.Score = &score
+	}
+	if runes := []rune(update.Notes); len(runes) > maxNotesLength {
+		update.Notes = string(runes[:maxNotesLength])
+	}
+	return update, nil
+}
+
+// parseMALDate: MyAnimeList пишет неизвестную дату как 0000-00-00
+func parseMALDate(value string) *time.Time {
+	t, err := time.Parse(malDateLayout, strings.TrimSpace(value))
+	if err != nil {
+		return nil
+	}
+	return &t
+}
+
+func formatMALDate(t *time.Time) string {
+	if t == nil {
+		return "0000-00-00"
+	}
+	return t.Format(malDateLayout)
+}
+
+// malExportItem - запись списка с данными аниме для экспорта
+type malExportItem struct {
+	Entry    AnimeEntry
+	MalID    int
+	Title    string
+	Type     string
+	Episodes int
+}
+
+func writeMALExport(items []malExportItem) ([]byte, error) {
+	list := malList{
+		Info: malInfo{ExportType: 1, Total: len(items)},
+	}
+	for _, item := range items {
+		entry := item.Entry
+		anime := malAnime{
+			SeriesID:       item.MalID,
+			Title:          cdata{Value: item.Title},
+			Type:           item.Type,
+			Episodes:       item.Episodes,
+			WatchedEps:     entry.EpisodesWatched,
+			StartDate:      formatMALDate(entry.StartedAt),
+			FinishDate:     formatMALDate(entry.FinishedAt),
+			Status:         malStatusNames[entry.Status],
+			Comments:       cdata{Value: entry.Notes},
+			TimesWatched:   entry.RewatchCount,
+			UpdateOnImport: 1,
+		}
+		if entry.Score != nil {
+			anime.Score = *entry.Score
+		}
+		if entry.Status == StatusRewatching {
+			anime.Rewatching = 1
+		}
+		list.Anime = append(list.Anime, anime)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&buf)
+	encoder.Indent("", "\t")
+	if err := encoder.Encode(list); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
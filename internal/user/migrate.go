@@ -0,0 +1,79 @@
+package user
+
+import (
+	"gorm.io/gorm"
+)
+
+// VerifyLegacyEmails считает подтвержденными адреса аккаунтов, созданных до
+// появления подтверждения email, чтобы они могли и дальше комментировать.
+// Вызывается один раз - сразу после добавления колонки email_verified_at.
+func VerifyLegacyEmails(db *gorm.DB) error {
+	return db.Model(&User{}).
+		Where("email_verified_at IS NULL AND email IS NOT NULL").
+		UpdateColumn("email_verified_at", gorm.Expr("created_at")).Error
+}
+
+// NormalizeLegacyEmails приводит к нижнему регистру адреса, сохраненные
+// до нормализации. Адрес, совпадающий после этого с другим аккаунтом,
+// остается как есть: вход по нему все равно работает через lower(email).
+func NormalizeLegacyEmails(db *gorm.DB) error {
+	return db.Exec(`UPDATE users SET email = lower(email)
+		WHERE email <> lower(email)
+		AND NOT EXISTS (SELECT 1 FROM users other WHERE other.email = lower(users.email))`).Error
+}
+
+// MigrateLegacyLists переносит старые массивы watched_anime_ids и
+// favorite_anime_ids из таблицы users в user_anime_entries и удаляет их.
+// Порядок избранного сохраняется в favorite_position (номер в массиве),
+// в том числе для аниме, которые были и в просмотренных. Порядок
+// просмотренных - через created_at записей.
+func MigrateLegacyLists(db *gorm.DB) error {
+	migrator := db.Migrator()
+	hasWatched := migrator.HasColumn(&User{}, "watched_anime_ids")
+	hasFavorites := migrator.HasColumn(&User{}, "favorite_anime_ids")
+	if !hasWatched && !hasFavorites {
+		return nil
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if hasWatched {
+			if err := tx.Exec(`
+				INSERT INTO user_anime_entries
+					(id, user_id, anime_id, status, is_favorite, created_at, updated_at)
+				SELECT gen_random_uuid(), u.id, w.anime_id, ?, false,
+					u.created_at + w.ord * interval '1 millisecond', now()
+				FROM users u
+				CROSS JOIN LATERAL unnest(u.watched_anime_ids) WITH ORDINALITY AS w(anime_id, ord)
+				WHERE w.anime_id <> ''
+				ON CONFLICT (user_id, anime_id) DO NOTHING
+			`, StatusCompleted).Error; err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&User{}, "watched_anime_ids"); err != nil {
+				return err
+			}
+		}
+
+		if hasFavorites {
+			if err := tx.Exec(`
+				INSERT INTO user_anime_entries
+					(id, user_id, anime_id, status, is_favorite, favorite_position, created_at, updated_at)
+				SELECT DISTINCT ON (u.id, f.anime_id)
+					gen_random_uuid(), u.id, f.anime_id, '', true, f.ord,
+					u.created_at + f.ord * interval '1 millisecond', now()
+				FROM users u
+				CROSS JOIN LATERAL unnest(u.favorite_anime_ids) WITH ORDINALITY AS f(anime_id, ord)
+				WHERE f.anime_id <> ''
+				ORDER BY u.id, f.anime_id, f.ord
+				ON CONFLICT (user_id, anime_id) DO UPDATE
+					SET is_favorite = true, favorite_position = EXCLUDED.favorite_position
+			`).Error; err != nil {
+				return err
+			}
+			if err := tx.Migrator().DropColumn(&User{}, "favorite_anime_ids"); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
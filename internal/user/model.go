@@ -4,15 +4,215 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/lib/pq"
 )
 
 type User struct {
 	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
-	Email     string    `gorm:"unique" json:"email"`
+	Email     string    `gorm:"unique;default:null;index:idx_users_email_lower,expression:lower(email)" json:"email"` // Пусто у аккаунтов, созданных через Shikimori
 	Password  string    `json:"-"`
 	CreatedAt time.Time `json:"created_at"`
 
-	WatchedAnimeIDs  pq.StringArray `gorm:"type:text[]" json:"watched_anime_ids"`
-	FavoriteAnimeIDs pq.StringArray `gorm:"type:text[]" json:"favorite_anime_ids"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	Role            string     `gorm:"not null;default:user" json:"role"` // См. auth.RoleUser и др.
+	Karma           int        `gorm:"not null;default:0" json:"karma"`   // Сумма рейтингов комментариев
+	// Имя для @упоминаний в комментариях, хранится в нижнем регистре.
+	// Пусто, пока пользователь его не выбрал.
+	Username *string `gorm:"uniqueIndex" json:"username,omitempty"`
+}
+
+// Назначение одноразовых токенов из писем
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposeResetPassword = "reset_password"
+)
+
+// UserToken - одноразовый токен подтверждения email или сброса пароля.
+// Клиенту отдается подписанная обертка над ID, см. signUserToken.
+type UserToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Purpose   string    `gorm:"not null"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// Статусы записи в списке аниме пользователя
+const (
+	StatusPlanned    = "planned"
+	StatusWatching   = "watching"
+	StatusCompleted  = "completed"
+	StatusOnHold     = "on_hold"
+	StatusDropped    = "dropped"
+	StatusRewatching = "rewatching"
+)
+
+var validStatuses = map[string]bool{
+	StatusPlanned:    true,
+	StatusWatching:   true,
+	StatusCompleted:  true,
+	StatusOnHold:     true,
+	StatusDropped:    true,
+	StatusRewatching: true,
+}
+
+// AnimeEntry - запись в списке аниме пользователя.
+// Пустой Status означает, что аниме добавлено только в избранное.
+type AnimeEntry struct {
+	ID               uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID           uuid.UUID  `gorm:"type:uuid;not null;uniqueIndex:idx_user_anime_entries_user_anime" json:"-"`
+	AnimeID          string     `gorm:"not null;uniqueIndex:idx_user_anime_entries_user_anime" json:"anime_id"` // Shikimori ID аниме
+	Status           string     `gorm:"index" json:"status"`
+	Score            *int       `json:"score"` // 1-10, nil - без оценки
+	EpisodesWatched  int        `gorm:"not null;default:0" json:"episodes_watched"`
+	RewatchCount     int        `gorm:"not null;default:0" json:"rewatch_count"`
+	StartedAt        *time.Time `gorm:"type:date" json:"started_at"`
+	FinishedAt       *time.Time `gorm:"type:date" json:"finished_at"`
+	Notes            string     `gorm:"type:text" json:"notes"`
+	IsFavorite       bool       `gorm:"not null;default:false" json:"is_favorite"`
+	FavoritePosition *int       `json:"favorite_position,omitempty"` // Порядок в витрине избранного
+	ShikimoriRateID  *int64     `json:"shikimori_rate_id,omitempty"` // Связанная запись списка на Shikimori
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+}
+
+func (AnimeEntry) TableName() string {
+	return "user_anime_entries"
+}
+
+// AnimeEntryUpdate - изменяемые поля записи списка
+type AnimeEntryUpdate struct {
+	Status          string     `json:"status"`
+	Score           *int       `json:"score"`
+	EpisodesWatched int        `json:"episodes_watched"`
+	RewatchCount    int        `json:"rewatch_count"`
+	StartedAt       *time.Time `json:"started_at"`
+	FinishedAt      *time.Time `json:"finished_at"`
+	Notes           string     `json:"notes"`
+}
+
+// Session - устройство, на котором пользователь вошел в аккаунт.
+// Все refresh-токены сессии образуют одно семейство ротации.
+type Session struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"-"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+}
+
+// RefreshToken хранится только в виде SHA-256 хеша
+type RefreshToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash string    `gorm:"not null;uniqueIndex"`
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
+// SessionMeta - данные клиента, с которого выполняется вход
+type SessionMeta struct {
+	UserAgent string
+	IP        string
+}
+
+// ShikimoriAccount - привязанный аккаунт Shikimori.
+// Токены Shikimori хранятся зашифрованными, см. encryptSecret.
+type ShikimoriAccount struct {
+	UserID          uuid.UUID `gorm:"type:uuid;primaryKey" json:"-"`
+	ShikimoriUserID int64     `gorm:"not null;uniqueIndex" json:"shikimori_user_id"`
+	Nickname        string    `json:"nickname"`
+	AccessToken     string    `gorm:"type:text" json:"-"`
+	RefreshToken    string    `gorm:"type:text" json:"-"`
+	TokenExpiresAt  time.Time `json:"-"`
+	CreatedAt       time.Time `json:"linked_at"`
+	UpdatedAt       time.Time `json:"-"`
+
+	PushEnabled     bool       `gorm:"not null;default:false" json:"push_enabled"` // Отправлять локальные изменения списка на Shikimori
+	LastImportAt    *time.Time `json:"last_import_at"`
+	ImportStartedAt *time.Time `json:"-"` // Не пусто, пока идет импорт
+}
+
+// Направление и результат синхронизации с Shikimori
+const (
+	SyncDirectionImport = "import"
+	SyncDirectionPush   = "push"
+
+	SyncResultCreated  = "created"
+	SyncResultUpdated  = "updated"
+	SyncResultDeleted  = "deleted"
+	SyncResultSkipped  = "skipped"
+	SyncResultConflict = "conflict" // Запись на Shikimori новее, взята она
+	SyncResultFailed   = "failed"
+	SyncResultFinished = "finished" // Итог импорта целиком
+)
+
+// ShikimoriSyncLog - журнал синхронизации, показывается в профиле
+type ShikimoriSyncLog struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index" json:"-"`
+	Direction string    `gorm:"not null" json:"direction"`
+	AnimeID   string    `json:"anime_id,omitempty"`
+	Result    string    `gorm:"not null" json:"result"`
+	Message   string    `json:"message,omitempty"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+}
+
+// Назначение OAuth state
+const (
+	OAuthPurposeLogin = "login"
+	OAuthPurposeLink  = "link"
+)
+
+// OAuthState - одноразовый state для защиты OAuth-колбэка от CSRF.
+// Хранится только SHA-256 хеш значения, выданного клиенту.
+type OAuthState struct {
+	ID        string     `gorm:"primaryKey"`
+	Purpose   string     `gorm:"not null"`
+	UserID    *uuid.UUID `gorm:"type:uuid"` // Для привязки к существующему аккаунту
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
+
+// Статусы фонового импорта списка
+const (
+	ImportStatusPending   = "pending"
+	ImportStatusRunning   = "running"
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// ListImportJob - фоновый импорт списка из файла другого трекера
+type ListImportJob struct {
+	ID         uuid.UUID           `gorm:"type:uuid;primaryKey" json:"id"`
+	UserID     uuid.UUID           `gorm:"type:uuid;not null;index" json:"-"`
+	Format     string              `gorm:"not null" json:"format"`
+	Status     string              `gorm:"not null" json:"status"`
+	Total      int                 `json:"total"`
+	Processed  int                 `json:"processed"`
+	Imported   int                 `json:"imported"`
+	Skipped    int                 `json:"skipped"` // Уже есть в списке
+	Unmatched  []UnmatchedListItem `gorm:"serializer:json;type:jsonb" json:"unmatched"`
+	Error      string              `json:"error,omitempty"`
+	CreatedAt  time.Time           `json:"created_at"`
+	UpdatedAt  time.Time           `json:"updated_at"`
+	FinishedAt *time.Time          `json:"finished_at"`
+}
+
+// UnmatchedListItem - запись файла, которую не удалось импортировать
+type UnmatchedListItem struct {
+	ExternalID string `json:"external_id"`
+	Title      string `json:"title"`
+	Reason     string `json:"reason"`
+}
+
+// TokenPair - access-токен и refresh-токен для продления сессии
+type TokenPair struct {
+	AccessToken  string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"` // Время жизни access-токена в секундах
 }
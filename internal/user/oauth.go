@@ -0,0 +1,207 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrShikimoriOAuthDisabled   = errors.New("shikimori login is not configured")
+	ErrShikimoriLinkedElsewhere = errors.New("this shikimori account is linked to another user")
+	ErrLastLoginMethod          = errors.New("set an email and password before unlinking shikimori")
+)
+
+const oauthStateTTL = 10 * time.Minute
+
+// ShikimoriAuthURL создает одноразовый state и возвращает адрес авторизации.
+// Для привязки state запоминает пользователя, начавшего процесс.
+func (s *service) ShikimoriAuthURL(purpose, userID string) (string, error) {
+	if !s.oauth.Configured() {
+		return "", ErrShikimoriOAuthDisabled
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	state := base64.RawURLEncoding.EncodeToString(raw)
+
+	stored := &OAuthState{
+		ID:        hashToken(state),
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(oauthStateTTL),
+	}
+	if purpose == OAuthPurposeLink {
+		id, err := uuid.Parse(userID)
+		if err != nil {
+			return "", ErrUserNotFound
+		}
+		stored.UserID = &id
+	}
+	if err := s.repo.CreateOAuthState(stored); err != nil {
+		return "", err
+	}
+	return s.oauth.AuthorizeURL(state), nil
+}
+
+// LoginWithShikimori входит в аккаунт, к которому привязан пользователь
+// Shikimori, или создает новый аккаунт без email и пароля
+func (s *service) LoginWithShikimori(ctx context.Context, code, state string, meta SessionMeta) (*TokenPair, error) {
+	if _, err := s.repo.ConsumeOAuthState(hashToken(state), OAuthPurposeLogin); err != nil {
+		return nil, err
+	}
+	token, profile, err := s.exchangeShikimoriCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	account, err := s.repo.FindShikimoriAccount(profile.ID)
+	switch {
+	case err == nil:
+		if err := s.storeShikimoriTokens(account.UserID, token); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, ErrShikimoriNotLinked):
+		account, err = newShikimoriAccount(token, profile)
+		if err != nil {
+			return nil, err
+		}
+		user := &User{ID: uuid.New()}
+		if err := s.repo.CreateShikimoriUser(user, account); err != nil {
+			return nil, err
+		}
+		log.Printf("Created user %s for shikimori user %d", user.ID, profile.ID)
+	default:
+		return nil, err
+	}
+
+	user, err := s.repo.FindByID(account.UserID.String())
+	if err != nil {
+		return nil, err
+	}
+	return s.startSession(user, meta)
+}
+
+// LinkShikimori привязывает Shikimori к аккаунту, начавшему привязку
+func (s *service) LinkShikimori(ctx context.Context, userID, code, state string) (*ShikimoriAccount, error) {
+	stored, err := s.repo.ConsumeOAuthState(hashToken(state), OAuthPurposeLink)
+	if err != nil {
+		return nil, err
+	}
+	if stored.UserID == nil || stored.UserID.String() != userID {
+		return nil, ErrInvalidOAuthState
+	}
+
+	token, profile, err := s.exchangeShikimoriCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, err := s.repo.FindShikimoriAccount(profile.ID)
+	if err == nil && existing.UserID != *stored.UserID {
+		return nil, ErrShikimoriLinkedElsewhere
+	}
+	if err != nil && !errors.Is(err, ErrShikimoriNotLinked) {
+		return nil, err
+	}
+
+	account, err := newShikimoriAccount(token, profile)
+	if err != nil {
+		return nil, err
+	}
+	account.UserID = *stored.UserID
+	if err := s.repo.SaveShikimoriAccount(account); err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+func (s *service) GetShikimoriAccount(userID string) (*ShikimoriAccount, error) {
+	return s.repo.GetShikimoriAccount(userID)
+}
+
+// UnlinkShikimori отвязывает Shikimori, если у пользователя остается
+// другой способ входа
+func (s *service) UnlinkShikimori(userID string) error {
+	user, err := s.repo.FindByID(userID)
+	if err != nil {
+		return ErrUserNotFound
+	}
+	if user.Email == "" || user.Password == "" {
+		return ErrLastLoginMethod
+	}
+	return s.repo.DeleteShikimoriAccount(userID)
+}
+
+// shikimoriAccessToken возвращает действующий токен Shikimori пользователя,
+// при необходимости обновляя его
+func (s *service) shikimoriAccessToken(ctx context.Context, account *ShikimoriAccount) (string, error) {
+	if time.Until(account.TokenExpiresAt) > time.Minute {
+		return decryptSecret(account.AccessToken)
+	}
+
+	refreshToken, err := decryptSecret(account.RefreshToken)
+	if err != nil {
+		return "", err
+	}
+	token, err := s.oauth.RefreshToken(ctx, refreshToken)
+	if err != nil {
+		return "", err
+	}
+	if err := s.storeShikimoriTokens(account.UserID, token); err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+func (s *service) exchangeShikimoriCode(ctx context.Context, code string) (*shikimori.OAuthToken, *shikimori.OAuthUser, error) {
+	if !s.oauth.Configured() {
+		return nil, nil, ErrShikimoriOAuthDisabled
+	}
+	token, err := s.oauth.Exchange(ctx, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	profile, err := s.oauth.WhoAmI(ctx, token.AccessToken)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, profile, nil
+}
+
+func (s *service) storeShikimoriTokens(userID uuid.UUID, token *shikimori.OAuthToken) error {
+	access, err := encryptSecret(token.AccessToken)
+	if err != nil {
+		return err
+	}
+	refresh, err := encryptSecret(token.RefreshToken)
+	if err != nil {
+		return err
+	}
+	return s.repo.UpdateShikimoriTokens(userID, access, refresh, token.ExpiresAt())
+}
+
+func newShikimoriAccount(token *shikimori.OAuthToken, profile *shikimori.OAuthUser) (*ShikimoriAccount, error) {
+	access, err := encryptSecret(token.AccessToken)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := encryptSecret(token.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &ShikimoriAccount{
+		ShikimoriUserID: profile.ID,
+		Nickname:        profile.Nickname,
+		AccessToken:     access,
+		RefreshToken:    refresh,
+		TokenExpiresAt:  token.ExpiresAt(),
+	}, nil
+}
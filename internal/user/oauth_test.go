@@ -0,0 +1,309 @@
+package user
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/Zipklas/anime-site-backend/internal/shikimori/shikimoritest"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// oauthRepo хранит в памяти только то, что нужно входу и привязке через Shikimori
+type oauthRepo struct {
+	Repository
+
+	mu       sync.Mutex
+	users    map[uuid.UUID]*User
+	states   map[string]OAuthState
+	accounts map[uuid.UUID]ShikimoriAccount
+	sessions int
+}
+
+func newOAuthRepo() *oauthRepo {
+	return &oauthRepo{
+		users:    map[uuid.UUID]*User{},
+		states:   map[string]OAuthState{},
+		accounts: map[uuid.UUID]ShikimoriAccount{},
+	}
+}
+
+func (r *oauthRepo) FindByID(userID string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, _ := uuid.Parse(userID)
+	user, ok := r.users[id]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return user, nil
+}
+
+func (r *oauthRepo) CreateSession(*Session, *RefreshToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sessions++
+	return nil
+}
+
+func (r *oauthRepo) CreateOAuthState(state *OAuthState) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.states[state.ID] = *state
+	return nil
+}
+
+func (r *oauthRepo) ConsumeOAuthState(stateHash string, purpose string) (*OAuthState, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	state, ok := r.states[stateHash]
+	if !ok || state.Purpose != purpose || !state.ExpiresAt.After(time.Now()) {
+		return nil, ErrInvalidOAuthState
+	}
+	delete(r.states, stateHash)
+	return &state, nil
+}
+
+func (r *oauthRepo) FindShikimoriAccount(shikimoriUserID int64) (*ShikimoriAccount, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, account := range r.accounts {
+		if account.ShikimoriUserID == shikimoriUserID {
+			return &account, nil
+		}
+	}
+	return nil, ErrShikimoriNotLinked
+}
+
+func (r *oauthRepo) CreateShikimoriUser(user *User, account *ShikimoriAccount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.users[user.ID] = user
+	account.UserID = user.ID
+	r.accounts[user.ID] = *account
+	return nil
+}
+
+func (r *oauthRepo) SaveShikimoriAccount(account *ShikimoriAccount) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.accounts[account.UserID] = *account
+	return nil
+}
+
+func (r *oauthRepo) UpdateShikimoriTokens(userID uuid.UUID, accessToken, refreshToken string, expiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	account := r.accounts[userID]
+	account.AccessToken, account.RefreshToken, account.TokenExpiresAt = accessToken, refreshToken, expiresAt
+	r.accounts[userID] = account
+	return nil
+}
+
+func (r *oauthRepo) addUser(email string) *User {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user := &User{ID: uuid.New(), Email: email, Password: "hash", Role: auth.RoleUser}
+	r.users[user.ID] = user
+	return user
+}
+
+func newOAuthTestHandler(t *testing.T) (*Handler, *oauthRepo) {
+	t.Helper()
+	server := httptest.NewServer(shikimoritest.NewProvider(time.Hour))
+	t.Cleanup(server.Close)
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TOKEN_ENCRYPTION_KEY", base64.StdEncoding.EncodeToString(key))
+	t.Setenv("SHIKIMORI_OAUTH_URL", server.URL)
+	t.Setenv("SHIKIMORI_CLIENT_ID", "client")
+	t.Setenv("SHIKIMORI_CLIENT_SECRET", "secret")
+	t.Setenv("SHIKIMORI_REDIRECT_URL", "http://localhost/callback")
+	t.Setenv("SHIKIMORI_API_INTERVAL", "0s")
+
+	keys, err := auth.NewEphemeralKeySet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newOAuthRepo()
+	service := NewService(repo, nil, nil, auth.NewManager(keys, time.Minute), shikimori.NewOAuthClientFromEnv())
+	return NewHandler(service), repo
+}
+
+// call выполняет обработчик как запрос от user (nil - анонимный)
+func call(t *testing.T, handler echo.HandlerFunc, method string, body interface{}, user *User) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload string
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		payload = string(data)
+	}
+	req := httptest.NewRequest(method, "/", strings.NewReader(payload))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if user != nil {
+		req = req.WithContext(auth.WithUser(req.Context(), &auth.CurrentUser{ID: user.ID, Email: user.Email, Role: user.Role}))
+	}
+	rec := httptest.NewRecorder()
+	if err := handler(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatalf("handler error: %v", err)
+	}
+	return rec
+}
+
+// authorize получает у провайдера code для shikimoriUserID по адресу из urlHandler
+func authorize(t *testing.T, urlHandler echo.HandlerFunc, method string, user *User, shikimoriUserID int64) map[string]string {
+	t.Helper()
+	rec := call(t, urlHandler, method, nil, user)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("auth url: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		URL string `json:"url"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	code, state, err := shikimoritest.Authorize(resp.URL, shikimoriUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{"code": code, "state": state}
+}
+
+func TestShikimoriLoginCallback(t *testing.T) {
+	h, repo := newOAuthTestHandler(t)
+
+	login := func() TokenPair {
+		callback := authorize(t, h.ShikimoriLoginURL, http.MethodGet, nil, 42)
+		rec := call(t, h.ShikimoriLogin, http.MethodPost, callback, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("callback: %d %s", rec.Code, rec.Body)
+		}
+		var tokens TokenPair
+		if err := json.Unmarshal(rec.Body.Bytes(), &tokens); err != nil {
+			t.Fatal(err)
+		}
+		if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+			t.Fatalf("empty token pair: %s", rec.Body)
+		}
+		return tokens
+	}
+
+	// Первый вход создает аккаунт, повторный входит в него же
+	login()
+	login()
+
+	if len(repo.users) != 1 || len(repo.accounts) != 1 {
+		t.Fatalf("users = %d, accounts = %d; want one of each", len(repo.users), len(repo.accounts))
+	}
+	for _, account := range repo.accounts {
+		if account.ShikimoriUserID != 42 {
+			t.Errorf("linked shikimori user %d, want 42", account.ShikimoriUserID)
+		}
+		access, err := decryptSecret(account.AccessToken)
+		if err != nil || access == "" {
+			t.Errorf("stored access token is not decryptable: %v", err)
+		}
+	}
+	if repo.sessions != 2 {
+		t.Errorf("sessions = %d, want 2", repo.sessions)
+	}
+}
+
+func TestShikimoriLoginCallbackRejectsBadState(t *testing.T) {
+	h, repo := newOAuthTestHandler(t)
+
+	callback := authorize(t, h.ShikimoriLoginURL, http.MethodGet, nil, 42)
+	callback["state"] = "forged"
+	if rec := call(t, h.ShikimoriLogin, http.MethodPost, callback, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("forged state: %d, want 400", rec.Code)
+	}
+
+	// state одноразовый: повтор колбэка отклоняется
+	callback = authorize(t, h.ShikimoriLoginURL, http.MethodGet, nil, 42)
+	call(t, h.ShikimoriLogin, http.MethodPost, callback, nil)
+	if rec := call(t, h.ShikimoriLogin, http.MethodPost, callback, nil); rec.Code != http.StatusBadRequest {
+		t.Errorf("replayed state: %d, want 400", rec.Code)
+	}
+	if len(repo.users) != 1 {
+		t.Errorf("users = %d, want 1", len(repo.users))
+	}
+}
+
+func TestShikimoriLoginCallbackRejectsBadCode(t *testing.T) {
+	h, repo := newOAuthTestHandler(t)
+
+	callback := authorize(t, h.ShikimoriLoginURL, http.MethodGet, nil, 42)
+	callback["code"] = "invalid"
+	if rec := call(t, h.ShikimoriLogin, http.MethodPost, callback, nil); rec.Code != http.StatusBadGateway {
+		t.Errorf("invalid code: %d, want 502", rec.Code)
+	}
+	if len(repo.users) != 0 {
+		t.Errorf("users = %d, want 0", len(repo.users))
+	}
+}
+
+func TestLinkShikimori(t *testing.T) {
+	h, repo := newOAuthTestHandler(t)
+	owner := repo.addUser("owner@example.com")
+	other := repo.addUser("other@example.com")
+
+	callback := authorize(t, h.ShikimoriLinkURL, http.MethodPost, owner, 42)
+	rec := call(t, h.LinkShikimori, http.MethodPost, callback, owner)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link: %d %s", rec.Code, rec.Body)
+	}
+	if account := repo.accounts[owner.ID]; account.ShikimoriUserID != 42 {
+		t.Errorf("owner linked to %d, want 42", account.ShikimoriUserID)
+	}
+
+	// Тот же аккаунт Shikimori нельзя привязать ко второму пользователю
+	callback = authorize(t, h.ShikimoriLinkURL, http.MethodPost, other, 42)
+	if rec := call(t, h.LinkShikimori, http.MethodPost, callback, other); rec.Code != http.StatusConflict {
+		t.Errorf("link to second user: %d, want 409", rec.Code)
+	}
+
+	// Повторная привязка владельцем допустима
+	callback = authorize(t, h.ShikimoriLinkURL, http.MethodPost, owner, 42)
+	if rec := call(t, h.LinkShikimori, http.MethodPost, callback, owner); rec.Code != http.StatusOK {
+		t.Errorf("relink: %d, want 200", rec.Code)
+	}
+	if len(repo.accounts) != 1 {
+		t.Errorf("accounts = %d, want 1", len(repo.accounts))
+	}
+}
+
+func TestLinkShikimoriRejectsForeignState(t *testing.T) {
+	h, repo := newOAuthTestHandler(t)
+	owner := repo.addUser("owner@example.com")
+	attacker := repo.addUser("attacker@example.com")
+
+	// state, выданный одному пользователю, не подходит другому
+	callback := authorize(t, h.ShikimoriLinkURL, http.MethodPost, owner, 42)
+	if rec := call(t, h.LinkShikimori, http.MethodPost, callback, attacker); rec.Code != http.StatusBadRequest {
+		t.Errorf("foreign state: %d, want 400", rec.Code)
+	}
+	// и state входа не подходит для привязки
+	callback = authorize(t, h.ShikimoriLoginURL, http.MethodGet, nil, 42)
+	if rec := call(t, h.LinkShikimori, http.MethodPost, callback, owner); rec.Code != http.StatusBadRequest {
+		t.Errorf("login state used for link: %d, want 400", rec.Code)
+	}
+	if len(repo.accounts) != 0 {
+		t.Errorf("accounts = %d, want 0", len(repo.accounts))
+	}
+}
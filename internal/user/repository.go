@@ -1,10 +1,30 @@
 package user
 
 import (
-	"github.com/lib/pq"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrEntryNotFound       = errors.New("anime is not in the list")
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected, session revoked")
+	ErrInvalidOAuthState   = errors.New("invalid or expired oauth state")
+	ErrShikimoriNotLinked  = errors.New("shikimori account is not linked")
+	ErrImportNotFound      = errors.New("import not found")
 )
 
+// Избранное без явной позиции (добавленное до сортировки) идет в конце
+const favoriteOrder = "favorite_position NULLS LAST, created_at, anime_id"
+
 type Repository interface {
 	Create(user *User) error
 	FindByEmail(email string) (*User, error)
@@ -12,6 +32,57 @@ type Repository interface {
 
 	UpdateWatched(userID string, animeID string) error
 	UpdateFavorites(userID string, animeID string) error
+
+	GetEntries(userID string, status string) ([]AnimeEntry, error)
+	GetEntry(userID string, animeID string) (*AnimeEntry, error)
+	SaveEntry(userID string, animeID string, update AnimeEntryUpdate) (*AnimeEntry, error)
+	DeleteEntry(userID string, animeID string) error
+	GetWatchedIDs(userID string) ([]string, error)
+	GetFavoriteIDs(userID string) ([]string, error)
+
+	RemoveWatched(userID string, animeID string) error
+	RemoveFavorite(userID string, animeID string) error
+	ReorderFavorites(userID string, animeIDs []string) error
+
+	CreateSession(session *Session, token *RefreshToken) error
+	RotateRefreshToken(tokenHash string, next *RefreshToken, meta SessionMeta) (*Session, error)
+	GetActiveSession(sessionID string) (*Session, error)
+	TouchSession(sessionID string, at time.Time) error
+	ListSessions(userID string) ([]Session, error)
+	RevokeSession(userID string, sessionID string) error
+	RevokeAllSessions(userID string) error
+
+	CreateUserToken(token *UserToken) error
+	VerifyEmail(tokenID uuid.UUID) error
+	ResetPassword(tokenID uuid.UUID, passwordHash string) error
+
+	GetRole(userID string) (string, error)
+	SetRole(userID string, role string) error
+	SetUsername(userID string, username string) error
+	ListUsers(role string, limit, offset int) ([]User, error)
+	PromoteAdmins(emails []string) error
+
+	CreateOAuthState(state *OAuthState) error
+	ConsumeOAuthState(stateHash string, purpose string) (*OAuthState, error)
+	GetShikimoriAccount(userID string) (*ShikimoriAccount, error)
+	FindShikimoriAccount(shikimoriUserID int64) (*ShikimoriAccount, error)
+	CreateShikimoriUser(user *User, account *ShikimoriAccount) error
+	SaveShikimoriAccount(account *ShikimoriAccount) error
+	UpdateShikimoriTokens(userID uuid.UUID, accessToken, refreshToken string, expiresAt time.Time) error
+	DeleteShikimoriAccount(userID string) error
+
+	SetShikimoriPush(userID string, enabled bool) error
+	StartShikimoriImport(userID string) (bool, error)
+	FinishShikimoriImport(userID string, succeeded bool) error
+	ApplyShikimoriRate(userID string, animeID string, fields map[string]interface{}) (bool, error)
+	UpdateEntrySync(userID string, animeID string, fields map[string]interface{}) error
+	AddSyncLogs(logs []ShikimoriSyncLog) error
+	ListSyncLogs(userID string, limit int) ([]ShikimoriSyncLog, error)
+
+	CreateImportJob(job *ListImportJob) error
+	UpdateImportJob(job *ListImportJob) error
+	GetImportJob(userID string, jobID string) (*ListImportJob, error)
+	FailUnfinishedImportJobs(reason string) (int64, error)
 }
 type repository struct {
 	db *gorm.DB
@@ -25,7 +96,8 @@ func (r *repository) Create(user *User) error {
 }
 func (r *repository) FindByEmail(email string) (*User, error) {
 	var user User
-	if err := r.db.First(&user, "email = ?", email).Error; err != nil {
+	// lower() - для адресов, сохраненных до приведения к нижнему регистру
+	if err := r.db.First(&user, "lower(email) = ?", strings.ToLower(email)).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
@@ -37,52 +109,783 @@ func (r *repository) FindByID(userID string) (*User, error) {
 	if result.Error != nil {
 		return nil, result.Error
 	}
+	return &user, nil
+}
+
+// UpdateWatched отмечает аниме как просмотренное
+func (r *repository) UpdateWatched(userID string, animeID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		entry, err := findEntryForUpdate(tx, userID, animeID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			entry, err = newEntry(userID, animeID)
+			if err != nil {
+				return err
+			}
+			entry.Status = StatusCompleted
+			return tx.Create(entry).Error
+		}
+
+		// Уже просмотрено, ничего не делаем
+		if entry.Status == StatusCompleted || entry.Status == StatusRewatching {
+			return nil
+		}
+		return tx.Model(entry).Update("status", StatusCompleted).Error
+	})
+}
+
+// UpdateFavorites добавляет аниме в конец избранного
+func (r *repository) UpdateFavorites(userID string, animeID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		entry, err := findEntryForUpdate(tx, userID, animeID)
+		if err != nil {
+			return err
+		}
+		if err := numberFavorites(tx, userID); err != nil {
+			return err
+		}
+
+		var lastPosition int
+		if err := tx.Model(&AnimeEntry{}).
+			Where("user_id = ? AND is_favorite", userID).
+			Select("COALESCE(MAX(favorite_position), 0)").
+			Scan(&lastPosition).Error; err != nil {
+			return err
+		}
+		position := new(int)
+		*position = lastPosition + 1
+		if entry == nil {
+			entry, err = newEntry(userID, animeID)
+			if err != nil {
+				return err
+			}
+			entry.IsFavorite = true
+			entry.FavoritePosition = position
+			return tx.Create(entry).Error
+		}
 
-	// Инициализация массивов, если они nil
-	if user.WatchedAnimeIDs == nil {
-		user.WatchedAnimeIDs = pq.StringArray{}
+		if entry.IsFavorite {
+			return nil
+		}
+		return tx.Model(entry).Updates(map[string]interface{}{
+			"is_favorite":       true,
+			"favorite_position": position,
+		}).Error
+	})
+}
+
+// RemoveWatched убирает аниме из просмотренных. Если аниме в избранном,
+// запись остается без статуса, иначе удаляется целиком.
+func (r *repository) RemoveWatched(userID string, animeID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		entry, err := findEntryForUpdate(tx, userID, animeID)
+		if err != nil {
+			return err
+		}
+		if entry == nil || (entry.Status != StatusCompleted && entry.Status != StatusRewatching) {
+			return ErrEntryNotFound
+		}
+
+		if entry.IsFavorite {
+			return tx.Model(entry).Update("status", "").Error
+		}
+		return tx.Delete(entry).Error
+	})
+}
+
+// RemoveFavorite убирает аниме из избранного. Запись без статуса удаляется.
+func (r *repository) RemoveFavorite(userID string, animeID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		entry, err := findEntryForUpdate(tx, userID, animeID)
+		if err != nil {
+			return err
+		}
+		if entry == nil || !entry.IsFavorite {
+			return ErrEntryNotFound
+		}
+
+		if entry.Status == "" {
+			return tx.Delete(entry).Error
+		}
+		return tx.Model(entry).Updates(map[string]interface{}{
+			"is_favorite":       false,
+			"favorite_position": nil,
+		}).Error
+	})
+}
+
+// ReorderFavorites ставит переданные аниме в начало избранного в указанном
+// порядке, остальные избранные сохраняют прежний порядок после них.
+func (r *repository) ReorderFavorites(userID string, animeIDs []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var favorites []AnimeEntry
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("user_id = ? AND is_favorite", userID).
+			Order(favoriteOrder).
+			Find(&favorites).Error; err != nil {
+			return err
+		}
+
+		byAnimeID := make(map[string]*AnimeEntry, len(favorites))
+		for i := range favorites {
+			byAnimeID[favorites[i].AnimeID] = &favorites[i]
+		}
+
+		ordered := make([]*AnimeEntry, 0, len(favorites))
+		seen := make(map[string]bool, len(animeIDs))
+		for _, animeID := range animeIDs {
+			entry, ok := byAnimeID[animeID]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrEntryNotFound, animeID)
+			}
+			if seen[animeID] {
+				return fmt.Errorf("%w: duplicate anime_id %s", ErrInvalidListEntry, animeID)
+			}
+			seen[animeID] = true
+			ordered = append(ordered, entry)
+		}
+		for i := range favorites {
+			if !seen[favorites[i].AnimeID] {
+				ordered = append(ordered, &favorites[i])
+			}
+		}
+
+		for i, entry := range ordered {
+			if err := tx.Model(entry).Update("favorite_position", i+1).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *repository) GetEntries(userID string, status string) ([]AnimeEntry, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
 	}
-	if user.FavoriteAnimeIDs == nil {
-		user.FavoriteAnimeIDs = pq.StringArray{}
+
+	var entries []AnimeEntry
+	if err := query.Order("created_at, anime_id").Find(&entries).Error; err != nil {
+		return nil, err
 	}
+	return entries, nil
+}
 
-	return &user, nil
+func (r *repository) GetEntry(userID string, animeID string) (*AnimeEntry, error) {
+	var entry AnimeEntry
+	err := r.db.Where("user_id = ? AND anime_id = ?", userID, animeID).First(&entry).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrEntryNotFound
+		}
+		return nil, err
+	}
+	return &entry, nil
 }
-func (r *repository) UpdateWatched(userID string, animeID string) error {
+
+// SaveEntry создает или обновляет запись списка, сохраняя флаг избранного
+func (r *repository) SaveEntry(userID string, animeID string, update AnimeEntryUpdate) (*AnimeEntry, error) {
+	var saved *AnimeEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		entry, err := findEntryForUpdate(tx, userID, animeID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			entry, err = newEntry(userID, animeID)
+			if err != nil {
+				return err
+			}
+			applyEntryUpdate(entry, update)
+			saved = entry
+			return tx.Create(entry).Error
+		}
+
+		applyEntryUpdate(entry, update)
+		saved = entry
+		return tx.Model(entry).Select(
+			"status", "score", "episodes_watched", "rewatch_count",
+			"started_at", "finished_at", "notes", "updated_at",
+		).Updates(entry).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (r *repository) DeleteEntry(userID string, animeID string) error {
+	result := r.db.Where("user_id = ? AND anime_id = ?", userID, animeID).Delete(&AnimeEntry{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrEntryNotFound
+	}
+	return nil
+}
+
+func (r *repository) GetWatchedIDs(userID string) ([]string, error) {
+	ids := []string{}
+	err := r.db.Model(&AnimeEntry{}).
+		Where("user_id = ? AND status IN ?", userID, []string{StatusCompleted, StatusRewatching}).
+		Order("created_at, anime_id").
+		Pluck("anime_id", &ids).Error
+	return ids, err
+}
+
+func (r *repository) GetFavoriteIDs(userID string) ([]string, error) {
+	ids := []string{}
+	err := r.db.Model(&AnimeEntry{}).
+		Where("user_id = ? AND is_favorite", userID).
+		Order(favoriteOrder).
+		Pluck("anime_id", &ids).Error
+	return ids, err
+}
+
+// findEntryForUpdate блокирует запись до конца транзакции, nil - записи нет
+// numberFavorites дает места в избранном записям без favorite_position
+// (добавленным до его появления) после остальных в порядке favoriteOrder,
+// чтобы новое избранное не оказалось перед ними
+func numberFavorites(tx *gorm.DB, userID string) error {
+	return tx.Exec(`WITH unnumbered AS (
+	SELECT id, ROW_NUMBER() OVER (ORDER BY created_at, anime_id) AS n
+	FROM user_anime_entries
+	WHERE user_id = @user AND is_favorite AND favorite_position IS NULL
+), last AS (
+	SELECT COALESCE(MAX(favorite_position), 0) AS position
+	FROM user_anime_entries
+	WHERE user_id = @user AND is_favorite
+)
+UPDATE user_anime_entries SET favorite_position = last.position + unnumbered.n
+FROM unnumbered, last
+WHERE user_anime_entries.id = unnumbered.id`, map[string]interface{}{"user": userID}).Error
+}
+
+func findEntryForUpdate(tx *gorm.DB, userID string, animeID string) (*AnimeEntry, error) {
+	var entry AnimeEntry
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ? AND anime_id = ?", userID, animeID).
+		First(&entry).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &entry, nil
+}
+
+func newEntry(userID string, animeID string) (*AnimeEntry, error) {
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	return &AnimeEntry{
+		ID:      uuid.New(),
+		UserID:  uid,
+		AnimeID: animeID,
+	}, nil
+}
+
+func applyEntryUpdate(entry *AnimeEntry, update AnimeEntryUpdate) {
+	entry.Status = update.Status
+	entry.Score = update.Score
+	entry.EpisodesWatched = update.EpisodesWatched
+	entry.RewatchCount = update.RewatchCount
+	entry.StartedAt = update.StartedAt
+	entry.FinishedAt = update.FinishedAt
+	entry.Notes = update.Notes
+}
+
+func (r *repository) CreateSession(session *Session, token *RefreshToken) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
-		var user User
-		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		if err := tx.Create(session).Error; err != nil {
 			return err
 		}
+		token.SessionID = session.ID
+		return tx.Create(token).Error
+	})
+}
 
-		// Проверяем, есть ли уже этот animeID в массиве
-		for _, id := range user.WatchedAnimeIDs {
-			if id == animeID {
-				return nil // Уже существует, ничего не делаем
+// RotateRefreshToken гасит предъявленный refresh-токен и сохраняет следующий.
+// Повторное предъявление уже использованного токена отзывает всю сессию.
+func (r *repository) RotateRefreshToken(tokenHash string, next *RefreshToken, meta SessionMeta) (*Session, error) {
+	var (
+		session Session
+		reused  bool
+	)
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var current RefreshToken
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("token_hash = ?", tokenHash).
+			First(&current).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrInvalidRefreshToken
 			}
+			return err
+		}
+
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ?", current.SessionID).
+			First(&session).Error; err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if session.RevokedAt != nil || now.After(session.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		// Токен уже был обменян - вероятно, его украли. Отзываем всё семейство.
+		if current.UsedAt != nil {
+			reused = true
+			return tx.Model(&session).Update("revoked_at", now).Error
+		}
+
+		if now.After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err := tx.Model(&current).Update("used_at", now).Error; err != nil {
+			return err
+		}
+
+		next.SessionID = session.ID
+		if err := tx.Create(next).Error; err != nil {
+			return err
 		}
 
-		// Обновляем массив
-		user.WatchedAnimeIDs = append(user.WatchedAnimeIDs, animeID)
-		return tx.Save(&user).Error
+		session.LastSeenAt = now
+		session.UserAgent = meta.UserAgent
+		session.IP = meta.IP
+		return tx.Model(&session).Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"user_agent":   meta.UserAgent,
+			"ip":           meta.IP,
+		}).Error
 	})
+	if err != nil {
+		return nil, err
+	}
+	if reused {
+		return nil, ErrRefreshTokenReused
+	}
+	return &session, nil
 }
 
-func (r *repository) UpdateFavorites(userID string, animeID string) error {
+func (r *repository) GetActiveSession(sessionID string) (*Session, error) {
+	var session Session
+	err := r.db.Where("id = ? AND revoked_at IS NULL AND expires_at > ?", sessionID, time.Now()).
+		First(&session).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *repository) TouchSession(sessionID string, at time.Time) error {
+	return r.db.Model(&Session{}).Where("id = ?", sessionID).Update("last_seen_at", at).Error
+}
+
+func (r *repository) ListSessions(userID string) ([]Session, error) {
+	sessions := []Session{}
+	err := r.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at desc").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+func (r *repository) RevokeSession(userID string, sessionID string) error {
+	result := r.db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", sessionID, userID).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+func (r *repository) RevokeAllSessions(userID string) error {
+	return r.db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// CreateUserToken сохраняет новый токен и гасит выданные ранее токены того же назначения
+func (r *repository) CreateUserToken(token *UserToken) error {
 	return r.db.Transaction(func(tx *gorm.DB) error {
-		var user User
-		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+		if err := tx.Model(&UserToken{}).
+			Where("user_id = ? AND purpose = ? AND used_at IS NULL", token.UserID, token.Purpose).
+			Update("used_at", time.Now()).Error; err != nil {
 			return err
 		}
+		return tx.Create(token).Error
+	})
+}
+
+func (r *repository) VerifyEmail(tokenID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, tokenID, TokenPurposeVerifyEmail)
+		if err != nil {
+			return err
+		}
+		return tx.Model(&User{}).
+			Where("id = ? AND email_verified_at IS NULL", token.UserID).
+			Update("email_verified_at", time.Now()).Error
+	})
+}
 
-		// Проверяем на существование
-		for _, id := range user.FavoriteAnimeIDs {
-			if id == animeID {
-				return nil
+// ResetPassword меняет пароль и завершает все сессии пользователя.
+// Переход по ссылке из письма заодно подтверждает email.
+func (r *repository) ResetPassword(tokenID uuid.UUID, passwordHash string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		token, err := consumeUserToken(tx, tokenID, TokenPurposeResetPassword)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		if err := tx.Model(&User{}).Where("id = ?", token.UserID).
+			Update("password", passwordHash).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&User{}).
+			Where("id = ? AND email_verified_at IS NULL", token.UserID).
+			Update("email_verified_at", now).Error; err != nil {
+			return err
+		}
+		return tx.Model(&Session{}).
+			Where("user_id = ? AND revoked_at IS NULL", token.UserID).
+			Update("revoked_at", now).Error
+	})
+}
+
+// consumeUserToken атомарно помечает токен использованным
+func consumeUserToken(tx *gorm.DB, tokenID uuid.UUID, purpose string) (*UserToken, error) {
+	var token UserToken
+	result := tx.Model(&token).
+		Clauses(clause.Returning{}).
+		Where("id = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", tokenID, purpose, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidUserToken
+	}
+	return &token, nil
+}
+
+func (r *repository) GetRole(userID string) (string, error) {
+	var roles []string
+	if err := r.db.Model(&User{}).Where("id = ?", userID).Pluck("role", &roles).Error; err != nil {
+		return "", err
+	}
+	if len(roles) == 0 {
+		return "", gorm.ErrRecordNotFound
+	}
+	return roles[0], nil
+}
+
+func (r *repository) SetRole(userID string, role string) error {
+	result := r.db.Model(&User{}).Where("id = ?", userID).Update("role", role)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// SetUsername сохраняет имя, если оно не занято другим пользователем
+func (r *repository) SetUsername(userID string, username string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var taken int64
+		err := tx.Model(&User{}).Where("username = ? AND id <> ?", username, userID).Count(&taken).Error
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			return ErrUsernameTaken
+		}
+		// Проверка выше не защищает от параллельного запроса с тем же
+		// именем, его отсекает уникальный индекс
+		err = tx.Model(&User{}).Where("id = ?", userID).Update("username", username).Error
+		if duplicateKey(tx, err) {
+			return ErrUsernameTaken
+		}
+		return err
+	})
+}
+
+// duplicateKey - err означает нарушение уникального индекса (23505 в Postgres)
+func duplicateKey(db *gorm.DB, err error) bool {
+	if err == nil {
+		return false
+	}
+	if translator, ok := db.Dialector.(gorm.ErrorTranslator); ok {
+		err = translator.Translate(err)
+	}
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+func (r *repository) ListUsers(role string, limit, offset int) ([]User, error) {
+	query := r.db.Model(&User{})
+	if role != "" {
+		query = query.Where("role = ?", role)
+	}
+
+	users := []User{}
+	err := query.Order("created_at").Limit(limit).Offset(offset).Find(&users).Error
+	return users, err
+}
+
+// PromoteAdmins выдает роль администратора аккаунтам из ADMIN_EMAILS
+func (r *repository) PromoteAdmins(emails []string) error {
+	var cleaned []string
+	for _, email := range emails {
+		if email = strings.TrimSpace(email); email != "" {
+			cleaned = append(cleaned, email)
+		}
+	}
+	if len(cleaned) == 0 {
+		return nil
+	}
+	return r.db.Model(&User{}).
+		Where("email IN ? AND role <> ?", cleaned, auth.RoleAdmin).
+		Update("role", auth.RoleAdmin).Error
+}
+
+func (r *repository) CreateOAuthState(state *OAuthState) error {
+	// Заодно чистим просроченные state
+	if err := r.db.Where("expires_at < ?", time.Now()).Delete(&OAuthState{}).Error; err != nil {
+		return err
+	}
+	return r.db.Create(state).Error
+}
+
+// ConsumeOAuthState атомарно удаляет state, так что повторный колбэк не пройдет
+func (r *repository) ConsumeOAuthState(stateHash string, purpose string) (*OAuthState, error) {
+	var state OAuthState
+	result := r.db.Clauses(clause.Returning{}).
+		Where("id = ? AND purpose = ? AND expires_at > ?", stateHash, purpose, time.Now()).
+		Delete(&state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInvalidOAuthState
+	}
+	return &state, nil
+}
+
+func (r *repository) GetShikimoriAccount(userID string) (*ShikimoriAccount, error) {
+	var account ShikimoriAccount
+	if err := r.db.First(&account, "user_id = ?", userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShikimoriNotLinked
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *repository) FindShikimoriAccount(shikimoriUserID int64) (*ShikimoriAccount, error) {
+	var account ShikimoriAccount
+	if err := r.db.First(&account, "shikimori_user_id = ?", shikimoriUserID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrShikimoriNotLinked
+		}
+		return nil, err
+	}
+	return &account, nil
+}
+
+func (r *repository) CreateShikimoriUser(user *User, account *ShikimoriAccount) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		account.UserID = user.ID
+		return tx.Create(account).Error
+	})
+}
+
+// SaveShikimoriAccount привязывает аккаунт, заменяя ранее привязанный.
+// При смене аккаунта Shikimori связи записей списка сбрасываются.
+func (r *repository) SaveShikimoriAccount(account *ShikimoriAccount) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var previous ShikimoriAccount
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&previous, "user_id = ?", account.UserID).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil && previous.ShikimoriUserID != account.ShikimoriUserID {
+			if err := tx.Delete(&previous).Error; err != nil {
+				return err
+			}
+			if err := clearRateIDs(tx, account.UserID.String()); err != nil {
+				return err
+			}
+		}
+
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"shikimori_user_id", "nickname", "access_token", "refresh_token", "token_expires_at", "updated_at"}),
+		}).Create(account).Error
+	})
+}
+
+func (r *repository) UpdateShikimoriTokens(userID uuid.UUID, accessToken, refreshToken string, expiresAt time.Time) error {
+	return r.db.Model(&ShikimoriAccount{}).Where("user_id = ?", userID).Updates(map[string]interface{}{
+		"access_token":     accessToken,
+		"refresh_token":    refreshToken,
+		"token_expires_at": expiresAt,
+		"updated_at":       time.Now(),
+	}).Error
+}
+
+func (r *repository) DeleteShikimoriAccount(userID string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Where("user_id = ?", userID).Delete(&ShikimoriAccount{})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrShikimoriNotLinked
+		}
+		return clearRateIDs(tx, userID)
+	})
+}
+
+func clearRateIDs(tx *gorm.DB, userID string) error {
+	return tx.Model(&AnimeEntry{}).
+		Where("user_id = ? AND shikimori_rate_id IS NOT NULL", userID).
+		UpdateColumn("shikimori_rate_id", nil).Error
+}
+
+func (r *repository) SetShikimoriPush(userID string, enabled bool) error {
+	result := r.db.Model(&ShikimoriAccount{}).Where("user_id = ?", userID).Update("push_enabled", enabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrShikimoriNotLinked
+	}
+	return nil
+}
+
+// StartShikimoriImport отмечает начало импорта. false - импорт уже идет.
+// Метка старше часа считается оставшейся от упавшего процесса.
+func (r *repository) StartShikimoriImport(userID string) (bool, error) {
+	now := time.Now()
+	result := r.db.Model(&ShikimoriAccount{}).
+		Where("user_id = ? AND (import_started_at IS NULL OR import_started_at < ?)", userID, now.Add(-time.Hour)).
+		Update("import_started_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r *repository) FinishShikimoriImport(userID string, succeeded bool) error {
+	updates := map[string]interface{}{"import_started_at": nil}
+	if succeeded {
+		updates["last_import_at"] = time.Now()
+	}
+	return r.db.Model(&ShikimoriAccount{}).Where("user_id = ?", userID).Updates(updates).Error
+}
+
+// ApplyShikimoriRate записывает в список состояние записи с Shikimori,
+// создавая запись при необходимости. Возвращает true, если запись создана.
+// fields может содержать updated_at - время изменения на Shikimori.
+func (r *repository) ApplyShikimoriRate(userID string, animeID string, fields map[string]interface{}) (bool, error) {
+	created := false
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		entry, err := findEntryForUpdate(tx, userID, animeID)
+		if err != nil {
+			return err
+		}
+		if entry == nil {
+			entry, err = newEntry(userID, animeID)
+			if err != nil {
+				return err
 			}
+			if err := tx.Create(entry).Error; err != nil {
+				return err
+			}
+			created = true
 		}
+		return tx.Model(entry).Updates(fields).Error
+	})
+	return created, err
+}
+
+// UpdateEntrySync обновляет служебные поля синхронизации существующей записи
+func (r *repository) UpdateEntrySync(userID string, animeID string, fields map[string]interface{}) error {
+	return r.db.Model(&AnimeEntry{}).
+		Where("user_id = ? AND anime_id = ?", userID, animeID).
+		Updates(fields).Error
+}
 
-		user.FavoriteAnimeIDs = append(user.FavoriteAnimeIDs, animeID)
-		return tx.Save(&user).Error
+// AddSyncLogs сохраняет записи журнала, удаляя записи старше 30 дней
+func (r *repository) AddSyncLogs(logs []ShikimoriSyncLog) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND created_at < ?", logs[0].UserID, time.Now().AddDate(0, 0, -30)).
+			Delete(&ShikimoriSyncLog{}).Error; err != nil {
+			return err
+		}
+		return tx.CreateInBatches(logs, 500).Error
 	})
 }
+
+func (r *repository) ListSyncLogs(userID string, limit int) ([]ShikimoriSyncLog, error) {
+	logs := []ShikimoriSyncLog{}
+	err := r.db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&logs).Error
+	return logs, err
+}
+
+func (r *repository) CreateImportJob(job *ListImportJob) error {
+	return r.db.Create(job).Error
+}
+
+// UpdateImportJob сохраняет прогресс импорта
+func (r *repository) UpdateImportJob(job *ListImportJob) error {
+	return r.db.Model(job).Select(
+		"status", "total", "processed", "imported", "skipped", "unmatched", "error", "finished_at", "updated_at",
+	).Updates(job).Error
+}
+
+// FailUnfinishedImportJobs отмечает ошибкой все ожидающие и идущие импорты
+func (r *repository) FailUnfinishedImportJobs(reason string) (int64, error) {
+	result := r.db.Model(&ListImportJob{}).
+		Where("status IN ?", []string{ImportStatusPending, ImportStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":      ImportStatusFailed,
+			"error":       reason,
+			"finished_at": time.Now(),
+		})
+	return result.RowsAffected, result.Error
+}
+
+func (r *repository) GetImportJob(userID string, jobID string) (*ListImportJob, error) {
+	var job ListImportJob
+	if err := r.db.Where("id = ? AND user_id = ?", jobID, userID).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrImportNotFound
+		}
+		return nil, err
+	}
+	return &job, nil
+}
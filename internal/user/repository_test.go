@@ -0,0 +1,65 @@
+package user
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// pgError повторяет JSON-представление ошибки драйвера Postgres
+type pgError struct {
+	Code string
+}
+
+func (e *pgError) Error() string { return "pg error " + e.Code }
+
+func TestDuplicateKey(t *testing.T) {
+	db := &gorm.DB{Config: &gorm.Config{Dialector: postgres.New(postgres.Config{})}}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"unique violation", &pgError{Code: "23505"}, true},
+		{"foreign key violation", &pgError{Code: "23503"}, false},
+		{"other error", errors.New("connection reset"), false},
+		{"no error", nil, false},
+	}
+	for _, tt := range tests {
+		if got := duplicateKey(db, tt.err); got != tt.want {
+			t.Errorf("%s: duplicateKey = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNumberFavoritesOnlyTouchesUnnumberedFavorites(t *testing.T) {
+	db, err := gorm.Open(postgres.Open("host=localhost"), &gorm.Config{
+		DryRun:               true,
+		DisableAutomaticPing: true,
+		Logger:               logger.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sql string
+	db.Callback().Raw().After("gorm:raw").Register("test:capture", func(tx *gorm.DB) {
+		sql = tx.Statement.SQL.String()
+	})
+	if err := numberFavorites(db, "user-1"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"is_favorite AND favorite_position IS NULL",
+		"ROW_NUMBER() OVER (ORDER BY created_at, anime_id)",
+		"COALESCE(MAX(favorite_position), 0)",
+		"SET favorite_position = last.position + unnumbered.n",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("numberFavorites query has no %q:\n%s", want, sql)
+		}
+	}
+}
@@ -5,105 +5,297 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/mail"
 	"os"
+	"regexp"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/Zipklas/anime-site-backend/internal/auth"
 	"github.com/Zipklas/anime-site-backend/internal/shikimori"
-	"github.com/golang-jwt/jwt/v4"
+	"github.com/Zipklas/anime-site-backend/pkg/mailer"
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
 )
 
 type Service interface {
 	Register(email, password string) error
-	Login(email, password string) (string, error)
+	Login(email, password string, meta SessionMeta) (*TokenPair, error)
+	Refresh(refreshToken string, meta SessionMeta) (*TokenPair, error)
+	ValidateSession(sessionID, userID string) (role string, err error)
+	Logout(userID, sessionID string) error
+	LogoutAll(userID string) error
+	ListSessions(userID string) ([]Session, error)
+	RevokeSession(userID, sessionID string) error
+	ResendVerification(email string) error
+	VerifyEmail(token string) error
+	ForgotPassword(email string) error
+	ResetPassword(token, password string) error
+	ListUsers(role string, limit, offset int) ([]User, error)
+	SetRole(actorID, userID, role string) error
+	PromoteAdmins(emails []string) error
+	ShikimoriAuthURL(purpose, userID string) (string, error)
+	LoginWithShikimori(ctx context.Context, code, state string, meta SessionMeta) (*TokenPair, error)
+	LinkShikimori(ctx context.Context, userID, code, state string) (*ShikimoriAccount, error)
+	GetShikimoriAccount(userID string) (*ShikimoriAccount, error)
+	UnlinkShikimori(userID string) error
+	ImportShikimori(userID string) error
+	SetShikimoriPush(userID string, enabled bool) error
+	GetShikimoriSyncStatus(userID string) (*ShikimoriSyncStatus, error)
+	StartListImport(userID string, data []byte, overwrite bool) (*ListImportJob, error)
+	GetListImport(userID, jobID string) (*ListImportJob, error)
+	FailInterruptedImports() (int64, error)
+	ExportList(ctx context.Context, userID, format string) ([]byte, error)
 	GetProfile(userID string) (*User, error)
+	SetUsername(userID, username string) (string, error)
 	AddWatched(userID, animeID string) error
 	AddFavorite(userID, animeID string) error
-	GetAnimeLists(userID string) (watched []shikimori.Anime, favorites []shikimori.Anime, err error)
-	GetWatchedAnimeDetails(userID string) ([]shikimori.Anime, error)
-	GetFavouriteAnimeDetails(userID string) ([]shikimori.Anime, error)
+	GetAnimeLists(ctx context.Context, userID string) (watched *AnimeListDetails, favorites *AnimeListDetails, err error)
+	GetWatchedAnimeDetails(ctx context.Context, userID string) (*AnimeListDetails, error)
+	GetFavouriteAnimeDetails(ctx context.Context, userID string) (*AnimeListDetails, error)
+	GetListIDs(userID string) (watched []string, favorites []string, err error)
+	GetList(userID, status string) ([]AnimeEntry, error)
+	GetListEntry(userID, animeID string) (*AnimeEntry, error)
+	SaveListEntry(userID, animeID string, update AnimeEntryUpdate) (*AnimeEntry, error)
+	RemoveListEntry(userID, animeID string) error
+	RemoveWatched(userID, animeID string) error
+	RemoveFavorite(userID, animeID string) error
+	ReorderFavorites(userID string, animeIDs []string) error
 }
 
+var (
+	ErrInvalidListEntry = errors.New("invalid list entry")
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrEmailTaken       = errors.New("email is already registered")
+	ErrWeakPassword     = errors.New("password must be at least 8 characters long")
+	ErrInvalidRole      = errors.New("unknown role")
+	ErrUserNotFound     = errors.New("user not found")
+	ErrOwnRoleChange    = errors.New("administrators cannot change their own role")
+	ErrInvalidUsername  = errors.New("username must be 3-20 latin letters, digits or underscores")
+	ErrUsernameTaken    = errors.New("username is already taken")
+)
+
+const (
+	maxNotesLength = 2000
+
+	defaultRefreshTokenTTL = 30 * 24 * time.Hour
+	// Как часто обновлять last_seen_at сессии
+	sessionTouchInterval = time.Minute
+
+	minPasswordLength   = 8
+	verifyEmailTokenTTL = 48 * time.Hour
+	resetTokenTTL       = time.Hour
+)
+
 type service struct {
 	repo             Repository
 	shikimoriService *shikimori.Service
+	mailer           mailer.Mailer
+	tokens           *auth.Manager
+	oauth            *shikimori.OAuthClient
+	syncLocks        sync.Map
 }
 
-func NewService(repo Repository, shikimoriService *shikimori.Service) Service {
+func NewService(repo Repository, shikimoriService *shikimori.Service, mailer mailer.Mailer, tokens *auth.Manager, oauth *shikimori.OAuthClient) Service {
 	return &service{
 		repo:             repo,
 		shikimoriService: shikimoriService,
+		mailer:           mailer,
+		tokens:           tokens,
+		oauth:            oauth,
 	}
 }
 
-func (s *service) GetAnimeLists(userID string) ([]shikimori.Anime, []shikimori.Anime, error) {
-	user, err := s.repo.FindByID(userID)
+// AnimeListDetails - аниме из списка пользователя в порядке списка.
+// FailedIDs содержит ID, которые не удалось получить из Shikimori.
+type AnimeListDetails struct {
+	Items     []shikimori.Anime `json:"items"`
+	FailedIDs []string          `json:"failed_ids"`
+}
+
+func (s *service) GetAnimeLists(ctx context.Context, userID string) (*AnimeListDetails, *AnimeListDetails, error) {
+	watchedIDs, favoriteIDs, err := s.GetListIDs(userID)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var watched []shikimori.Anime
-	for _, id := range user.WatchedAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), id)
-		if err == nil {
-			watched = append(watched, *anime)
-		}
-	}
+	return s.fetchDetails(ctx, watchedIDs), s.fetchDetails(ctx, favoriteIDs), nil
+}
 
-	var favorites []shikimori.Anime
-	for _, id := range user.FavoriteAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), id)
-		if err == nil {
-			favorites = append(favorites, *anime)
-		}
+func (s *service) fetchDetails(ctx context.Context, ids []string) *AnimeListDetails {
+	if len(ids) == 0 {
+		return &AnimeListDetails{Items: []shikimori.Anime{}, FailedIDs: []string{}}
 	}
 
-	return watched, favorites, nil
+	items, failed := s.shikimoriService.FetchAnimesByIDs(ctx, ids)
+	if failed == nil {
+		failed = []string{}
+	}
+	return &AnimeListDetails{Items: items, FailedIDs: failed}
 }
 
 func (s *service) Register(email, password string) error {
+	email, err := normalizeEmail(email)
+	if err != nil {
+		return err
+	}
+	if len([]rune(password)) < minPasswordLength {
+		return ErrWeakPassword
+	}
+	if _, err := s.repo.FindByEmail(email); err == nil {
+		return ErrEmailTaken
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
 	// Генерация UUID для нового пользователя
 	id := uuid.New()
 
-	hash, _ := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
 	user := &User{
 		ID:       id,
 		Email:    email,
 		Password: string(hash),
 	}
-	return s.repo.Create(user)
+	if err := s.repo.Create(user); err != nil {
+		return err
+	}
+
+	s.sendVerification(user)
+	return nil
 }
 
-func (s *service) Login(email, password string) (string, error) {
-	user, err := s.repo.FindByEmail(email)
+func (s *service) Login(email, password string, meta SessionMeta) (*TokenPair, error) {
+	user, err := s.repo.FindByEmail(canonicalEmail(email))
 	if err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return "", errors.New("invalid credentials")
+		return nil, errors.New("invalid credentials")
 	}
 
-	// Генерация JWT токена
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": user.ID,
-		"email":   user.Email,
-		"exp":     time.Now().Add(time.Hour * 72).Unix(), // токен действителен 72 часа
-	})
+	return s.startSession(user, meta)
+}
+
+// startSession создает новую сессию и выдает для нее пару токенов
+func (s *service) startSession(user *User, meta SessionMeta) (*TokenPair, error) {
+	refreshToken, stored, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         uuid.New(),
+		UserID:     user.ID,
+		UserAgent:  meta.UserAgent,
+		IP:         meta.IP,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(refreshTokenTTL()),
+	}
+	if err := s.repo.CreateSession(session, stored); err != nil {
+		return nil, err
+	}
+
+	return s.issueTokens(user, session, refreshToken)
+}
+
+// Refresh обменивает refresh-токен на новую пару токенов той же сессии
+func (s *service) Refresh(refreshToken string, meta SessionMeta) (*TokenPair, error) {
+	if refreshToken == "" {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	nextToken, stored, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := s.repo.RotateRefreshToken(hashToken(refreshToken), stored, meta)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.repo.FindByID(session.UserID.String())
+	if err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.issueTokens(user, session, nextToken)
+}
 
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		return "", errors.New("JWT secret is not set")
+// ValidateSession проверяет, что сессия access-токена не отозвана, и
+// возвращает текущую роль пользователя: смена роли действует сразу,
+// не дожидаясь истечения токена
+func (s *service) ValidateSession(sessionID, userID string) (string, error) {
+	session, err := s.repo.GetActiveSession(sessionID)
+	if err != nil {
+		return "", err
+	}
+	if session.UserID.String() != userID {
+		return "", ErrSessionNotFound
 	}
-	log.Println("JWT_SECRET:", os.Getenv("JWT_SECRET"))
-	tokenString, err := token.SignedString([]byte(secret))
+
+	role, err := s.repo.GetRole(userID)
 	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", ErrSessionNotFound
+		}
 		return "", err
 	}
-	log.Println("Generated Token:", tokenString)
 
-	return tokenString, nil
+	// Не пишем в базу на каждый запрос
+	if now := time.Now(); now.Sub(session.LastSeenAt) > sessionTouchInterval {
+		if err := s.repo.TouchSession(sessionID, now); err != nil {
+			log.Printf("Failed to update session %s: %v", sessionID, err)
+		}
+	}
+	return role, nil
+}
+
+func (s *service) Logout(userID, sessionID string) error {
+	return s.repo.RevokeSession(userID, sessionID)
 }
+
+func (s *service) LogoutAll(userID string) error {
+	return s.repo.RevokeAllSessions(userID)
+}
+
+func (s *service) ListSessions(userID string) ([]Session, error) {
+	return s.repo.ListSessions(userID)
+}
+
+func (s *service) RevokeSession(userID, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+	return s.repo.RevokeSession(userID, sessionID)
+}
+
+func (s *service) issueTokens(user *User, session *Session, refreshToken string) (*TokenPair, error) {
+	// Генерация JWT токена
+	tokenString, err := s.tokens.Sign(auth.Claims{
+		UserID:    user.ID.String(),
+		Email:     user.Email,
+		Role:      user.Role,
+		SessionID: session.ID.String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:  tokenString,
+		RefreshToken: refreshToken,
+		ExpiresIn:    int(s.tokens.TTL().Seconds()),
+	}, nil
+}
+
 func (s *service) GetProfile(userID string) (*User, error) {
 	user, err := s.repo.FindByID(userID)
 	if err != nil {
@@ -112,59 +304,308 @@ func (s *service) GetProfile(userID string) (*User, error) {
 	return user, nil
 }
 func (s *service) AddWatched(userID, animeID string) error {
-	return s.repo.UpdateWatched(userID, animeID)
+	if err := s.repo.UpdateWatched(userID, animeID); err != nil {
+		return err
+	}
+	s.pushListChange(userID, animeID, nil)
+	return nil
 }
 
 func (s *service) AddFavorite(userID, animeID string) error {
 	return s.repo.UpdateFavorites(userID, animeID)
 }
-func (s *service) GetWatchedAnimeDetails(userID string) ([]shikimori.Anime, error) {
-	// Получаем пользователя с его списком просмотренных аниме
-	user, err := s.repo.FindByID(userID)
+
+func (s *service) RemoveWatched(userID, animeID string) error {
+	rateID := s.entryRateID(userID, animeID)
+	if err := s.repo.RemoveWatched(userID, animeID); err != nil {
+		return err
+	}
+	s.pushListChange(userID, animeID, rateID)
+	return nil
+}
+
+func (s *service) RemoveFavorite(userID, animeID string) error {
+	return s.repo.RemoveFavorite(userID, animeID)
+}
+
+func (s *service) ReorderFavorites(userID string, animeIDs []string) error {
+	if len(animeIDs) == 0 {
+		return fmt.Errorf("%w: anime_ids cannot be empty", ErrInvalidListEntry)
+	}
+	seen := make(map[string]bool, len(animeIDs))
+	for _, animeID := range animeIDs {
+		if seen[animeID] {
+			return fmt.Errorf("%w: duplicate anime_id %s", ErrInvalidListEntry, animeID)
+		}
+		seen[animeID] = true
+	}
+	return s.repo.ReorderFavorites(userID, animeIDs)
+}
+func (s *service) GetWatchedAnimeDetails(ctx context.Context, userID string) (*AnimeListDetails, error) {
+	// Получаем список просмотренных аниме пользователя
+	ids, err := s.repo.GetWatchedIDs(userID)
 	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
+		return nil, fmt.Errorf("failed to load watched list: %w", err)
 	}
 
-	// Если список пуст, возвращаем пустой массив
-	if len(user.WatchedAnimeIDs) == 0 {
-		return []shikimori.Anime{}, nil
+	// Получаем информацию об аниме из Shikimori API
+	return s.fetchDetails(ctx, ids), nil
+}
+func (s *service) GetFavouriteAnimeDetails(ctx context.Context, userID string) (*AnimeListDetails, error) {
+	// Получаем список избранных аниме пользователя
+	ids, err := s.repo.GetFavoriteIDs(userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load favorite list: %w", err)
 	}
 
 	// Получаем информацию об аниме из Shikimori API
-	var animeList []shikimori.Anime
-	for _, animeID := range user.WatchedAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), animeID)
-		if err != nil {
-			log.Printf("Failed to get anime %s: %v", animeID, err)
-			continue // Пропускаем если не удалось получить
-		}
-		animeList = append(animeList, *anime)
+	return s.fetchDetails(ctx, ids), nil
+}
+
+func (s *service) GetListIDs(userID string) ([]string, []string, error) {
+	watched, err := s.repo.GetWatchedIDs(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	favorites, err := s.repo.GetFavoriteIDs(userID)
+	if err != nil {
+		return nil, nil, err
 	}
+	return watched, favorites, nil
+}
 
-	return animeList, nil
+func (s *service) GetList(userID, status string) ([]AnimeEntry, error) {
+	if status != "" && !validStatuses[status] {
+		return nil, fmt.Errorf("%w: unknown status %q", ErrInvalidListEntry, status)
+	}
+	entries, err := s.repo.GetEntries(userID, status)
+	if err != nil {
+		return nil, err
+	}
+	if entries == nil {
+		entries = []AnimeEntry{}
+	}
+	return entries, nil
 }
-func (s *service) GetFavouriteAnimeDetails(userID string) ([]shikimori.Anime, error) {
-	// Получаем пользователя с его списком просмотренных аниме
-	user, err := s.repo.FindByID(userID)
+
+func (s *service) GetListEntry(userID, animeID string) (*AnimeEntry, error) {
+	return s.repo.GetEntry(userID, animeID)
+}
+
+func (s *service) SaveListEntry(userID, animeID string, update AnimeEntryUpdate) (*AnimeEntry, error) {
+	if err := validateEntryUpdate(update); err != nil {
+		return nil, err
+	}
+	entry, err := s.repo.SaveEntry(userID, animeID, update)
 	if err != nil {
-		return nil, fmt.Errorf("user not found: %w", err)
+		return nil, err
 	}
+	s.pushListChange(userID, animeID, entry.ShikimoriRateID)
+	return entry, nil
+}
 
-	// Если список пуст, возвращаем пустой массив
-	if len(user.FavoriteAnimeIDs) == 0 {
-		return []shikimori.Anime{}, nil
+func (s *service) RemoveListEntry(userID, animeID string) error {
+	rateID := s.entryRateID(userID, animeID)
+	if err := s.repo.DeleteEntry(userID, animeID); err != nil {
+		return err
 	}
+	s.pushListChange(userID, animeID, rateID)
+	return nil
+}
 
-	// Получаем информацию об аниме из Shikimori API
-	var animeList []shikimori.Anime
-	for _, animeID := range user.FavoriteAnimeIDs {
-		anime, err := s.shikimoriService.GetAnimeByID(context.Background(), animeID)
-		if err != nil {
-			log.Printf("Failed to get anime %s: %v", animeID, err)
-			continue // Пропускаем если не удалось получить
+// entryRateID возвращает связь записи с Shikimori до ее удаления
+func (s *service) entryRateID(userID, animeID string) *int64 {
+	entry, err := s.repo.GetEntry(userID, animeID)
+	if err != nil {
+		return nil
+	}
+	return entry.ShikimoriRateID
+}
+
+func validateEntryUpdate(update AnimeEntryUpdate) error {
+	if !validStatuses[update.Status] {
+		return fmt.Errorf("%w: unknown status %q", ErrInvalidListEntry, update.Status)
+	}
+	if update.Score != nil && (*update.Score < 1 || *update.Score > 10) {
+		return fmt.Errorf("%w: score must be between 1 and 10", ErrInvalidListEntry)
+	}
+	if update.EpisodesWatched < 0 {
+		return fmt.Errorf("%w: episodes_watched cannot be negative", ErrInvalidListEntry)
+	}
+	if update.RewatchCount < 0 {
+		return fmt.Errorf("%w: rewatch_count cannot be negative", ErrInvalidListEntry)
+	}
+	if update.StartedAt != nil && update.FinishedAt != nil && update.FinishedAt.Before(*update.StartedAt) {
+		return fmt.Errorf("%w: finished_at is before started_at", ErrInvalidListEntry)
+	}
+	if len([]rune(update.Notes)) > maxNotesLength {
+		return fmt.Errorf("%w: notes are too long", ErrInvalidListEntry)
+	}
+	return nil
+}
+
+// ResendVerification повторно отправляет письмо подтверждения.
+// Ответ не зависит от существования аккаунта.
+func (s *service) ResendVerification(email string) error {
+	user, err := s.repo.FindByEmail(canonicalEmail(email))
+	if err != nil || user.EmailVerifiedAt != nil {
+		return nil
+	}
+	s.sendVerification(user)
+	return nil
+}
+
+func (s *service) VerifyEmail(token string) error {
+	tokenID, err := parseUserToken(token, TokenPurposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	return s.repo.VerifyEmail(tokenID)
+}
+
+// ForgotPassword отправляет ссылку для сброса пароля.
+// Ответ не зависит от существования аккаунта.
+func (s *service) ForgotPassword(email string) error {
+	user, err := s.repo.FindByEmail(canonicalEmail(email))
+	if err != nil {
+		return nil
+	}
+
+	link, err := s.createTokenLink(user, TokenPurposeResetPassword, resetTokenTTL, "/reset-password")
+	if err != nil {
+		return err
+	}
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Сброс пароля",
+		Body: "Чтобы задать новый пароль, перейдите по ссылке:\n" + link +
+			"\n\nСсылка действует 1 час. Если вы не запрашивали сброс, просто проигнорируйте это письмо.",
+	})
+	return nil
+}
+
+func (s *service) ResetPassword(token, password string) error {
+	tokenID, err := parseUserToken(token, TokenPurposeResetPassword)
+	if err != nil {
+		return err
+	}
+	if len([]rune(password)) < minPasswordLength {
+		return ErrWeakPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return s.repo.ResetPassword(tokenID, string(hash))
+}
+
+func (s *service) sendVerification(user *User) {
+	link, err := s.createTokenLink(user, TokenPurposeVerifyEmail, verifyEmailTokenTTL, "/verify-email")
+	if err != nil {
+		log.Printf("Failed to create verification token for %s: %v", user.ID, err)
+		return
+	}
+	s.sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Подтверждение email",
+		Body:    "Чтобы подтвердить адрес и получить возможность оставлять комментарии, перейдите по ссылке:\n" + link,
+	})
+}
+
+// createTokenLink сохраняет одноразовый токен и возвращает ссылку на фронтенд (APP_URL)
+func (s *service) createTokenLink(user *User, purpose string, ttl time.Duration, path string) (string, error) {
+	token := &UserToken{
+		ID:        uuid.New(),
+		UserID:    user.ID,
+		Purpose:   purpose,
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	signed, err := signUserToken(token)
+	if err != nil {
+		return "", err
+	}
+	if err := s.repo.CreateUserToken(token); err != nil {
+		return "", err
+	}
+	return strings.TrimRight(os.Getenv("APP_URL"), "/") + path + "?token=" + signed, nil
+}
+
+// sendMail отправляет письмо в фоне, чтобы не задерживать ответ
+func (s *service) sendMail(msg mailer.Message) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			log.Printf("Failed to send email to %s: %v", msg.To, err)
 		}
-		animeList = append(animeList, *anime)
+	}()
+}
+
+// usernamePattern совпадает с упоминаниями в разметке комментариев,
+// см. markup.NodeMention
+var usernamePattern = regexp.MustCompile(`^[a-z0-9_]{3,20}$`)
+
+// SetUsername задает имя для упоминаний и возвращает его в том виде,
+// в каком оно сохранено
+func (s *service) SetUsername(userID, username string) (string, error) {
+	username = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(username, "@")))
+	if !usernamePattern.MatchString(username) {
+		return "", ErrInvalidUsername
+	}
+	if err := s.repo.SetUsername(userID, username); err != nil {
+		return "", err
+	}
+	return username, nil
+}
+
+// canonicalEmail приводит адрес к виду, в котором он хранится: без
+// пробелов по краям и в нижнем регистре
+func canonicalEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func normalizeEmail(email string) (string, error) {
+	email = canonicalEmail(email)
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email || !strings.Contains(email[strings.LastIndex(email, "@"):], ".") {
+		return "", ErrInvalidEmail
+	}
+	return email, nil
+}
+
+func (s *service) ListUsers(role string, limit, offset int) ([]User, error) {
+	if role != "" && !auth.IsValidRole(role) {
+		return nil, ErrInvalidRole
+	}
+	return s.repo.ListUsers(role, limit, offset)
+}
+
+// SetRole меняет роль пользователя. Вступает в силу со следующего запроса.
+func (s *service) SetRole(actorID, userID, role string) error {
+	if !auth.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+	if _, err := uuid.Parse(userID); err != nil {
+		return ErrUserNotFound
+	}
+	if actorID == userID {
+		return ErrOwnRoleChange
 	}
 
-	return animeList, nil
+	if err := s.repo.SetRole(userID, role); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrUserNotFound
+		}
+		return err
+	}
+	log.Printf("User %s changed role of %s to %s", actorID, userID, role)
+	return nil
+}
+
+func (s *service) PromoteAdmins(emails []string) error {
+	for i := range emails {
+		emails[i] = canonicalEmail(emails[i])
+	}
+	return s.repo.PromoteAdmins(emails)
 }
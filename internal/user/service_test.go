@@ -0,0 +1,102 @@
+package user
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Zipklas/anime-site-backend/internal/auth"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+		err  error
+	}{
+		{"user@example.com", "user@example.com", nil},
+		{"  User@Example.COM ", "user@example.com", nil},
+		{"USER@EXAMPLE.COM", "user@example.com", nil},
+		{"user@localhost", "", ErrInvalidEmail},
+		{"User <user@example.com>", "", ErrInvalidEmail},
+		{"not an email", "", ErrInvalidEmail},
+		{"", "", ErrInvalidEmail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := normalizeEmail(tt.in)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Errorf("normalizeEmail(%q) = %q, %v; want %q, %v", tt.in, got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+// Вход и регистрация должны приводить адрес к одному виду
+func TestCanonicalEmailMatchesRegistration(t *testing.T) {
+	for _, in := range []string{"User@Example.com", " user@example.com\t", "USER@EXAMPLE.COM "} {
+		registered, err := normalizeEmail(in)
+		if err != nil {
+			t.Fatalf("normalizeEmail(%q): %v", in, err)
+		}
+		if login := canonicalEmail(in); login != registered {
+			t.Errorf("login looks up %q, registration stored %q", login, registered)
+		}
+	}
+}
+
+// favoritesRepo проверяет, дошел ли запрос до базы
+type favoritesRepo struct {
+	Repository
+	reordered []string
+}
+
+func (r *favoritesRepo) ReorderFavorites(userID string, animeIDs []string) error {
+	r.reordered = animeIDs
+	return nil
+}
+
+func TestReorderFavoritesValidatesIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		animeIDs []string
+		wantErr  error
+	}{
+		{"ok", []string{"1", "5", "20"}, nil},
+		{"empty", nil, ErrInvalidListEntry},
+		{"duplicate", []string{"1", "5", "1"}, ErrInvalidListEntry},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &favoritesRepo{}
+			s := &service{repo: repo}
+			err := s.ReorderFavorites("user", tt.animeIDs)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("ReorderFavorites() = %v, want %v", err, tt.wantErr)
+			}
+			if (tt.wantErr == nil) != (repo.reordered != nil) {
+				t.Errorf("repository called = %v", repo.reordered != nil)
+			}
+		})
+	}
+}
+
+func TestReorderFavoritesDuplicateIsBadRequest(t *testing.T) {
+	repo := &favoritesRepo{}
+	handler := NewHandler(&service{repo: repo})
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"anime_ids": ["1", "5", "1"]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req = req.WithContext(auth.WithUser(req.Context(), &auth.CurrentUser{ID: uuid.New(), Role: auth.RoleUser}))
+	rec := httptest.NewRecorder()
+	if err := handler.ReorderFavorites(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400: %s", rec.Code, rec.Body)
+	}
+}
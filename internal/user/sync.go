@@ -0,0 +1,366 @@
+package user
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Zipklas/anime-site-backend/internal/shikimori"
+	"github.com/google/uuid"
+)
+
+var ErrImportInProgress = errors.New("shikimori import is already running")
+
+const (
+	syncLogLimit  = 100
+	importTimeout = 30 * time.Minute
+	pushTimeout   = time.Minute
+)
+
+// ShikimoriSyncStatus - состояние синхронизации для профиля
+type ShikimoriSyncStatus struct {
+	Account       *ShikimoriAccount  `json:"account"`
+	ImportRunning bool               `json:"import_running"`
+	Logs          []ShikimoriSyncLog `json:"logs"`
+}
+
+func (s *service) GetShikimoriSyncStatus(userID string) (*ShikimoriSyncStatus, error) {
+	account, err := s.repo.GetShikimoriAccount(userID)
+	if err != nil {
+		return nil, err
+	}
+	logs, err := s.repo.ListSyncLogs(userID, syncLogLimit)
+	if err != nil {
+		return nil, err
+	}
+	return &ShikimoriSyncStatus{
+		Account:       account,
+		ImportRunning: account.ImportStartedAt != nil,
+		Logs:          logs,
+	}, nil
+}
+
+func (s *service) SetShikimoriPush(userID string, enabled bool) error {
+	return s.repo.SetShikimoriPush(userID, enabled)
+}
+
+// ImportShikimori запускает в фоне импорт списка с Shikimori.
+// Ход и итог импорта пишутся в журнал синхронизации.
+func (s *service) ImportShikimori(userID string) error {
+	account, err := s.repo.GetShikimoriAccount(userID)
+	if err != nil {
+		return err
+	}
+	started, err := s.repo.StartShikimoriImport(userID)
+	if err != nil {
+		return err
+	}
+	if !started {
+		return ErrImportInProgress
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), importTimeout)
+		defer cancel()
+
+		lock := s.syncLock(userID)
+		lock.Lock()
+		err := s.runImport(ctx, account)
+		lock.Unlock()
+
+		if err != nil {
+			log.Printf("Shikimori import for %s failed: %v", userID, err)
+			summary := newSyncLog(account.UserID, SyncDirectionImport, "")
+			summary.Result = SyncResultFailed
+			summary.Message = err.Error()
+			s.addSyncLogs([]ShikimoriSyncLog{summary})
+		}
+		if err := s.repo.FinishShikimoriImport(userID, err == nil); err != nil {
+			log.Printf("Failed to finish shikimori import for %s: %v", userID, err)
+		}
+	}()
+	return nil
+}
+
+// runImport переносит записи с Shikimori в локальный список. При конфликте
+// побеждает запись, измененная позже. Если включена отправка, локальные
+// записи, которых нет на Shikimori или которые новее, отправляются туда.
+func (s *service) runImport(ctx context.Context, account *ShikimoriAccount) error {
+	userID := account.UserID.String()
+
+	token, err := s.shikimoriAccessToken(ctx, account)
+	if err != nil {
+		return err
+	}
+	rates, err := s.oauth.ListUserRates(ctx, token, account.ShikimoriUserID)
+	if err != nil {
+		return err
+	}
+	entries, err := s.repo.GetEntries(userID, "")
+	if err != nil {
+		return err
+	}
+
+	local := make(map[string]*AnimeEntry, len(entries))
+	for i := range entries {
+		local[entries[i].AnimeID] = &entries[i]
+	}
+
+	var logs []ShikimoriSyncLog
+	counts := map[string]int{}
+	record := func(entry ShikimoriSyncLog) {
+		counts[entry.Result]++
+		logs = append(logs, entry)
+	}
+
+	seen := make(map[string]bool, len(rates))
+	for i := range rates {
+		rate := &rates[i]
+		if rate.TargetType != "" && rate.TargetType != "Anime" {
+			continue
+		}
+		animeID := strconv.FormatInt(rate.TargetID, 10)
+		seen[animeID] = true
+		entry := local[animeID]
+
+		if entry != nil && entry.Status != "" && entry.UpdatedAt.After(rate.UpdatedAt) {
+			// Локальная запись новее
+			if account.PushEnabled {
+				record(s.pushEntry(ctx, token, account, animeID, entry, &rate.ID))
+				continue
+			}
+			result := newSyncLog(account.UserID, SyncDirectionImport, animeID)
+			result.Result = SyncResultSkipped
+			result.Message = "local entry is newer"
+			record(result)
+			continue
+		}
+		record(s.applyRate(account.UserID, SyncDirectionImport, rate))
+	}
+
+	for _, entry := range entries {
+		if entry.Status == "" || seen[entry.AnimeID] {
+			continue
+		}
+		// Запись удалили на Shikimori после последней синхронизации,
+		// локальные данные при этом не трогаем
+		changedSinceImport := account.LastImportAt == nil || entry.UpdatedAt.After(*account.LastImportAt)
+		if !account.PushEnabled || (entry.ShikimoriRateID != nil && !changedSinceImport) {
+			if entry.ShikimoriRateID != nil {
+				if err := s.repo.UpdateEntrySync(userID, entry.AnimeID, map[string]interface{}{
+					"shikimori_rate_id": nil,
+					"updated_at":        entry.UpdatedAt,
+				}); err != nil {
+					return err
+				}
+				result := newSyncLog(account.UserID, SyncDirectionImport, entry.AnimeID)
+				result.Result = SyncResultSkipped
+				result.Message = "removed on shikimori"
+				record(result)
+			}
+			continue
+		}
+		record(s.pushEntry(ctx, token, account, entry.AnimeID, &entry, entry.ShikimoriRateID))
+	}
+
+	summary := newSyncLog(account.UserID, SyncDirectionImport, "")
+	summary.Result = SyncResultFinished
+	summary.Message = fmt.Sprintf("created %d, updated %d, conflicts %d, skipped %d, failed %d",
+		counts[SyncResultCreated], counts[SyncResultUpdated], counts[SyncResultConflict],
+		counts[SyncResultSkipped], counts[SyncResultFailed])
+	logs = append(logs, summary)
+	s.addSyncLogs(logs)
+	return nil
+}
+
+// pushListChange в фоне отправляет изменение записи на Shikimori, если
+// аккаунт привязан и отправка включена. rateID - связь записи до изменения,
+// нужна, если запись удалена.
+func (s *service) pushListChange(userID, animeID string, rateID *int64) {
+	account, err := s.repo.GetShikimoriAccount(userID)
+	if err != nil {
+		if !errors.Is(err, ErrShikimoriNotLinked) {
+			log.Printf("Failed to load shikimori account for %s: %v", userID, err)
+		}
+		return
+	}
+	if !account.PushEnabled {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), pushTimeout)
+		defer cancel()
+
+		// Изменения одного пользователя отправляются по очереди
+		lock := s.syncLock(userID)
+		lock.Lock()
+		defer lock.Unlock()
+
+		entry, err := s.repo.GetEntry(userID, animeID)
+		if err != nil && !errors.Is(err, ErrEntryNotFound) {
+			log.Printf("Failed to load list entry for shikimori push: %v", err)
+			return
+		}
+		if entry != nil && entry.ShikimoriRateID != nil {
+			rateID = entry.ShikimoriRateID
+		}
+
+		var result ShikimoriSyncLog
+		token, err := s.shikimoriAccessToken(ctx, account)
+		if err != nil {
+			result = newSyncLog(account.UserID, SyncDirectionPush, animeID)
+			result.Result = SyncResultFailed
+			result.Message = err.Error()
+		} else {
+			result = s.pushEntry(ctx, token, account, animeID, entry, rateID)
+		}
+		s.addSyncLogs([]ShikimoriSyncLog{result})
+	}()
+}
+
+// pushEntry отправляет запись на Shikimori. Запись без статуса или nil
+// удаляется с Shikimori. Если запись на Shikimori изменена позже локальной,
+// она побеждает и переносится в локальный список.
+func (s *service) pushEntry(ctx context.Context, token string, account *ShikimoriAccount, animeID string, entry *AnimeEntry, rateID *int64) ShikimoriSyncLog {
+	result := newSyncLog(account.UserID, SyncDirectionPush, animeID)
+	fail := func(err error) ShikimoriSyncLog {
+		result.Result = SyncResultFailed
+		result.Message = err.Error()
+		return result
+	}
+	userID := account.UserID.String()
+
+	if entry == nil || entry.Status == "" {
+		if rateID == nil {
+			result.Result = SyncResultSkipped
+			return result
+		}
+		if err := s.oauth.DeleteUserRate(ctx, token, *rateID); err != nil {
+			return fail(err)
+		}
+		if entry != nil {
+			if err := s.repo.UpdateEntrySync(userID, animeID, map[string]interface{}{
+				"shikimori_rate_id": nil,
+				"updated_at":        entry.UpdatedAt,
+			}); err != nil {
+				return fail(err)
+			}
+		}
+		result.Result = SyncResultDeleted
+		return result
+	}
+
+	var (
+		remote *shikimori.UserRate
+		err    error
+	)
+	if rateID != nil {
+		remote, err = s.oauth.GetUserRate(ctx, token, *rateID)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if remote == nil {
+		remote, err = s.oauth.FindUserRate(ctx, token, account.ShikimoriUserID, animeID)
+		if err != nil {
+			return fail(err)
+		}
+	}
+	if remote != nil && remote.UpdatedAt.After(entry.UpdatedAt) {
+		applied := s.applyRate(account.UserID, SyncDirectionPush, remote)
+		if applied.Result == SyncResultFailed {
+			return applied
+		}
+		result.Result = SyncResultConflict
+		result.Message = "shikimori entry is newer"
+		return result
+	}
+
+	update := shikimori.UserRateUpdate{
+		Status:   entry.Status,
+		Episodes: entry.EpisodesWatched,
+	}
+	if entry.Score != nil {
+		update.Score = *entry.Score
+	}
+	if remote == nil {
+		remote, err = s.oauth.CreateUserRate(ctx, token, account.ShikimoriUserID, animeID, update)
+		result.Result = SyncResultCreated
+	} else {
+		remote, err = s.oauth.UpdateUserRate(ctx, token, remote.ID, update)
+		result.Result = SyncResultUpdated
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	// Время изменения берем с Shikimori, чтобы запись считалась синхронной
+	if err := s.repo.UpdateEntrySync(userID, animeID, map[string]interface{}{
+		"shikimori_rate_id": remote.ID,
+		"updated_at":        remote.UpdatedAt,
+	}); err != nil {
+		return fail(err)
+	}
+	return result
+}
+
+// applyRate переносит запись Shikimori в локальный список
+func (s *service) applyRate(userID uuid.UUID, direction string, rate *shikimori.UserRate) ShikimoriSyncLog {
+	animeID := strconv.FormatInt(rate.TargetID, 10)
+	result := newSyncLog(userID, direction, animeID)
+	if !validStatuses[rate.Status] {
+		result.Result = SyncResultSkipped
+		result.Message = fmt.Sprintf("unknown status %q", rate.Status)
+		return result
+	}
+
+	var score interface{}
+	if rate.Score > 0 {
+		score = rate.Score
+	}
+	created, err := s.repo.ApplyShikimoriRate(userID.String(), animeID, map[string]interface{}{
+		"status":            rate.Status,
+		"score":             score,
+		"episodes_watched":  rate.Episodes,
+		"rewatch_count":     rate.Rewatches,
+		"shikimori_rate_id": rate.ID,
+		"updated_at":        rate.UpdatedAt,
+	})
+	switch {
+	case err != nil:
+		result.Result = SyncResultFailed
+		result.Message = err.Error()
+	case created:
+		result.Result = SyncResultCreated
+	default:
+		result.Result = SyncResultUpdated
+	}
+	return result
+}
+
+func (s *service) addSyncLogs(logs []ShikimoriSyncLog) {
+	if err := s.repo.AddSyncLogs(logs); err != nil {
+		log.Printf("Failed to save shikimori sync log: %v", err)
+	}
+}
+
+// syncLock возвращает мьютекс синхронизации пользователя
+func (s *service) syncLock(userID string) *sync.Mutex {
+	lock, _ := s.syncLocks.LoadOrStore(userID, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func newSyncLog(userID uuid.UUID, direction, animeID string) ShikimoriSyncLog {
+	return ShikimoriSyncLog{
+		ID:        uuid.New(),
+		UserID:    userID,
+		Direction: direction,
+		AnimeID:   animeID,
+		CreatedAt: time.Now(),
+	}
+}
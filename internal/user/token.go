@@ -0,0 +1,115 @@
+package user
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// newRefreshToken возвращает токен для клиента и запись для хранения в базе
+func newRefreshToken() (string, *RefreshToken, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	return token, &RefreshToken{
+		ID:        uuid.New(),
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(refreshTokenTTL()),
+	}, nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func refreshTokenTTL() time.Duration {
+	return durationFromEnv("REFRESH_TOKEN_TTL", defaultRefreshTokenTTL)
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		log.Printf("Invalid %s=%q, using %s", name, value, fallback)
+		return fallback
+	}
+	return d
+}
+
+var ErrInvalidUserToken = errors.New("invalid or expired token")
+
+type signedTokenPayload struct {
+	ID      uuid.UUID `json:"id"`
+	Purpose string    `json:"p"`
+	Expires int64     `json:"exp"`
+}
+
+// signUserToken подписывает токен из письма HMAC-SHA256 ключом EMAIL_TOKEN_SECRET.
+// Подпись не дает перебирать ID, а одноразовость обеспечивает запись в базе.
+func signUserToken(token *UserToken) (string, error) {
+	secret := os.Getenv("EMAIL_TOKEN_SECRET")
+	if secret == "" {
+		return "", errors.New("EMAIL_TOKEN_SECRET is not set")
+	}
+
+	payload, err := json.Marshal(signedTokenPayload{
+		ID:      token.ID,
+		Purpose: token.Purpose,
+		Expires: token.ExpiresAt.Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + tokenSignature(secret, encoded), nil
+}
+
+// parseUserToken проверяет подпись, назначение и срок действия токена
+func parseUserToken(token, purpose string) (uuid.UUID, error) {
+	secret := os.Getenv("EMAIL_TOKEN_SECRET")
+	if secret == "" {
+		return uuid.Nil, errors.New("EMAIL_TOKEN_SECRET is not set")
+	}
+
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(signature), []byte(tokenSignature(secret, encoded))) {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	var payload signedTokenPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	if payload.Purpose != purpose || time.Now().Unix() > payload.Expires {
+		return uuid.Nil, ErrInvalidUserToken
+	}
+	return payload.ID, nil
+}
+
+func tokenSignature(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 
 	"github.com/Zipklas/anime-site-backend/internal/comment"
+	"github.com/Zipklas/anime-site-backend/internal/notification"
 	"github.com/Zipklas/anime-site-backend/internal/user"
 
 	"gorm.io/driver/postgres"
@@ -18,7 +19,43 @@ func InitPostgres() *gorm.DB {
 		log.Fatal("Failed to connect:", err)
 	}
 
-	_ = db.AutoMigrate(&user.User{})
-	_ = db.AutoMigrate(&comment.Comment{}, &comment.CommentVote{})
+	// Подтверждение email появилось позже самих аккаунтов
+	verificationExists := db.Migrator().HasColumn(&user.User{}, "email_verified_at")
+	_ = db.AutoMigrate(&user.User{}, &user.AnimeEntry{}, &user.Session{}, &user.RefreshToken{}, &user.UserToken{}, &user.ShikimoriAccount{}, &user.OAuthState{}, &user.ShikimoriSyncLog{}, &user.ListImportJob{})
+	if !verificationExists {
+		if err := user.VerifyLegacyEmails(db); err != nil {
+			log.Fatal("Failed to verify existing emails:", err)
+		}
+	}
+	if err := user.NormalizeLegacyEmails(db); err != nil {
+		log.Fatal("Failed to normalize emails:", err)
+	}
+	if err := user.MigrateLegacyLists(db); err != nil {
+		log.Fatal("Failed to migrate anime lists:", err)
+	}
+	// Счетчики голосов появились позже самих голосов - заполняем их один раз
+	countersExist := db.Migrator().HasColumn(&comment.Comment{}, "score")
+	// Статус модерации появился позже is_approved
+	moderationStatusExists := db.Migrator().HasColumn(&comment.Comment{}, "moderation_status")
+	_ = db.AutoMigrate(&comment.Comment{}, &comment.CommentVote{}, &comment.ModerationAction{}, &comment.CommentRevision{}, &comment.CommentReport{}, &comment.ModerationJob{})
+	_ = db.AutoMigrate(&notification.Notification{}, &notification.Preference{})
+	// Новая колонка получает approved у всех комментариев, а до нее скрытые
+	// отмечались только is_approved = false - они остаются скрытыми
+	if !moderationStatusExists {
+		if err := db.Model(&comment.Comment{}).
+			Where("NOT is_approved").
+			UpdateColumn("moderation_status", comment.ModerationRejected).Error; err != nil {
+			log.Fatal("Failed to set moderation status of existing comments:", err)
+		}
+	}
+	if !countersExist {
+		result, err := comment.NewRepository(db).RecountVotes()
+		if err != nil {
+			log.Fatal("Failed to recount comment votes:", err)
+		}
+		// Голоса за свои комментарии удаляются - об этом нужно знать
+		log.Printf("Recounted comment votes: self votes removed: %d, comments fixed: %d, users fixed: %d",
+			result.SelfVotesRemoved, result.CommentsFixed, result.UsersFixed)
+	}
 	return db
 }
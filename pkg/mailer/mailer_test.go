@@ -0,0 +1,88 @@
+package mailer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBuildMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  Message
+		want []string
+		not  []string
+	}{
+		{
+			name: "headers",
+			msg:  Message{To: "user@example.com", Subject: "Hello", Body: "text"},
+			want: []string{"From: noreply@example.com\r\n", "To: user@example.com\r\n", "Subject: Hello\r\n", "\r\n\r\ntext"},
+		},
+		{
+			name: "header injection",
+			msg:  Message{To: "user@example.com\r\nBcc: victim@example.com", Subject: "Hi\nBcc: other@example.com", Body: "text"},
+			not:  []string{"\r\nBcc:", "\nBcc:"},
+		},
+		{
+			name: "utf-8 subject",
+			msg:  Message{To: "user@example.com", Subject: "Подтвердите почту", Body: "text"},
+			want: []string{"Subject: =?utf-8?q?"},
+		},
+		{
+			name: "line endings",
+			msg:  Message{To: "user@example.com", Subject: "s", Body: "a\nb\r\nc\rd"},
+			want: []string{"a\r\nb\r\nc\r\nd"},
+			not:  []string{"\r\r\n"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(buildMessage("noreply@example.com", tt.msg))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("message has no %q:\n%s", want, got)
+				}
+			}
+			for _, bad := range tt.not {
+				if strings.Contains(got, bad) {
+					t.Errorf("message contains %q:\n%s", bad, got)
+				}
+			}
+		})
+	}
+}
+
+func TestLogMailerAppendsToFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	m := NewLogMailer(path)
+	for _, to := range []string{"a@example.com", "b@example.com"} {
+		if err := m.Send(context.Background(), Message{To: to, Subject: "s", Body: "body"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"To: a@example.com", "To: b@example.com"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("mail log has no %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestSMTPMailerRequiresConfig(t *testing.T) {
+	tests := []SMTPConfig{
+		{},
+		{Host: "smtp.example.com"},
+		{From: "noreply@example.com"},
+	}
+	for _, cfg := range tests {
+		if err := NewSMTPMailer(cfg).Send(context.Background(), Message{To: "user@example.com"}); err == nil {
+			t.Errorf("Send with %+v succeeded", cfg)
+		}
+	}
+}